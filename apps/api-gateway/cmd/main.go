@@ -1,17 +1,28 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 
+	"github.com/indulgeback/telos/apps/api-gateway/internal/admin"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
 	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/capture"
 	"github.com/indulgeback/telos/apps/api-gateway/internal/config"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/ipfilter"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/maintenance"
 	apimiddleware "github.com/indulgeback/telos/apps/api-gateway/internal/middleware"
 	"github.com/indulgeback/telos/apps/api-gateway/internal/proxy"
 	"github.com/indulgeback/telos/apps/api-gateway/internal/service"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/tlsconfig"
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
 	"github.com/indulgeback/telos/pkg/tlog"
 )
 
@@ -45,61 +56,248 @@ func main() {
 
 	// 初始化服务发现和负载均衡
 	lb := service.NewConsistentHashLoadBalancer()
-	discovery := service.NewRegistryServiceDiscovery(cfg.RegistryServiceURL, lb)
+	// 通过 registry 的长轮询接口监听实例变化，registry 不支持或监听失败时退回定时轮询
+	discovery := service.NewRegistryServiceDiscoveryWithConfig(cfg.RegistryServiceURL, lb, service.RegistryConfig{
+		RefreshInterval: time.Duration(cfg.RegistryRefreshSeconds) * time.Second,
+		WatchWait:       time.Duration(cfg.RegistryWatchSeconds) * time.Second,
+	})
+
+	// API Key 存储（可选），供脚本和 CI 通过 Authorization: Bearer 调用
+	var apiKeyStore gatewayauth.APIKeyStore
+	if cfg.APIKeysFile != "" {
+		store, err := gatewayauth.NewFileAPIKeyStore(cfg.APIKeysFile)
+		if err != nil {
+			tlog.Error("加载 API Key 文件失败", "error", err, "path", cfg.APIKeysFile)
+			os.Exit(1)
+		}
+		apiKeyStore = store
+	}
+
+	// 身份头签名密钥环（可选），支持多密钥轮换
+	var signingKeys *gatewayidentity.Keyring
+	if cfg.SigningKeysFile != "" {
+		keys, err := gatewayidentity.LoadKeyringFile(cfg.SigningKeysFile)
+		if err != nil {
+			tlog.Error("加载签名密钥文件失败", "error", err, "path", cfg.SigningKeysFile)
+			os.Exit(1)
+		}
+		signingKeys = keys
+	}
+
+	// OIDC/JWT Bearer 认证（可选），配置 JWKS 地址或 Issuer 后启用，Issuer 与 Audience 必填
+	var jwtConfig *gatewayauth.JWTConfig
+	if cfg.JWTJWKSURL != "" || cfg.JWTIssuer != "" {
+		jwtConfig = &gatewayauth.JWTConfig{
+			JWKSURL:   cfg.JWTJWKSURL,
+			Issuer:    cfg.JWTIssuer,
+			Audiences: cfg.JWTAudiences,
+		}
+		if err := jwtConfig.Validate(); err != nil {
+			tlog.Error("JWT 认证配置无效，需要同时设置 JWT_ISSUER 和 JWT_AUDIENCE", "error", err)
+			os.Exit(1)
+		}
+	}
 
 	authenticator := gatewayauth.NewAuthenticator(gatewayauth.Config{
 		BetterAuthBaseURL:     cfg.BetterAuthBaseURL,
 		BetterAuthSessionPath: cfg.BetterAuthSessionPath,
 		GatewayInternalSecret: cfg.GatewayInternalSecret,
 		CacheTTL:              time.Duration(cfg.AuthCacheTTLSeconds) * time.Second,
+		CacheSize:             cfg.AuthCacheSize,
+		SessionCookieNames:    cfg.AuthSessionCookies,
 		ClockSkew:             time.Duration(cfg.AuthClockSkewSeconds) * time.Second,
+		APIKeyStore:           apiKeyStore,
+		SigningKeys:           signingKeys,
+		SigningKeyID:          cfg.SigningKeyID,
+		SignatureVersion:      cfg.SignatureVersion,
+		ClaimPaths:            cfg.AuthClaimPaths,
+		PropagateClaims:       cfg.AuthPropagateClaims,
+		JWT:                   jwtConfig,
+		NegativeCacheTTL:      time.Duration(cfg.AuthNegativeCacheSeconds) * time.Second,
+		BreakerThreshold:      cfg.AuthBreakerThreshold,
+		BreakerCooldown:       time.Duration(cfg.AuthBreakerCooldownSeconds) * time.Second,
 	})
+	defer authenticator.Stop()
 
 	// 初始化代理管理器
 	proxyManager := proxy.NewProxyManager(discovery, authenticator)
+	proxyManager.SetDigestLimit(cfg.DigestMaxBytes)
+	proxyManager.SetDigestSpillLimit(cfg.DigestSpillBytes)
+	proxyManager.SetConcurrencyLimiter(apimiddleware.NewConcurrencyLimiter(
+		cfg.UserConcurrencyLimit,
+		time.Duration(cfg.UserConcurrencyQueueMs)*time.Millisecond,
+	))
+	// IP 访问控制：客户端 IP 只信任 TRUSTED_PROXIES 转发的 X-Forwarded-For
+	clientIPs, err := ipfilter.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		tlog.Error("加载可信代理配置失败", "error", err)
+		os.Exit(1)
+	}
+	var geo ipfilter.GeoLookup
+	if len(cfg.GeoIPDatabases) > 0 {
+		geoDB, err := ipfilter.OpenGeoDB(cfg.GeoIPDatabases...)
+		if err != nil {
+			tlog.Error("加载 GeoIP 数据库失败", "error", err)
+			os.Exit(1)
+		}
+		geo = geoDB
+	}
+	globalIPFilter, err := ipfilter.Compile(ipfilter.Rules{
+		Allow:          cfg.IPAllow,
+		Deny:           cfg.IPDeny,
+		AllowFiles:     cfg.IPAllowFiles,
+		DenyFiles:      cfg.IPDenyFiles,
+		AllowCountries: cfg.IPAllowCountries,
+		DenyCountries:  cfg.IPDenyCountries,
+		AllowASNs:      cfg.IPAllowASNs,
+		DenyASNs:       cfg.IPDenyASNs,
+	})
+	if err != nil {
+		tlog.Error("加载 IP 访问规则失败", "error", err)
+		os.Exit(1)
+	}
+	if globalIPFilter.NeedsGeo() && geo == nil {
+		tlog.Error("IP 访问规则包含国家/ASN 条件，但未配置 GEOIP_DATABASES")
+		os.Exit(1)
+	}
+	proxyManager.SetIPFilter(clientIPs, geo)
+
+	// 上游 TLS（可选）：注册元数据 scheme=https 的实例按服务使用指定的 CA、客户端证书和 SNI
+	if cfg.UpstreamTLSFile != "" {
+		options, err := tlsconfig.LoadUpstreamFile(cfg.UpstreamTLSFile)
+		if err != nil {
+			tlog.Error("加载上游 TLS 配置失败", "error", err, "path", cfg.UpstreamTLSFile)
+			os.Exit(1)
+		}
+		upstreamTLS, err := tlsconfig.NewUpstreamConfigs(options)
+		if err != nil {
+			tlog.Error("加载上游 TLS 配置失败", "error", err, "path", cfg.UpstreamTLSFile)
+			os.Exit(1)
+		}
+		proxyManager.SetUpstreamTLS(upstreamTLS)
+	}
+
+	// 上游协议：agent-service 等长连接流服务可用 h2/h2c 在少量连接上复用
+	upstreamProtocols := make(map[string]proxy.UpstreamProtocol, len(cfg.UpstreamProtocols))
+	for serviceName, value := range cfg.UpstreamProtocols {
+		protocol, err := proxy.ParseUpstreamProtocol(value)
+		if err != nil {
+			tlog.Error("加载上游协议配置失败", "error", err, "service", serviceName)
+			os.Exit(1)
+		}
+		upstreamProtocols[serviceName] = protocol
+	}
+	proxyManager.SetUpstreamTransport(proxy.UpstreamTransportConfig{
+		Protocols:           upstreamProtocols,
+		MaxConnsPerHost:     cfg.UpstreamMaxConnsPerHost,
+		MaxIdleConnsPerHost: cfg.UpstreamMaxIdleConnsPerHost,
+	})
+
+	// 后端的 5xx 错误页转换为统一错误格式，原始响应体截断后记录到日志
+	var upstreamErrors *proxy.UpstreamErrorConfig
+	if !cfg.UpstreamErrorPassthrough {
+		upstreamErrors = &proxy.UpstreamErrorConfig{
+			Pages:        make(map[int]string, len(cfg.UpstreamErrorPages)),
+			LogBodyBytes: cfg.UpstreamErrorLogBytes,
+		}
+		for status, path := range cfg.UpstreamErrorPages {
+			code, err := strconv.Atoi(status)
+			if err != nil {
+				tlog.Error("加载自定义错误页配置失败", "status", status, "error", err)
+				os.Exit(1)
+			}
+			upstreamErrors.Pages[code] = path
+		}
+	}
+
+	// 管理类路由只允许办公网/VPN 访问（未配置时不限制）
+	var adminIPRules *ipfilter.Rules
+	if len(cfg.AdminIPAllow) > 0 || len(cfg.AdminIPAllowFiles) > 0 {
+		adminIPRules = &ipfilter.Rules{Allow: cfg.AdminIPAllow, AllowFiles: cfg.AdminIPAllowFiles}
+	}
+	// MCP 服务器会在后端执行外部进程，写操作仅限管理员角色（默认 admin）
+	mcpServerPolicy := proxy.WriteRolesPolicy(cfg.MCPAdminRoles)
+
+	// 会话 Cookie 认证的写请求需通过来源校验（可选双重提交令牌）
+	proxyManager.SetCSRF(proxy.CSRFConfig{
+		TrustedOrigins: cfg.CSRFTrustedOrigins,
+		DoubleSubmit:   cfg.CSRFDoubleSubmit,
+		CookieDomain:   cfg.CSRFCookieDomain,
+	})
+
+	// agent-service 的接口均接收 JSON 对象
+	jsonContentTypes := []string{"application/json"}
+	jsonObjectSchema := json.RawMessage(`{"type":"object"}`)
+	// 受保护路由同时接受浏览器会话、API Key 和（启用时的）JWT
+	authMethods := []gatewayauth.Method{gatewayauth.MethodSession, gatewayauth.MethodAPIKey}
+	if jwtConfig != nil {
+		authMethods = append(authMethods, gatewayauth.MethodJWT)
+	}
 
 	// 加载路由配置
 	// 注意：更具体的路由应该放在前面，避免前缀匹配冲突
 	routes := []proxy.RouteConfig{
 		{
 			// 工具管理 API - 必须在 /api/agent 之前，避免前缀匹配冲突
-			Path:        "/api/tools",
-			ServiceName: "agent-service",
-			StripPrefix: false,
-			Timeout:     10,
-			AuthMode:    proxy.AuthModeRequired,
+			Path:                "/api/tools",
+			ServiceName:         "agent-service",
+			StripPrefix:         false,
+			Timeout:             10,
+			AuthMode:            proxy.AuthModeRequired,
+			AuthMethods:         authMethods,
+			MaxBodyBytes:        1 << 20,
+			AllowedContentTypes: jsonContentTypes,
+			RequestSchema:       jsonObjectSchema,
 		},
 		{
 			// Agent 管理 API
-			Path:        "/api/agents",
-			ServiceName: "agent-service",
-			StripPrefix: false,
-			Timeout:     10,
-			AuthMode:    proxy.AuthModeRequired,
+			Path:                "/api/agents",
+			ServiceName:         "agent-service",
+			StripPrefix:         false,
+			Timeout:             10,
+			AuthMode:            proxy.AuthModeRequired,
+			AuthMethods:         authMethods,
+			MaxBodyBytes:        1 << 20,
+			AllowedContentTypes: jsonContentTypes,
+			RequestSchema:       jsonObjectSchema,
 		},
 		{
 			// Skill 管理 API
-			Path:        "/api/skills",
-			ServiceName: "agent-service",
-			StripPrefix: false,
-			Timeout:     10,
-			AuthMode:    proxy.AuthModeRequired,
+			Path:                "/api/skills",
+			ServiceName:         "agent-service",
+			StripPrefix:         false,
+			Timeout:             10,
+			AuthMode:            proxy.AuthModeRequired,
+			AuthMethods:         authMethods,
+			MaxBodyBytes:        1 << 20,
+			AllowedContentTypes: jsonContentTypes,
+			RequestSchema:       jsonObjectSchema,
 		},
 		{
 			// MCP Server 管理 API
-			Path:        "/api/mcp-servers",
-			ServiceName: "agent-service",
-			StripPrefix: false,
-			Timeout:     30,
-			AuthMode:    proxy.AuthModeRequired,
+			Path:                "/api/mcp-servers",
+			ServiceName:         "agent-service",
+			StripPrefix:         false,
+			Timeout:             30,
+			AuthMode:            proxy.AuthModeRequired,
+			AuthMethods:         authMethods,
+			MaxBodyBytes:        1 << 20,
+			AllowedContentTypes: jsonContentTypes,
+			RequestSchema:       jsonObjectSchema,
+			IPFilter:            adminIPRules,
+			Policy:              mcpServerPolicy,
 		},
 		{
 			// Agent Run Trace API
-			Path:        "/api/runs",
-			ServiceName: "agent-service",
-			StripPrefix: false,
-			Timeout:     30,
-			AuthMode:    proxy.AuthModeRequired,
+			Path:                "/api/runs",
+			ServiceName:         "agent-service",
+			StripPrefix:         false,
+			Timeout:             30,
+			AuthMode:            proxy.AuthModeRequired,
+			AuthMethods:         authMethods,
+			MaxBodyBytes:        1 << 20,
+			AllowedContentTypes: jsonContentTypes,
+			RequestSchema:       jsonObjectSchema,
 		},
 		{
 			// 聊天 API - 必须放在最后，因为 /api/agent 是 /api/agents 的前缀
@@ -108,28 +306,191 @@ func main() {
 			StripPrefix: false,
 			Timeout:     60,
 			AuthMode:    proxy.AuthModeRequired,
+			AuthMethods: authMethods,
+			// 聊天请求可能携带图片，放宽请求体上限
+			MaxBodyBytes:        20 << 20,
+			AllowedContentTypes: jsonContentTypes,
+			RequestSchema:       jsonObjectSchema,
+			// 流式对话会长时间占用 agent-service worker，单独限制每用户并发数
+			MaxConcurrency: 4,
 		},
 	}
-	proxyManager.LoadRoutes(routes)
+	for i := range routes {
+		routes[i].UpstreamErrors = upstreamErrors
+	}
+	// gRPC 服务按全名路由，如 /telos.agent.v1.AgentService/Chat；浏览器通过 gRPC-Web 调用时同样使用会话 Cookie
+	var grpcPaths []string
+	for grpcService, serviceName := range cfg.GRPCRoutes {
+		path := "/" + strings.Trim(grpcService, "/") + "/"
+		grpcPaths = append(grpcPaths, path)
+		routes = append(routes, proxy.RouteConfig{
+			Path:        path,
+			ServiceName: serviceName,
+			Mode:        proxy.RouteModeGRPC,
+			AuthMode:    proxy.AuthModeRequired,
+			AuthMethods: authMethods,
+		})
+	}
+	if err := proxyManager.LoadRoutes(routes); err != nil {
+		tlog.Error("加载路由配置失败", "error", err)
+		os.Exit(1)
+	}
+
+	// 维护开关：启动状态来自状态文件（存在时）或环境变量，运行时通过 /_gateway/maintenance 或修改状态文件切换
+	routePaths := make([]string, 0, len(routes))
+	for _, route := range routes {
+		routePaths = append(routePaths, route.Path)
+	}
+	maintenanceSwitch, err := maintenance.NewSwitch(routePaths, cfg.MaintenanceBypassUsers, cfg.MaintenanceBypassIPs)
+	if err != nil {
+		tlog.Error("加载维护配置失败", "error", err)
+		os.Exit(1)
+	}
+	maintenanceMode := maintenance.Mode{Enabled: true, Message: cfg.MaintenanceMessage, RetryAfter: cfg.MaintenanceRetryAfter}
+	if cfg.MaintenanceEnabled {
+		if err := maintenanceSwitch.Set("", maintenanceMode); err != nil {
+			tlog.Error("加载维护配置失败", "error", err)
+			os.Exit(1)
+		}
+		tlog.Warn("网关处于维护状态")
+	}
+	for _, path := range cfg.MaintenanceRoutes {
+		if err := maintenanceSwitch.Set(path, maintenanceMode); err != nil {
+			tlog.Error("加载维护配置失败", "error", err)
+			os.Exit(1)
+		}
+		tlog.Warn("路由处于维护状态", "route", path)
+	}
+	if cfg.MaintenanceFile != "" {
+		loaded, err := maintenanceSwitch.UseFile(cfg.MaintenanceFile)
+		if err != nil {
+			tlog.Error("加载维护状态文件失败", "error", err, "path", cfg.MaintenanceFile)
+			os.Exit(1)
+		}
+		if loaded {
+			tlog.Warn("已从状态文件加载维护状态", "path", cfg.MaintenanceFile, "status", maintenanceSwitch.Status())
+		}
+	}
+	if len(cfg.MaintenanceBypassIPs) > 0 && !clientIPs.HasTrustedProxies() {
+		tlog.Warn("未配置 TRUSTED_PROXIES，MAINTENANCE_BYPASS_IPS 不会生效")
+	}
+	proxyManager.SetMaintenance(maintenanceSwitch)
+
+	// 跨域策略：全局默认策略，路由可通过 RouteConfig.CORS 覆盖
+	corsPolicy := apimiddleware.DefaultCORSPolicy(cfg.CORSOrigins)
+	if len(cfg.CORSAllowedMethods) > 0 {
+		corsPolicy.AllowedMethods = cfg.CORSAllowedMethods
+	}
+	if len(cfg.CORSAllowedHeaders) > 0 {
+		corsPolicy.AllowedHeaders = cfg.CORSAllowedHeaders
+	}
+	if len(cfg.CORSExposedHeaders) > 0 {
+		corsPolicy.ExposedHeaders = cfg.CORSExposedHeaders
+	}
+	if cfg.CORSMaxAge != 0 {
+		// 负数表示不缓存预检结果
+		corsPolicy.MaxAge = max(cfg.CORSMaxAge, 0)
+	}
+	if !corsPolicy.AllowCredentials {
+		tlog.Warn("CORS_ORIGINS 包含 \"*\"，跨域请求将不能携带 Cookie")
+	}
+	corsMiddleware, err := apimiddleware.CORSMiddleware(corsPolicy, proxyManager.CORSPolicy)
+	if err != nil {
+		tlog.Error("跨域策略无效", "error", err)
+		os.Exit(1)
+	}
 
 	// 初始化 Echo 实例
 	e := echo.New()
+	// 未匹配的路由、panic 等 Echo 层面的错误同样返回统一的错误格式
+	e.HTTPErrorHandler = apierror.EchoErrorHandler
 
 	// 添加内置中间件
 	e.Use(middleware.Recover())
 	e.Use(middleware.RequestID())
 	e.Use(echo.WrapMiddleware(apimiddleware.LoggingMiddleware))
-	e.Use(echo.WrapMiddleware(apimiddleware.CORSMiddleware(cfg.CORSOrigins)))
+	// 全局 IP 访问规则，尽早拒绝被封禁的网络
+	e.Use(echo.WrapMiddleware(apimiddleware.IPFilterMiddleware(clientIPs, globalIPFilter, geo)))
+	// 安全响应头与请求加固，放在 CORS 之前以便预检响应也带上安全头
+	e.Use(echo.WrapMiddleware(apimiddleware.HardeningMiddleware(apimiddleware.HardeningConfig{
+		HSTSMaxAge:            cfg.SecurityHSTSMaxAge,
+		HSTSIncludeSubdomains: cfg.SecurityHSTSIncludeSubdomains,
+		ReferrerPolicy:        cfg.SecurityReferrerPolicy,
+		FrameOptions:          cfg.SecurityFrameOptions,
+		ContentSecurityPolicy: cfg.SecurityContentSecurityPolicy,
+		MaxURLLength:          cfg.MaxURLLength,
+		MaxHeaderBytes:        cfg.MaxHeaderBytes,
+	})))
+	e.Use(echo.WrapMiddleware(corsMiddleware))
 
 	// 添加限流中间件
 	rateLimitWindow := time.Duration(cfg.RateLimitWindow) * time.Second
 	e.Use(echo.WrapMiddleware(apimiddleware.RateLimitMiddleware(cfg.RateLimitRequests, rateLimitWindow)))
 
+	// 响应压缩（可选），放在最内层以便日志记录到真实状态码
+	if cfg.CompressionEnabled {
+		e.Use(echo.WrapMiddleware(apimiddleware.CompressionMiddleware(apimiddleware.CompressionConfig{
+			MinSize: cfg.CompressionMinSize,
+		})))
+	}
+
+	// 流量捕获：只在启用管理接口时可用，放在压缩之后以记录未压缩的响应体；
+	// 未启用时每个请求只多一次原子读取，运行时可通过 /_gateway/capture/filter 开启
+	var recorder *capture.Recorder
+	if cfg.AdminToken != "" {
+		recorder = capture.NewRecorder(capture.Config{
+			BufferSize:    cfg.CaptureBufferSize,
+			MaxBodyBytes:  cfg.CaptureMaxBodyBytes,
+			RedactHeaders: cfg.CaptureRedactHeaders,
+		}, capture.Filter{
+			Enabled: cfg.CaptureEnabled,
+			UserIDs: cfg.CaptureUsers,
+			Routes:  cfg.CaptureRoutes,
+			Header:  cfg.CaptureHeader,
+		})
+		e.Use(echo.WrapMiddleware(recorder.Middleware))
+		if cfg.CaptureEnabled {
+			tlog.Warn("流量捕获已启用", "users", cfg.CaptureUsers, "routes", cfg.CaptureRoutes, "header", cfg.CaptureHeader)
+		}
+	} else if cfg.CaptureEnabled {
+		tlog.Warn("未配置 GATEWAY_ADMIN_TOKEN，无法导出记录，流量捕获未启用")
+	}
+
 	// 健康检查路由，无需鉴权
 	e.GET("/ping", func(c echo.Context) error {
 		return c.String(http.StatusOK, "pong")
 	})
 
+	// 网关管理接口（可选），需要 X-Gateway-Admin-Token，配置 ADMIN_IP_ALLOW 时同样限制来源 IP；
+	// 配置 GATEWAY_ADMIN_ADDR 时监听在独立的内网地址，公网端口不再提供 /_gateway
+	if cfg.AdminToken != "" {
+		adminServer := admin.NewServer(admin.Config{Token: cfg.AdminToken, Peers: cfg.Peers, Maintenance: maintenanceSwitch, Capture: recorder}, authenticator)
+		var adminMiddleware []echo.MiddlewareFunc
+		if adminIPRules != nil {
+			adminIPFilter, err := ipfilter.Compile(*adminIPRules)
+			if err != nil {
+				tlog.Error("加载管理接口 IP 访问规则失败", "error", err)
+				os.Exit(1)
+			}
+			adminMiddleware = append(adminMiddleware, echo.WrapMiddleware(apimiddleware.IPFilterMiddleware(clientIPs, adminIPFilter, geo)))
+		}
+		if cfg.AdminAddr != "" {
+			adminEcho := adminServer.NewEcho(adminMiddleware...)
+			go func() {
+				tlog.Info("管理接口启动", "address", cfg.AdminAddr)
+				if err := adminEcho.Start(cfg.AdminAddr); err != nil && err != http.ErrServerClosed {
+					tlog.Error("管理接口启动失败", "error", err, "address", cfg.AdminAddr)
+					os.Exit(1)
+				}
+			}()
+		} else {
+			tlog.Warn("未配置 GATEWAY_ADMIN_ADDR，管理接口与业务接口共用端口，建议监听在独立的内网地址")
+			adminServer.Register(e, adminMiddleware...)
+		}
+	} else {
+		tlog.Info("未配置 GATEWAY_ADMIN_TOKEN，管理接口未启用")
+	}
+
 	// 添加API路由组，需要鉴权
 	apiGroup := e.Group("/api")
 	// apiGroup.Use(echo.WrapMiddleware(apimiddleware.AuthMiddleware(cfg)))
@@ -137,6 +498,9 @@ func main() {
 	// 所有API请求由代理管理器处理
 	// 使用自定义 EchoHandler 来支持流式响应（SSE）
 	apiGroup.Any("/*", proxyManager.EchoHandler)
+	for _, path := range grpcPaths {
+		e.Any(path+"*", proxyManager.EchoHandler)
+	}
 
 	// 启动服务器
 	port := cfg.Port
@@ -145,6 +509,43 @@ func main() {
 	}
 	addr := ":" + port
 
+	// net/http 层的请求头上限，超出时直接返回 431；中间件在此之下按配置做更精确的限制
+	if cfg.MaxHeaderBytes > 0 {
+		e.Server.MaxHeaderBytes = cfg.MaxHeaderBytes
+	}
+
+	// 明文 HTTP/2（h2c），供内网的原生 gRPC 客户端或前置负载均衡使用
+	if cfg.ServerH2C {
+		e.Server.Protocols = new(http.Protocols)
+		e.Server.Protocols.SetHTTP1(true)
+		e.Server.Protocols.SetHTTP2(true)
+		e.Server.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	// 配置证书或 ACME 域名时直接提供 HTTPS，并通过 ALPN 向客户端提供 HTTP/2
+	serverTLS := tlsconfig.ServerOptions{
+		CertFile:         cfg.TLSCertFile,
+		KeyFile:          cfg.TLSKeyFile,
+		ACMEDomains:      cfg.TLSACMEDomains,
+		ACMEDirectoryURL: cfg.TLSACMEDirectoryURL,
+		ACMEEmail:        cfg.TLSACMEEmail,
+		ACMECacheDir:     cfg.TLSACMECacheDir,
+	}
+	if serverTLS.Enabled() {
+		tlsConfig, err := tlsconfig.NewServerConfig(serverTLS)
+		if err != nil {
+			tlog.Error("加载 TLS 配置失败", "error", err)
+			os.Exit(1)
+		}
+		e.Server.Addr = addr
+		e.Server.TLSConfig = tlsConfig
+		tlog.Info("API网关启动（HTTPS）", "address", addr, "port", port, "acme_domains", cfg.TLSACMEDomains)
+		if err := e.StartServer(e.Server); err != nil && err != http.ErrServerClosed {
+			tlog.Error("API网关启动失败", "error", err, "address", addr)
+		}
+		return
+	}
+
 	tlog.Info("API网关启动", "address", addr, "port", port)
 	if err := e.Start(addr); err != nil && err != http.ErrServerClosed {
 		tlog.Error("API网关启动失败", "error", err, "address", addr)
@@ -3,9 +3,13 @@ module github.com/indulgeback/telos/apps/api-gateway
 go 1.24.4
 
 require (
+	github.com/andybalholm/brotli v1.2.6
+	github.com/indulgeback/telos/pkg/gatewayidentity v0.0.0-00010101000000-000000000000
 	github.com/indulgeback/telos/pkg/tlog v0.0.0-00010101000000-000000000000
 	github.com/labstack/echo/v4 v4.13.4
+	github.com/oschwald/maxminddb-golang/v2 v2.0.0
 	github.com/spf13/viper v1.20.1
+	golang.org/x/crypto v0.40.0
 )
 
 require (
@@ -44,9 +48,8 @@ require (
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/crypto v0.40.0 // indirect
 	golang.org/x/net v0.42.0 // indirect
-	golang.org/x/sys v0.34.0 // indirect
+	golang.org/x/sys v0.37.0 // indirect
 	golang.org/x/text v0.27.0 // indirect
 	golang.org/x/time v0.11.0 // indirect
 	google.golang.org/protobuf v1.36.1 // indirect
@@ -54,3 +57,5 @@ require (
 )
 
 replace github.com/indulgeback/telos/pkg/tlog => ../../pkg/tlog
+
+replace github.com/indulgeback/telos/pkg/gatewayidentity => ../../pkg/gatewayidentity
@@ -0,0 +1,334 @@
+// Package admin 提供网关的管理接口，挂载在 /_gateway 下，需要管理令牌
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/capture"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/maintenance"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/metrics"
+	"github.com/indulgeback/telos/pkg/tlog"
+	"github.com/labstack/echo/v4"
+	echomiddleware "github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	// HeaderAdminToken 管理接口的认证头
+	HeaderAdminToken = "X-Gateway-Admin-Token"
+	// headerForwarded 标记由其他网关副本转发的请求，避免再次广播
+	headerForwarded = "X-Gateway-Admin-Forwarded"
+
+	peerTimeout = 3 * time.Second
+)
+
+type Config struct {
+	// Token 管理令牌，为空时不启用管理接口
+	Token string
+	// Peers 其他网关副本的地址，如 http://gateway-2:8890，吊销等操作会广播到这些副本
+	Peers []string
+	// Maintenance 维护开关，为 nil 时不注册维护接口
+	Maintenance *maintenance.Switch
+	// Capture 流量记录器，为 nil 时不注册流量捕获接口
+	Capture *capture.Recorder
+}
+
+// Server 网关管理接口
+type Server struct {
+	cfg           Config
+	authenticator *gatewayauth.Authenticator
+	client        *http.Client
+}
+
+func NewServer(cfg Config, authenticator *gatewayauth.Authenticator) *Server {
+	return &Server{
+		cfg:           cfg,
+		authenticator: authenticator,
+		client:        &http.Client{Timeout: peerTimeout},
+	}
+}
+
+// NewEcho 创建只提供管理接口的 Echo 实例，用于监听在独立的内网地址（GATEWAY_ADMIN_ADDR）
+func (s *Server) NewEcho(middleware ...echo.MiddlewareFunc) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.HTTPErrorHandler = apierror.EchoErrorHandler
+	e.Use(echomiddleware.Recover(), echomiddleware.RequestID())
+	s.Register(e, middleware...)
+	return e
+}
+
+// Register 在 Echo 上注册 /_gateway 管理路由，middleware 在令牌校验之前执行（如来源 IP 限制）
+func (s *Server) Register(e *echo.Echo, middleware ...echo.MiddlewareFunc) {
+	group := e.Group("/_gateway", append(middleware, s.requireToken)...)
+	group.POST("/sessions/revoke", s.revokeSessions)
+	// Prometheus 文本格式的网关指标，抓取时同样需要管理令牌
+	group.GET("/metrics", echo.WrapHandler(metrics.Handler()))
+	if s.cfg.Maintenance != nil {
+		group.GET("/maintenance", s.maintenanceStatus)
+		group.POST("/maintenance", s.setMaintenance)
+	}
+	if s.cfg.Capture != nil {
+		group.GET("/capture", s.exportCapture)
+		group.GET("/capture/filter", s.captureFilter)
+		group.POST("/capture/filter", s.setCaptureFilter)
+		group.POST("/capture/clear", s.clearCapture)
+	}
+}
+
+func (s *Server) requireToken(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.Request().Header.Get(HeaderAdminToken)
+		if s.cfg.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+			tlog.Warn("[API Gateway] 管理接口认证失败", "path", c.Request().URL.Path, "remote_addr", c.RealIP())
+			apierror.Write(c.Response().Writer, c.Request(), apierror.CodeUnauthorized)
+			return nil
+		}
+		return next(c)
+	}
+}
+
+// revokeRequest 至少提供 sessionId 或 userId 之一
+type revokeRequest struct {
+	SessionID string `json:"sessionId"`
+	UserID    string `json:"userId"`
+}
+
+// revokeSessions 清除会话缓存，使登出立即在所有网关副本生效
+// 可由 Better Auth 的会话删除钩子调用
+func (s *Server) revokeSessions(c echo.Context) error {
+	var req revokeRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeInvalidJSON)
+		return nil
+	}
+	req.SessionID = strings.TrimSpace(req.SessionID)
+	req.UserID = strings.TrimSpace(req.UserID)
+	if req.SessionID == "" && req.UserID == "" {
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeMissingParameter, "sessionId/userId")
+		return nil
+	}
+
+	revoked := s.authenticator.RevokeSession(req.SessionID) + s.authenticator.RevokeUser(req.UserID)
+	tlog.Info("[API Gateway] 吊销会话缓存",
+		"session_id", req.SessionID,
+		"user_id", req.UserID,
+		"revoked", revoked,
+	)
+
+	response := map[string]any{"revoked": revoked}
+	if c.Request().Header.Get(headerForwarded) == "" {
+		response["peers"] = s.broadcast(c.Request().Context(), c.Request().URL.Path, req)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// maintenanceRequest route 为空时切换整个网关的维护状态
+type maintenanceRequest struct {
+	Route      string `json:"route"`
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retryAfter"`
+}
+
+func (s *Server) maintenanceStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.cfg.Maintenance.Status())
+}
+
+// setMaintenance 开启或关闭维护状态，并广播到其他副本；配置了 MAINTENANCE_FILE 时状态会写回文件，重启后保留
+func (s *Server) setMaintenance(c echo.Context) error {
+	var req maintenanceRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeInvalidJSON)
+		return nil
+	}
+	req.Route = strings.TrimSpace(req.Route)
+	mode := maintenance.Mode{Enabled: req.Enabled, Message: req.Message, RetryAfter: req.RetryAfter}
+	if err := s.cfg.Maintenance.Set(req.Route, mode); err != nil {
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeInvalidParameter, err.Error())
+		return nil
+	}
+	tlog.Warn("[API Gateway] 切换维护状态",
+		"route", req.Route,
+		"enabled", req.Enabled,
+		"retry_after", req.RetryAfter,
+	)
+
+	response := map[string]any{"status": s.cfg.Maintenance.Status()}
+	if c.Request().Header.Get(headerForwarded) == "" {
+		response["peers"] = s.broadcast(c.Request().Context(), c.Request().URL.Path, req)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// exportCapture 以 HAR 格式下载记录，?user= 只导出该用户的请求
+// 记录只保存在处理请求的副本上，导出时合并所有副本的记录；?local=1 只导出本副本
+func (s *Server) exportCapture(c echo.Context) error {
+	userID := strings.TrimSpace(c.QueryParam("user"))
+	har := s.cfg.Capture.HAR(userID)
+	if c.Request().Header.Get(headerForwarded) == "" && c.QueryParam("local") == "" {
+		var failed []string
+		for peer, entries := range s.collectCapture(c.Request().Context(), userID) {
+			if entries == nil {
+				failed = append(failed, peer)
+				continue
+			}
+			har.Merge(entries)
+		}
+		if len(failed) > 0 {
+			sort.Strings(failed)
+			har.Log.Comment = "incomplete, failed peers: " + strings.Join(failed, ", ")
+		}
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="gateway.har"`)
+	return c.JSON(http.StatusOK, har)
+}
+
+func (s *Server) captureFilter(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.cfg.Capture.Filter())
+}
+
+// setCaptureFilter 更新记录条件并广播到其他副本，同一用户的请求可能落在任意副本上
+func (s *Server) setCaptureFilter(c echo.Context) error {
+	var filter capture.Filter
+	if err := json.NewDecoder(c.Request().Body).Decode(&filter); err != nil {
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeInvalidJSON)
+		return nil
+	}
+	filter.Header = strings.TrimSpace(filter.Header)
+	s.cfg.Capture.SetFilter(filter)
+	tlog.Warn("[API Gateway] 更新流量捕获条件",
+		"enabled", filter.Enabled,
+		"user_ids", filter.UserIDs,
+		"routes", filter.Routes,
+		"header", filter.Header,
+	)
+
+	response := map[string]any{"filter": filter}
+	if c.Request().Header.Get(headerForwarded) == "" {
+		response["peers"] = s.broadcast(c.Request().Context(), c.Request().URL.Path, filter)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// clearCapture 清空所有副本的记录缓冲区
+func (s *Server) clearCapture(c echo.Context) error {
+	s.cfg.Capture.Clear()
+	tlog.Info("[API Gateway] 清空流量捕获记录")
+
+	response := map[string]any{"cleared": true}
+	if c.Request().Header.Get(headerForwarded) == "" {
+		response["peers"] = s.broadcast(c.Request().Context(), c.Request().URL.Path, struct{}{})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// collectCapture 从其他副本拉取记录，拉取失败的副本对应 nil
+func (s *Server) collectCapture(ctx context.Context, userID string) map[string][]capture.HAREntry {
+	results := make(map[string][]capture.HAREntry, len(s.cfg.Peers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range s.cfg.Peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			entries, err := s.fetchCapture(ctx, strings.TrimRight(peer, "/")+"/_gateway/capture?user="+url.QueryEscape(userID))
+			if err != nil {
+				tlog.Warn("[API Gateway] 拉取副本流量记录失败", "peer", peer, "error", err)
+			}
+			mu.Lock()
+			results[peer] = entries
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return results
+}
+
+func (s *Server) fetchCapture(ctx context.Context, target string) ([]capture.HAREntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(HeaderAdminToken, s.cfg.Token)
+	req.Header.Set(headerForwarded, "1")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var har capture.HAR
+	if err := json.NewDecoder(resp.Body).Decode(&har); err != nil {
+		return nil, err
+	}
+	return nonNilEntries(har.Log.Entries), nil
+}
+
+func nonNilEntries(entries []capture.HAREntry) []capture.HAREntry {
+	if entries == nil {
+		return []capture.HAREntry{}
+	}
+	return entries
+}
+
+// broadcast 把管理操作转发给其他副本，返回每个副本的结果
+func (s *Server) broadcast(ctx context.Context, path string, payload any) map[string]string {
+	results := make(map[string]string, len(s.cfg.Peers))
+	if len(s.cfg.Peers) == 0 {
+		return results
+	}
+	body, _ := json.Marshal(payload)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range s.cfg.Peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			result := "ok"
+			if err := s.forward(ctx, strings.TrimRight(peer, "/")+path, body); err != nil {
+				result = err.Error()
+				tlog.Warn("[API Gateway] 管理操作转发失败", "peer", peer, "path", path, "error", err)
+			}
+			mu.Lock()
+			results[peer] = result
+			mu.Unlock()
+		}(peer)
+	}
+	wg.Wait()
+	return results
+}
+
+func (s *Server) forward(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderAdminToken, s.cfg.Token)
+	req.Header.Set(headerForwarded, "1")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
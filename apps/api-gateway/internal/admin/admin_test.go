@@ -0,0 +1,224 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/capture"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/ipfilter"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/maintenance"
+	apimiddleware "github.com/indulgeback/telos/apps/api-gateway/internal/middleware"
+	"github.com/labstack/echo/v4"
+)
+
+func newGateway(t *testing.T, sessionURL string, peers []string) (*httptest.Server, *gatewayauth.Authenticator) {
+	t.Helper()
+	authenticator := gatewayauth.NewAuthenticator(gatewayauth.Config{
+		BetterAuthBaseURL:     sessionURL,
+		BetterAuthSessionPath: "/get-session",
+		CacheTTL:              time.Minute,
+	})
+	t.Cleanup(authenticator.Stop)
+	e := echo.New()
+	NewServer(Config{Token: "admin-token", Peers: peers}, authenticator).Register(e)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	return server, authenticator
+}
+
+func TestRevokeSessionsBroadcastsToPeers(t *testing.T) {
+	calls := 0
+	betterAuth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(`{"session":{"id":"session-1"},"user":{"id":"user-1"}}`))
+	}))
+	defer betterAuth.Close()
+
+	peer, peerAuth := newGateway(t, betterAuth.URL, nil)
+	gateway, gatewayAuth := newGateway(t, betterAuth.URL, []string{peer.URL})
+	for _, a := range []*gatewayauth.Authenticator{peerAuth, gatewayAuth} {
+		if _, err := a.Authenticate(context.Background(), "telos.session_token=ok"); err != nil {
+			t.Fatalf("expected authenticate to succeed: %v", err)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/_gateway/sessions/revoke", strings.NewReader(`{"sessionId":"session-1"}`))
+	req.Header.Set(HeaderAdminToken, "admin-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected revoke request to succeed: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Revoked int               `json:"revoked"`
+		Peers   map[string]string `json:"peers"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || body.Revoked != 1 || body.Peers[peer.URL] != "ok" {
+		t.Fatalf("unexpected revoke response %d %+v", resp.StatusCode, body)
+	}
+
+	// 两个副本的缓存都已清除
+	for _, a := range []*gatewayauth.Authenticator{peerAuth, gatewayAuth} {
+		_, _ = a.Authenticate(context.Background(), "telos.session_token=ok")
+	}
+	if calls != 4 {
+		t.Fatalf("expected both replicas to look up the session again, got %d calls", calls)
+	}
+}
+
+func TestAdminAPIRequiresToken(t *testing.T) {
+	gateway, _ := newGateway(t, "http://127.0.0.1:0", nil)
+	for _, token := range []string{"", "wrong"} {
+		req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/_gateway/sessions/revoke", strings.NewReader(`{"userId":"user-1"}`))
+		req.Header.Set(HeaderAdminToken, token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected request to complete: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("token %q: expected 401, got %d", token, resp.StatusCode)
+		}
+	}
+}
+
+func TestSetMaintenanceBroadcastsToPeers(t *testing.T) {
+	newReplica := func(peers []string) (*httptest.Server, *maintenance.Switch) {
+		sw, err := maintenance.NewSwitch([]string{"/api/runs"}, nil, nil)
+		if err != nil {
+			t.Fatalf("NewSwitch: %v", err)
+		}
+		e := echo.New()
+		NewServer(Config{Token: "admin-token", Peers: peers, Maintenance: sw}, nil).Register(e)
+		server := httptest.NewServer(e)
+		t.Cleanup(server.Close)
+		return server, sw
+	}
+	peer, peerSwitch := newReplica(nil)
+	gateway, gatewaySwitch := newReplica([]string{peer.URL})
+
+	post := func(body string) int {
+		req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/_gateway/maintenance", strings.NewReader(body))
+		req.Header.Set(HeaderAdminToken, "admin-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected maintenance request to complete: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := post(`{"route":"/api/runs","enabled":true,"message":"迁移中","retryAfter":60}`); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	for _, sw := range []*maintenance.Switch{gatewaySwitch, peerSwitch} {
+		if mode, ok := sw.Active("/api/runs"); !ok || mode.Message != "迁移中" || mode.RetryAfter != 60 {
+			t.Fatalf("expected route to be in maintenance on every replica, got %+v %v", mode, ok)
+		}
+	}
+	if status := post(`{"route":"/api/unknown","enabled":true}`); status != http.StatusBadRequest {
+		t.Fatalf("expected unknown route to be rejected, got %d", status)
+	}
+}
+
+func TestCaptureFilterAndExport(t *testing.T) {
+	newReplica := func(peers []string) (*httptest.Server, *capture.Recorder) {
+		rec := capture.NewRecorder(capture.Config{}, capture.Filter{})
+		e := echo.New()
+		e.Use(echo.WrapMiddleware(rec.Middleware))
+		e.GET("/api/agent", func(c echo.Context) error { return c.String(http.StatusOK, "ok") })
+		NewServer(Config{Token: "admin-token", Peers: peers, Capture: rec}, nil).Register(e)
+		server := httptest.NewServer(e)
+		t.Cleanup(server.Close)
+		return server, rec
+	}
+	peer, peerRecorder := newReplica(nil)
+	gateway, _ := newReplica([]string{peer.URL})
+
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/_gateway/capture/filter", strings.NewReader(`{"enabled":true,"routes":["/api/agent"]}`))
+	req.Header.Set(HeaderAdminToken, "admin-token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("expected filter request to complete: %v", err)
+	}
+	resp.Body.Close()
+	if filter := peerRecorder.Filter(); resp.StatusCode != http.StatusOK || !filter.Enabled || len(filter.Routes) != 1 {
+		t.Fatalf("expected filter to be broadcast, got %d %+v", resp.StatusCode, filter)
+	}
+
+	// 同一用户的请求分别落在两个副本上
+	for _, replica := range []*httptest.Server{peer, gateway} {
+		resp, err = http.Get(replica.URL + "/api/agent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	export := func(query string) (capture.HAR, *http.Response) {
+		req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/_gateway/capture"+query, nil)
+		req.Header.Set(HeaderAdminToken, "admin-token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("expected export request to complete: %v", err)
+		}
+		defer resp.Body.Close()
+		var har capture.HAR
+		if err := json.NewDecoder(resp.Body).Decode(&har); err != nil {
+			t.Fatalf("decode HAR: %v", err)
+		}
+		return har, resp
+	}
+	har, resp := export("")
+	if !strings.Contains(resp.Header.Get("Content-Disposition"), "gateway.har") || har.Log.Version != "1.2" ||
+		len(har.Log.Entries) != 2 || har.Log.Entries[0].Response.Content.Text != "ok" || har.Log.Comment != "" {
+		t.Fatalf("expected entries from both replicas, got %+v", har)
+	}
+	if har.Log.Entries[0].StartedDateTime > har.Log.Entries[1].StartedDateTime {
+		t.Fatalf("expected entries sorted by start time, got %+v", har.Log.Entries)
+	}
+	if har, _ := export("?local=1"); len(har.Log.Entries) != 1 {
+		t.Fatalf("expected only local entries, got %+v", har.Log.Entries)
+	}
+
+	peer.Close()
+	if har, _ := export(""); len(har.Log.Entries) != 1 || !strings.Contains(har.Log.Comment, peer.URL) {
+		t.Fatalf("expected failed peer to be reported, got %+v", har.Log)
+	}
+}
+
+func TestAdminEchoServesOnlyAdminRoutesBehindIPFilter(t *testing.T) {
+	resolver, _ := ipfilter.NewResolver(nil)
+	filter, err := ipfilter.Compile(ipfilter.Rules{Allow: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewServer(Config{Token: "admin-token"}, nil).NewEcho(
+		echo.WrapMiddleware(apimiddleware.IPFilterMiddleware(resolver, filter, nil)),
+	)
+
+	serve := func(path, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(HeaderAdminToken, "admin-token")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := serve("/_gateway/metrics", "10.1.2.3:1234"); code != http.StatusOK {
+		t.Fatalf("expected allow-listed admin request to succeed, got %d", code)
+	}
+	if code := serve("/_gateway/metrics", "203.0.113.9:1234"); code != http.StatusForbidden {
+		t.Fatalf("expected admin request from outside the allow-list to be rejected even with a token, got %d", code)
+	}
+	if code := serve("/api/agents", "10.1.2.3:1234"); code != http.StatusNotFound {
+		t.Fatalf("expected the admin listener to serve only /_gateway, got %d", code)
+	}
+}
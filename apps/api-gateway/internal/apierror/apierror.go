@@ -0,0 +1,239 @@
+// Package apierror 网关统一的错误响应格式
+//
+// 网关自身产生的错误都按 RFC 9457 以 application/problem+json 返回，
+// code 是稳定的机器可读错误码，客户端应按 code 而不是 detail 文本判断错误类型；
+// detail 按 Accept-Language 本地化，内部错误只写日志，不返回给客户端
+package apierror
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType 错误响应的媒体类型
+const ContentType = "application/problem+json"
+
+// TypePrefix 错误类型 URI 的前缀，完整的 type 为前缀加错误码
+const TypePrefix = "urn:telos:error:"
+
+// Code 稳定的错误码，新增错误码不影响已有取值
+type Code string
+
+const (
+	CodeRouteNotFound     Code = "route_not_found"
+	CodeNotFound          Code = "not_found"
+	CodeMethodNotAllowed  Code = "method_not_allowed"
+	CodeBadRequest        Code = "bad_request"
+	CodeUnauthorized      Code = "unauthorized"
+	CodeAuthUnavailable   Code = "auth_unavailable"
+	CodeForbidden         Code = "forbidden"
+	CodeCSRFRejected      Code = "csrf_rejected"
+	CodeIPDenied          Code = "ip_denied"
+	CodeRateLimited       Code = "rate_limited"
+	CodeTooManyConcurrent Code = "too_many_concurrent"
+
+	CodeURLTooLong      Code = "url_too_long"
+	CodeHeadersTooLarge Code = "headers_too_large"
+	CodeAmbiguousLength Code = "ambiguous_length"
+	CodePathTraversal   Code = "path_traversal"
+
+	CodeInvalidContentType   Code = "invalid_content_type"
+	CodeUnsupportedMediaType Code = "unsupported_media_type"
+	CodeBodyTooLarge         Code = "body_too_large"
+	CodeBodyReadFailed       Code = "body_read_failed"
+	CodeInvalidJSON          Code = "invalid_json"
+	CodeBodyValidationFailed Code = "body_validation_failed"
+	CodeMissingParameter     Code = "missing_parameter"
+	CodeInvalidParameter     Code = "invalid_parameter"
+
+	CodeGRPCRequired       Code = "grpc_required"
+	CodeGRPCNotSupported   Code = "grpc_not_supported"
+	CodeInvalidGRPCTimeout Code = "invalid_grpc_timeout"
+	CodeDeadlineExceeded   Code = "deadline_exceeded"
+
+	CodeServiceUnavailable Code = "service_unavailable"
+	CodeMaintenance        Code = "maintenance"
+	CodeUpstreamError      Code = "upstream_error"
+	CodeUpstreamTimeout    Code = "upstream_timeout"
+	CodeInternal           Code = "internal_error"
+)
+
+// Language 错误信息的语言
+type Language string
+
+const (
+	LanguageZH Language = "zh"
+	LanguageEN Language = "en"
+)
+
+// DefaultLanguage 客户端没有声明或声明的语言都不支持时使用的语言
+const DefaultLanguage = LanguageZH
+
+// entry 错误码对应的 HTTP 状态码与各语言的信息模板，模板参数由调用方提供
+type entry struct {
+	status int
+	zh     string
+	en     string
+}
+
+var catalog = map[Code]entry{
+	CodeRouteNotFound:     {http.StatusNotFound, "未找到匹配的服务路由", "No service route matches the request"},
+	CodeNotFound:          {http.StatusNotFound, "资源不存在", "Resource not found"},
+	CodeMethodNotAllowed:  {http.StatusMethodNotAllowed, "不支持该请求方法", "Method not allowed"},
+	CodeBadRequest:        {http.StatusBadRequest, "请求无效", "Bad request"},
+	CodeUnauthorized:      {http.StatusUnauthorized, "未登录或登录已失效", "Authentication required"},
+	CodeAuthUnavailable:   {http.StatusServiceUnavailable, "认证服务暂时不可用", "Authentication service is temporarily unavailable"},
+	CodeForbidden:         {http.StatusForbidden, "无权访问该资源", "You do not have access to this resource"},
+	CodeCSRFRejected:      {http.StatusForbidden, "CSRF 校验失败", "CSRF validation failed"},
+	CodeIPDenied:          {http.StatusForbidden, "禁止访问", "Access denied"},
+	CodeRateLimited:       {http.StatusTooManyRequests, "请求过于频繁，请稍后再试", "Too many requests, please try again later"},
+	CodeTooManyConcurrent: {http.StatusTooManyRequests, "并发请求过多，请等待已有请求完成后再试", "Too many concurrent requests, wait for pending requests to finish"},
+
+	CodeURLTooLong:      {http.StatusRequestURITooLong, "请求 URL 过长", "Request URL is too long"},
+	CodeHeadersTooLarge: {http.StatusRequestHeaderFieldsTooLarge, "请求头过大", "Request headers are too large"},
+	CodeAmbiguousLength: {http.StatusBadRequest, "请求长度声明冲突", "Conflicting request length headers"},
+	CodePathTraversal:   {http.StatusBadRequest, "请求路径无效", "Invalid request path"},
+
+	CodeInvalidContentType:   {http.StatusUnsupportedMediaType, "无法解析请求的 Content-Type", "Malformed Content-Type header"},
+	CodeUnsupportedMediaType: {http.StatusUnsupportedMediaType, "不支持的请求类型 %q", "Unsupported media type %q"},
+	CodeBodyTooLarge:         {http.StatusRequestEntityTooLarge, "请求体超过 %d 字节的上限", "Request body exceeds the %d byte limit"},
+	CodeBodyReadFailed:       {http.StatusBadRequest, "读取请求体失败", "Failed to read request body"},
+	CodeInvalidJSON:          {http.StatusBadRequest, "请求体不是合法的 JSON", "Request body is not valid JSON"},
+	CodeBodyValidationFailed: {http.StatusBadRequest, "请求体校验失败: %s", "Request body validation failed: %s"},
+	CodeMissingParameter:     {http.StatusBadRequest, "缺少参数 %s", "Missing parameter %s"},
+	CodeInvalidParameter:     {http.StatusBadRequest, "参数无效: %s", "Invalid parameter: %s"},
+
+	CodeGRPCRequired:       {http.StatusUnsupportedMediaType, "该路由只接受 gRPC 请求", "This route only accepts gRPC requests"},
+	CodeGRPCNotSupported:   {http.StatusNotImplemented, "该路由不支持 gRPC", "This route does not support gRPC"},
+	CodeInvalidGRPCTimeout: {http.StatusBadRequest, "grpc-timeout 格式无效", "Malformed grpc-timeout header"},
+	CodeDeadlineExceeded:   {http.StatusGatewayTimeout, "请求已超过截止时间", "Request deadline exceeded"},
+
+	CodeServiceUnavailable: {http.StatusServiceUnavailable, "服务暂时不可用", "Service temporarily unavailable"},
+	CodeMaintenance:        {http.StatusServiceUnavailable, "服务维护中，请稍后再试", "Service is under maintenance, please try again later"},
+	CodeUpstreamError:      {http.StatusBadGateway, "后端服务错误", "Upstream service error"},
+	CodeUpstreamTimeout:    {http.StatusGatewayTimeout, "后端服务超时", "Upstream service timed out"},
+	CodeInternal:           {http.StatusInternalServerError, "网关内部错误", "Internal gateway error"},
+}
+
+// Status 错误码对应的 HTTP 状态码，未知错误码按 500 处理
+func (c Code) Status() int {
+	if e, ok := catalog[c]; ok {
+		return e.status
+	}
+	return http.StatusInternalServerError
+}
+
+// Message 按语言生成错误信息
+func Message(code Code, lang Language, args ...any) string {
+	e, ok := catalog[code]
+	if !ok {
+		e = catalog[CodeInternal]
+	}
+	template := e.zh
+	if lang == LanguageEN {
+		template = e.en
+	}
+	if len(args) == 0 {
+		return template
+	}
+	return fmt.Sprintf(template, args...)
+}
+
+// Problem RFC 9457 错误响应体
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance,omitempty"`
+	Code     Code   `json:"code"`
+	// Message 与 Detail 相同，兼容读取 message 字段的旧客户端
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+	TraceID   string `json:"traceId,omitempty"`
+}
+
+// New 根据请求生成错误响应体，detail 按请求的 Accept-Language 本地化
+func New(r *http.Request, code Code, args ...any) Problem {
+	status := code.Status()
+	lang := Negotiate(r.Header.Get("Accept-Language"))
+	detail := Message(code, lang, args...)
+	return Problem{
+		Type:      TypePrefix + string(code),
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		Message:   detail,
+		RequestID: r.Header.Get("X-Request-ID"),
+		TraceID:   TraceID(r.Header.Get("Traceparent")),
+	}
+}
+
+// WithStatus 使用指定的状态码，用于转换后端返回的错误时保留后端的状态码
+func (p Problem) WithStatus(status int) Problem {
+	p.Status = status
+	p.Title = http.StatusText(status)
+	return p
+}
+
+// Write 写入错误响应，状态码由错误码决定
+func Write(w http.ResponseWriter, r *http.Request, code Code, args ...any) {
+	WriteProblem(w, r, New(r, code, args...))
+}
+
+// WriteProblem 写入调整过的错误响应，如替换了 detail 的维护提示
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem Problem) {
+	header := w.Header()
+	// 请求 ID 中间件把生成的 ID 写在响应头上，客户端没有携带时以响应头为准
+	if requestID := header.Get("X-Request-ID"); requestID != "" {
+		problem.RequestID = requestID
+	}
+	header.Set("Content-Type", ContentType)
+	header.Set("Content-Language", string(Negotiate(r.Header.Get("Accept-Language"))))
+	header.Del("Content-Length")
+	w.WriteHeader(problem.Status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+// Negotiate 按 Accept-Language 的权重选择支持的语言，如 "en-US,en;q=0.9,zh;q=0.8" 选择 en
+func Negotiate(acceptLanguage string) Language {
+	best, bestQ := DefaultLanguage, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		lang := Language(base)
+		if lang != LanguageZH && lang != LanguageEN {
+			continue
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// TraceID 从 W3C traceparent 头（version-traceid-parentid-flags）中取出 trace ID，格式无效时返回空
+func TraceID(traceparent string) string {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	traceID := strings.ToLower(parts[1])
+	if strings.Trim(traceID, "0") == "" || strings.Trim(traceID, "0123456789abcdef") != "" {
+		return ""
+	}
+	return traceID
+}
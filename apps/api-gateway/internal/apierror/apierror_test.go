@@ -0,0 +1,90 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]Language{
+		"":                           LanguageZH,
+		"en":                         LanguageEN,
+		"en-US,en;q=0.9,zh;q=0.8":    LanguageEN,
+		"fr-FR,zh-CN;q=0.5,en;q=0.4": LanguageZH,
+		"zh;q=0.2,en;q=0.8":          LanguageEN,
+		"fr,de;q=0.9":                LanguageZH,
+		"en;q=0":                     LanguageZH,
+	}
+	for header, expected := range cases {
+		if got := Negotiate(header); got != expected {
+			t.Errorf("%q: expected %s, got %s", header, expected, got)
+		}
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	cases := map[string]string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01": "4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": "",
+		"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01": "",
+		"garbage": "",
+	}
+	for header, expected := range cases {
+		if got := TraceID(header); got != expected {
+			t.Errorf("%q: expected %q, got %q", header, expected, got)
+		}
+	}
+}
+
+func TestWriteLocalizesAndFormatsArgs(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/agent", nil)
+	req.Header.Set("Accept-Language", "en")
+	req.Header.Set("X-Request-ID", "client-id")
+	rec := httptest.NewRecorder()
+	Write(rec, req, CodeBodyTooLarge, int64(1024))
+
+	if rec.Code != http.StatusRequestEntityTooLarge || rec.Header().Get("Content-Language") != "en" {
+		t.Fatalf("unexpected response %d %v", rec.Code, rec.Header())
+	}
+	var problem Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	expected := Problem{
+		Type:      "urn:telos:error:body_too_large",
+		Title:     "Request Entity Too Large",
+		Status:    http.StatusRequestEntityTooLarge,
+		Detail:    "Request body exceeds the 1024 byte limit",
+		Instance:  "/api/agent",
+		Code:      CodeBodyTooLarge,
+		Message:   "Request body exceeds the 1024 byte limit",
+		RequestID: "client-id",
+	}
+	if problem != expected {
+		t.Fatalf("unexpected problem %+v", problem)
+	}
+}
+
+func TestEchoErrorHandlerHidesInternalErrors(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = EchoErrorHandler
+	e.GET("/panic", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusInternalServerError, "dial tcp 10.0.0.5:8080: connection refused")
+	})
+
+	for path, expected := range map[string]Code{"/missing": CodeNotFound, "/panic": CodeInternal} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var problem Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("%s: decode problem: %v", path, err)
+		}
+		if problem.Code != expected || problem.Status != expected.Status() || problem.Detail != Message(expected, DefaultLanguage) {
+			t.Fatalf("%s: unexpected problem %+v", path, problem)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/indulgeback/telos/pkg/tlog"
+	"github.com/labstack/echo/v4"
+)
+
+// EchoErrorHandler 替换 Echo 默认的错误处理，未匹配的路由、不支持的方法和 panic 同样返回统一格式
+// 非 HTTPError 的错误只写日志，客户端只看到 internal_error
+func EchoErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+	code := CodeInternal
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		code = CodeForStatus(httpErr.Code)
+	}
+	if code == CodeInternal {
+		tlog.Error("[API Gateway] 请求处理失败", "method", c.Request().Method, "path", c.Request().URL.Path, "error", err)
+	}
+	Write(c.Response(), c.Request(), code)
+}
+
+// CodeForStatus 按 HTTP 状态码选择通用错误码，未单独定义的 4xx 归为 bad_request，其余归为 internal_error
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	default:
+		if status >= 400 && status < 500 {
+			return CodeBadRequest
+		}
+		return CodeInternal
+	}
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey 描述一个可用于脚本/CI 调用网关的 API Key，只保存密钥的哈希
+type APIKey struct {
+	ID        string     `json:"id"`
+	Hash      string     `json:"hash"` // SHA-256 十六进制摘要，可带 "sha256:" 前缀
+	UserID    string     `json:"userId"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Disabled  bool       `json:"disabled"`
+}
+
+// APIKeyStore 按密钥哈希查找 API Key，未找到时返回 nil
+type APIKeyStore interface {
+	LookupAPIKey(ctx context.Context, hash string) (*APIKey, error)
+}
+
+// HashAPIKey 计算 API Key 的存储哈希
+func HashAPIKey(key string) string {
+	return hashString(key)
+}
+
+// FileAPIKeyStore 从 JSON 文件加载 API Key，适合本地和小规模部署
+// 文件格式：{"keys":[{"id":"ci","hash":"<sha256>","userId":"user-1","scopes":["agents:read"]}]}
+// 文件修改后会在下一次查找时自动重新加载
+type FileAPIKeyStore struct {
+	path string
+
+	mu          sync.RWMutex
+	keys        map[string]*APIKey
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+const apiKeyFileCheckInterval = 5 * time.Second
+
+func NewFileAPIKeyStore(path string) (*FileAPIKeyStore, error) {
+	store := &FileAPIKeyStore{path: path}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FileAPIKeyStore) LookupAPIKey(ctx context.Context, hash string) (*APIKey, error) {
+	s.reloadIfChanged()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[normalizeKeyHash(hash)]
+	if !ok {
+		return nil, nil
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (s *FileAPIKeyStore) reloadIfChanged() {
+	s.mu.RLock()
+	due := time.Since(s.lastChecked) >= apiKeyFileCheckInterval
+	s.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	info, err := os.Stat(s.path)
+	s.mu.Lock()
+	s.lastChecked = time.Now()
+	unchanged := err != nil || info.ModTime().Equal(s.modTime)
+	s.mu.Unlock()
+	if unchanged {
+		return
+	}
+	// 重新加载失败时保留旧的密钥集合，避免配置写坏导致全部失效
+	_ = s.reload()
+}
+
+func (s *FileAPIKeyStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("读取 API Key 文件失败: %w", err)
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("读取 API Key 文件失败: %w", err)
+	}
+
+	var file struct {
+		Keys []APIKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("解析 API Key 文件失败: %w", err)
+	}
+
+	keys := make(map[string]*APIKey, len(file.Keys))
+	for i := range file.Keys {
+		key := &file.Keys[i]
+		hash := normalizeKeyHash(key.Hash)
+		if hash == "" || strings.TrimSpace(key.UserID) == "" {
+			return fmt.Errorf("API Key %q 缺少 hash 或 userId", key.ID)
+		}
+		key.Hash = hash
+		keys[hash] = key
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.modTime = info.ModTime()
+	s.lastChecked = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func normalizeKeyHash(hash string) string {
+	hash = strings.ToLower(strings.TrimSpace(hash))
+	return strings.TrimPrefix(hash, "sha256:")
+}
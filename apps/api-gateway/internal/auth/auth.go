@@ -2,56 +2,108 @@ package auth
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
 )
 
 var ErrUnauthorized = errors.New("unauthorized")
 
+// maxSessionResponseBytes 会话响应的读取上限
+const maxSessionResponseBytes = 1 << 20
+
 type Config struct {
 	BetterAuthBaseURL     string
 	BetterAuthSessionPath string
 	GatewayInternalSecret string
 	CacheTTL              time.Duration
+	CacheSize             int // 认证缓存最大条目数，默认 10000
 	ClockSkew             time.Duration
+	APIKeyStore           APIKeyStore
+
+	// SessionCookieNames 用于认证的会话 Cookie 名，为空时使用 DefaultSessionCookieNames
+	SessionCookieNames []string
+
+	// 身份头签名：SigningKeys 为空时使用 GatewayInternalSecret 作为 SigningKeyID 对应的唯一密钥
+	SigningKeys      *gatewayidentity.Keyring
+	SigningKeyID     string
+	SignatureVersion string
+
+	// ClaimPaths 覆盖 DefaultClaimPaths 中的声明路径，值为空字符串表示不提取该声明
+	ClaimPaths map[string]string
+	// PropagateClaims 以签名头转发给后端的声明，nil 时使用 DefaultPropagateClaims
+	PropagateClaims []string
+
+	// JWT 非空时启用 OIDC/JWT Bearer 认证
+	JWT *JWTConfig
+
+	// NegativeCacheTTL 无效 Cookie 的缓存时间，0 使用默认 5 秒，负数表示不缓存
+	NegativeCacheTTL time.Duration
+	// BreakerThreshold 连续失败多少次后熔断 Better Auth 查询，默认 5
+	BreakerThreshold int
+	// BreakerCooldown 熔断后多久放行探测请求，默认 10 秒
+	BreakerCooldown time.Duration
 }
 
+// Method 表示请求的认证方式
+type Method string
+
+const (
+	MethodSession Method = "session" // Better Auth 会话 Cookie
+	MethodAPIKey  Method = "api_key" // Authorization: Bearer <api-key>
+	MethodJWT     Method = "jwt"     // Authorization: Bearer <OIDC/JWT 令牌>
+)
+
 type Identity struct {
 	UserID string
+	Method Method
+	Scopes []string
+
+	// 以下声明来自 Better Auth 会话或 JWT，API Key 认证时仅有 ExpiresAt
+	Email          string
+	Roles          []string
+	OrganizationID string
+	// SessionID Better Auth 会话 ID，用于吊销缓存
+	SessionID string
+	// ExpiresAt 会话或 API Key 的过期时间，零值表示未知
+	ExpiresAt time.Time
 }
 
 type Authenticator struct {
 	cfg    Config
+	signer *gatewayidentity.Signer
+	jwt    *jwtVerifier
 	client *http.Client
-	cache  map[string]cacheEntry
-	mu     sync.RWMutex
+	cache  *identityCache
+	// negativeCache 认证失败的 Cookie 单独缓存，随机 Cookie 洪泛不会挤出有效会话
+	negativeCache *identityCache
+
+	lookups  flightGroup
+	breaker  *circuitBreaker
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// revokedAt 最近被吊销的会话/用户，防止吊销前发起的查询把结果重新写回缓存
+	revokedMu sync.Mutex
+	revokedAt map[string]time.Time
 }
 
 type cacheEntry struct {
-	userID    string
+	identity  Identity
 	expiresAt time.Time
 }
 
-type sessionResponse struct {
-	User *struct {
-		ID string `json:"id"`
-	} `json:"user"`
-	Data *struct {
-		User *struct {
-			ID string `json:"id"`
-		} `json:"user"`
-	} `json:"data"`
-}
+// defaultNegativeCacheTTL 认证失败结果的默认缓存时间
+const defaultNegativeCacheTTL = 5 * time.Second
 
 func NewAuthenticator(cfg Config) *Authenticator {
 	a := &Authenticator{
@@ -59,7 +111,17 @@ func NewAuthenticator(cfg Config) *Authenticator {
 		client: &http.Client{
 			Timeout: 5 * time.Second,
 		},
-		cache: make(map[string]cacheEntry),
+		cache: newIdentityCache(cfg.CacheSize),
+		// 认证失败缓存的容量为认证缓存的 1/10
+		negativeCache: newIdentityCache(max(cacheSize(cfg.CacheSize)/negativeCacheRatio, 1)),
+		breaker:       newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		stop:          make(chan struct{}),
+
+		revokedAt: make(map[string]time.Time),
+	}
+	a.signer = newSigner(cfg)
+	if cfg.JWT != nil {
+		a.jwt = newJWTVerifier(*cfg.JWT, cfg.ClockSkew, a.client)
 	}
 	go a.cleanupLoop()
 	return a
@@ -68,29 +130,96 @@ func NewAuthenticator(cfg Config) *Authenticator {
 func (a *Authenticator) cleanupLoop() {
 	ticker := time.NewTicker(60 * time.Second)
 	defer ticker.Stop()
-	for range ticker.C {
-		a.mu.Lock()
-		now := time.Now()
-		for k, entry := range a.cache {
-			if now.After(entry.expiresAt) {
-				delete(a.cache, k)
-			}
+	for {
+		select {
+		case <-ticker.C:
+			a.cache.purgeExpired(time.Now())
+			a.negativeCache.purgeExpired(time.Now())
+			a.pruneRevocations(time.Now())
+		case <-a.stop:
+			return
 		}
-		a.mu.Unlock()
 	}
 }
 
+// Stop 停止后台清理协程，可重复调用
+func (a *Authenticator) Stop() {
+	a.stopOnce.Do(func() { close(a.stop) })
+}
+
+// RevokeSession 立即清除指定会话的缓存，返回清除的条目数
+func (a *Authenticator) RevokeSession(sessionID string) int {
+	if sessionID == "" {
+		return 0
+	}
+	a.markRevoked("session:"+sessionID, time.Now())
+	return a.cache.removeFunc(func(entry cacheEntry) bool {
+		return entry.identity.SessionID == sessionID
+	})
+}
+
+// RevokeUser 立即清除指定用户所有会话的缓存，返回清除的条目数
+func (a *Authenticator) RevokeUser(userID string) int {
+	if userID == "" {
+		return 0
+	}
+	a.markRevoked("user:"+userID, time.Now())
+	return a.cache.removeFunc(func(entry cacheEntry) bool {
+		return entry.identity.UserID == userID
+	})
+}
+
+// Authenticate 使用 Cookie 头中的会话 Cookie 认证，其他 Cookie 会被忽略
 func (a *Authenticator) Authenticate(ctx context.Context, cookieHeader string) (*Identity, error) {
-	cookieHeader = strings.TrimSpace(cookieHeader)
+	cookieHeader = a.SessionCookies(cookieHeader)
 	if cookieHeader == "" {
 		return nil, ErrUnauthorized
 	}
 
 	cacheKey := hashString(cookieHeader)
-	if identity := a.getCached(cacheKey); identity != nil {
+	if identity, found := a.getCached(cacheKey); found {
+		if identity == nil {
+			return nil, ErrUnauthorized
+		}
+		return identity, nil
+	}
+
+	// 同一 Cookie 的并发请求只查询一次；查询不随单个请求取消，由 client 超时兜底
+	lookupCtx := context.WithoutCancel(ctx)
+	return a.lookups.do(cacheKey, func() (*Identity, error) {
+		return a.lookupSession(lookupCtx, cacheKey, cookieHeader)
+	})
+}
+
+// lookupSession 向 Better Auth 查询会话并写入缓存，认证失败的结果会被短暂缓存
+func (a *Authenticator) lookupSession(ctx context.Context, cacheKey string, cookieHeader string) (*Identity, error) {
+	if !a.breaker.allow() {
+		return nil, ErrAuthUnavailable
+	}
+	// 查询 panic 时同样记为失败，否则半开的探测名额不会释放，熔断器一直拒绝请求
+	report := a.breaker.failure
+	defer func() { report() }()
+
+	startedAt := time.Now()
+	identity, err := a.fetchSession(ctx, cookieHeader)
+	switch {
+	case err == nil:
+		report = a.breaker.success
+		if !a.revokedSince(identity, startedAt) {
+			a.setCached(cacheKey, identity)
+		}
 		return identity, nil
+	case errors.Is(err, ErrUnauthorized):
+		// Better Auth 正常响应，只是会话无效
+		report = a.breaker.success
+		a.setNegativeCached(cacheKey)
+		return nil, err
+	default:
+		return nil, err
 	}
+}
 
+func (a *Authenticator) fetchSession(ctx context.Context, cookieHeader string) (*Identity, error) {
 	sessionURL, err := a.sessionURL()
 	if err != nil {
 		return nil, err
@@ -108,46 +237,124 @@ func (a *Authenticator) Authenticate(ctx context.Context, cookieHeader string) (
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 500 {
+		return nil, fmt.Errorf("better auth session lookup failed: status %d", resp.StatusCode)
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, ErrUnauthorized
 	}
 
-	var payload sessionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSessionResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	identity, err := a.parseSessionIdentity(body)
+	if err != nil {
+		return nil, err
+	}
+	if !identity.ExpiresAt.IsZero() && !time.Now().Before(identity.ExpiresAt) {
 		return nil, ErrUnauthorized
 	}
+	return identity, nil
+}
 
-	userID := ""
-	if payload.User != nil {
-		userID = payload.User.ID
+// AuthenticateRequest 按路由允许的认证方式依次尝试认证，methods 为空时仅接受会话 Cookie
+// 请求携带 Bearer 凭证时只按 Bearer 认证，失败不会回退到 Cookie；
+// JWT 形式的凭证按 JWT 校验，其余按 API Key 校验
+func (a *Authenticator) AuthenticateRequest(ctx context.Context, r *http.Request, methods []Method) (*Identity, error) {
+	if len(methods) == 0 {
+		methods = []Method{MethodSession}
 	}
-	if userID == "" && payload.Data != nil && payload.Data.User != nil {
-		userID = payload.Data.User.ID
+	if token := BearerToken(r); token != "" {
+		if looksLikeJWT(token) && hasMethod(methods, MethodJWT) {
+			return a.AuthenticateJWT(ctx, token)
+		}
+		if !hasMethod(methods, MethodAPIKey) {
+			return nil, ErrUnauthorized
+		}
+		return a.AuthenticateAPIKey(ctx, token)
 	}
-	if strings.TrimSpace(userID) == "" {
-		return nil, ErrUnauthorized
+	if hasMethod(methods, MethodSession) {
+		return a.Authenticate(ctx, r.Header.Get("Cookie"))
 	}
+	return nil, ErrUnauthorized
+}
 
-	identity := &Identity{UserID: strings.TrimSpace(userID)}
-	a.setCached(cacheKey, identity)
+// AuthenticateAPIKey 校验 API Key 并映射为用户身份
+func (a *Authenticator) AuthenticateAPIKey(ctx context.Context, key string) (*Identity, error) {
+	key = strings.TrimSpace(key)
+	if key == "" || a.cfg.APIKeyStore == nil {
+		return nil, ErrUnauthorized
+	}
+	apiKey, err := a.cfg.APIKeyStore.LookupAPIKey(ctx, HashAPIKey(key))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == nil || apiKey.Disabled {
+		return nil, ErrUnauthorized
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return nil, ErrUnauthorized
+	}
+	identity := &Identity{
+		UserID: strings.TrimSpace(apiKey.UserID),
+		Method: MethodAPIKey,
+		Scopes: append([]string(nil), apiKey.Scopes...),
+	}
+	if apiKey.ExpiresAt != nil {
+		identity.ExpiresAt = *apiKey.ExpiresAt
+	}
 	return identity, nil
 }
 
-func (a *Authenticator) Sign(method string, path string, userID string) (timestamp string, nonce string, signature string, err error) {
-	timestamp = fmt.Sprintf("%d", time.Now().Unix())
-	nonce, err = randomNonce()
-	if err != nil {
-		return "", "", "", err
+// BearerToken 提取 Authorization: Bearer 头中的凭证
+func BearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+func hasMethod(methods []Method, method Method) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign 为转发到后端的请求生成身份签名
+func (a *Authenticator) Sign(payload gatewayidentity.Payload) (gatewayidentity.Signature, error) {
+	return a.signer.Sign(payload, time.Now())
+}
+
+// SignWithoutDigest 为不计算请求体摘要的请求（gRPC、流式上传）签名，v3 降级为 v2
+func (a *Authenticator) SignWithoutDigest(payload gatewayidentity.Payload) (gatewayidentity.Signature, error) {
+	return a.signer.SignWithoutDigest(payload, time.Now())
+}
+
+// SignsContentDigest 当前签名版本是否覆盖查询串与请求体摘要（v3）
+func (a *Authenticator) SignsContentDigest() bool {
+	return a.signer.SignsContentDigest()
+}
+
+func newSigner(cfg Config) *gatewayidentity.Signer {
+	keys := cfg.SigningKeys
+	if keys == nil && cfg.GatewayInternalSecret != "" {
+		keyID := cfg.SigningKeyID
+		if keyID == "" {
+			keyID = gatewayidentity.DefaultKeyID
+		}
+		keys, _ = gatewayidentity.NewKeyring(keyID, gatewayidentity.Key{ID: keyID, Secret: cfg.GatewayInternalSecret})
 	}
-	signature = Sign(a.cfg.GatewayInternalSecret, method, path, userID, timestamp, nonce)
-	return timestamp, nonce, signature, nil
+	return &gatewayidentity.Signer{Keys: keys, Version: cfg.SignatureVersion}
 }
 
+// Sign 与后端共用 pkg/gatewayidentity 中的签名实现，保证 payload 格式一致
 func Sign(secret string, method string, path string, userID string, timestamp string, nonce string) string {
-	payload := strings.Join([]string{method, path, userID, timestamp, nonce}, "\n")
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(payload))
-	return hex.EncodeToString(mac.Sum(nil))
+	return gatewayidentity.Sign(secret, method, path, userID, timestamp, nonce)
 }
 
 func (a *Authenticator) sessionURL() (string, error) {
@@ -166,65 +373,73 @@ func (a *Authenticator) sessionURL() (string, error) {
 	return baseURL.String(), nil
 }
 
-func (a *Authenticator) getCached(key string) *Identity {
-	if a.cfg.CacheTTL <= 0 {
-		return nil
-	}
-	a.mu.RLock()
-	entry, ok := a.cache[key]
-	a.mu.RUnlock()
-	if !ok || time.Now().After(entry.expiresAt) {
-		if ok {
-			a.mu.Lock()
-			delete(a.cache, key)
-			a.mu.Unlock()
+// revocationWindow 吊销记录的保留时间，需大于单次会话查询的超时时间
+const revocationWindow = time.Minute
+
+func (a *Authenticator) markRevoked(key string, now time.Time) {
+	a.revokedMu.Lock()
+	a.revokedAt[key] = now
+	a.revokedMu.Unlock()
+}
+
+// revokedSince 身份在 since 之后是否被吊销
+func (a *Authenticator) revokedSince(identity *Identity, since time.Time) bool {
+	a.revokedMu.Lock()
+	defer a.revokedMu.Unlock()
+	for _, key := range []string{"session:" + identity.SessionID, "user:" + identity.UserID} {
+		if at, ok := a.revokedAt[key]; ok && !at.Before(since) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Authenticator) pruneRevocations(now time.Time) {
+	a.revokedMu.Lock()
+	defer a.revokedMu.Unlock()
+	for key, at := range a.revokedAt {
+		if now.Sub(at) > revocationWindow {
+			delete(a.revokedAt, key)
 		}
-		return nil
 	}
-	return &Identity{UserID: entry.userID}
+}
+
+// getCached 返回缓存的身份；found 为 true 且 identity 为 nil 表示命中认证失败缓存
+func (a *Authenticator) getCached(key string) (identity *Identity, found bool) {
+	entry, ok := a.cache.get(key, time.Now())
+	if !ok {
+		_, negative := a.negativeCache.get(key, time.Now())
+		return nil, negative
+	}
+	copied := entry.identity
+	return &copied, true
 }
 
 func (a *Authenticator) setCached(key string, identity *Identity) {
 	if a.cfg.CacheTTL <= 0 {
 		return
 	}
-	a.mu.Lock()
-	defer a.mu.Unlock()
-
-	if len(a.cache) >= 10000 {
-		now := time.Now()
-		for k, entry := range a.cache {
-			if now.After(entry.expiresAt) {
-				delete(a.cache, k)
-			}
-		}
-		if len(a.cache) >= 10000 {
-			count := 0
-			for k := range a.cache {
-				delete(a.cache, k)
-				count++
-				if count >= 1000 {
-					break
-				}
-			}
-		}
+	// 缓存时间不超过会话本身的过期时间，避免过期会话在缓存中继续有效
+	expiresAt := time.Now().Add(a.cfg.CacheTTL)
+	if !identity.ExpiresAt.IsZero() && identity.ExpiresAt.Before(expiresAt) {
+		expiresAt = identity.ExpiresAt
 	}
+	a.cache.set(key, cacheEntry{identity: *identity, expiresAt: expiresAt})
+}
 
-	a.cache[key] = cacheEntry{
-		userID:    identity.UserID,
-		expiresAt: time.Now().Add(a.cfg.CacheTTL),
+// setNegativeCached 短暂缓存认证失败的 Cookie，削弱无效 Cookie 洪泛对 Better Auth 的压力
+func (a *Authenticator) setNegativeCached(key string) {
+	ttl := a.cfg.NegativeCacheTTL
+	if ttl == 0 {
+		ttl = defaultNegativeCacheTTL
 	}
+	if ttl < 0 {
+		return
+	}
+	a.negativeCache.set(key, cacheEntry{expiresAt: time.Now().Add(ttl)})
 }
 
 func hashString(value string) string {
 	sum := sha256.Sum256([]byte(value))
 	return hex.EncodeToString(sum[:])
 }
-
-func randomNonce() (string, error) {
-	bytes := make([]byte, 16)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(bytes), nil
-}
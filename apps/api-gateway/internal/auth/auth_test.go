@@ -3,8 +3,15 @@ package auth
 import (
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -80,3 +87,473 @@ func TestSignMatchesGatewayPayloadContract(t *testing.T) {
 		t.Fatalf("expected %s, got %s", expected, signature)
 	}
 }
+
+func TestAuthenticateRequestAcceptsAPIKeyFromFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"keys":[
+		{"id":"ci","hash":"sha256:` + HashAPIKey("secret-key") + `","userId":"ci-user","scopes":["agents:read"]},
+		{"id":"old","hash":"` + HashAPIKey("disabled-key") + `","userId":"ci-user","disabled":true}
+	]}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	store, err := NewFileAPIKeyStore(path)
+	if err != nil {
+		t.Fatalf("expected key file to load: %v", err)
+	}
+	authenticator := NewAuthenticator(Config{APIKeyStore: store})
+	methods := []Method{MethodSession, MethodAPIKey}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	req.Header.Set("Authorization", "Bearer secret-key")
+	identity, err := authenticator.AuthenticateRequest(context.Background(), req, methods)
+	if err != nil {
+		t.Fatalf("expected api key to authenticate: %v", err)
+	}
+	if identity.UserID != "ci-user" || identity.Method != MethodAPIKey || len(identity.Scopes) != 1 {
+		t.Fatalf("unexpected identity: %+v", identity)
+	}
+
+	for _, token := range []string{"disabled-key", "unknown-key"} {
+		req.Header.Set("Authorization", "Bearer "+token)
+		if _, err := authenticator.AuthenticateRequest(context.Background(), req, methods); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expected %s to be rejected, got %v", token, err)
+		}
+	}
+
+	// 路由未启用 API Key 时，Bearer 凭证直接被拒绝
+	req.Header.Set("Authorization", "Bearer secret-key")
+	if _, err := authenticator.AuthenticateRequest(context.Background(), req, nil); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected ErrUnauthorized when api keys are not allowed, got %v", err)
+	}
+}
+
+func TestAuthenticateExtractsSessionClaims(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":{"session":{"expiresAt":"` + expiresAt + `","activeOrganizationId":"org-1"},` +
+			`"user":{"id":"user-1","email":"a@example.com","role":"admin, member","tier":"pro"}}}`))
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     server.URL,
+		BetterAuthSessionPath: "/get-session",
+		ClaimPaths:            map[string]string{ClaimOrganization: ""},
+		PropagateClaims:       []string{ClaimEmail, ClaimRoles, ClaimOrganization},
+	})
+
+	identity, err := authenticator.Authenticate(context.Background(), "telos.session_token=ok")
+	if err != nil {
+		t.Fatalf("expected authenticate to succeed: %v", err)
+	}
+	if identity.Email != "a@example.com" || len(identity.Roles) != 2 || identity.Roles[1] != "member" {
+		t.Fatalf("unexpected claims %+v", identity)
+	}
+	if identity.OrganizationID != "" {
+		t.Fatalf("expected organization claim to be disabled, got %q", identity.OrganizationID)
+	}
+	if identity.ExpiresAt.Format(time.RFC3339) != expiresAt {
+		t.Fatalf("expected session expiry %s, got %s", expiresAt, identity.ExpiresAt)
+	}
+
+	headers := authenticator.ClaimHeaders(identity)
+	if headers["X-User-Email"] != "a@example.com" || headers["X-User-Roles"] != "admin,member" {
+		t.Fatalf("unexpected claim headers %v", headers)
+	}
+	if _, ok := headers["X-Organization-ID"]; ok {
+		t.Fatal("expected empty claims not to be propagated")
+	}
+}
+
+func TestAuthenticateCapsCacheAtSessionExpiry(t *testing.T) {
+	calls := 0
+	expiresAt := time.Now().Add(1500 * time.Millisecond).UnixMilli()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"session":{"expiresAt":` + strconv.FormatInt(expiresAt, 10) + `},"user":{"id":"user-1"}}`))
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     server.URL,
+		BetterAuthSessionPath: "/get-session",
+		CacheTTL:              time.Hour,
+	})
+
+	if _, err := authenticator.Authenticate(context.Background(), "telos.session_token=short"); err != nil {
+		t.Fatalf("expected authenticate to succeed: %v", err)
+	}
+	time.Sleep(1600 * time.Millisecond)
+	// 缓存随会话一起过期，重新查询后发现会话已过期
+	if _, err := authenticator.Authenticate(context.Background(), "telos.session_token=short"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected expired session to be rejected, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected cache entry to expire with the session, got %d calls", calls)
+	}
+}
+
+func TestAuthenticateCoalescesConcurrentLookups(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		_, _ = w.Write([]byte(`{"user":{"id":"user-1"}}`))
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     server.URL,
+		BetterAuthSessionPath: "/get-session",
+		CacheTTL:              time.Minute,
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			identity, err := authenticator.Authenticate(context.Background(), "telos.session_token=popular")
+			if err == nil && identity.UserID != "user-1" {
+				err = errors.New("unexpected user " + identity.UserID)
+			}
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("expected all lookups to succeed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected one Better Auth call, got %d", calls)
+	}
+}
+
+func TestFlightGroupReleasesWaitersWhenLeaderPanics(t *testing.T) {
+	var group flightGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (*Identity, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+			panic("boom")
+		}
+		return &Identity{UserID: "user-1"}, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := group.do("cookie", fn)
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected waiters to be released after the leader panicked")
+	}
+	close(errs)
+	for err := range errs {
+		if !errors.Is(err, ErrAuthUnavailable) {
+			t.Fatalf("expected panic to be reported as ErrAuthUnavailable, got %v", err)
+		}
+	}
+
+	// 飞行中的记录已删除，后续调用重新执行
+	if identity, err := group.do("cookie", fn); err != nil || identity.UserID != "user-1" {
+		t.Fatalf("expected a fresh call after the panic, got %+v, %v", identity, err)
+	}
+}
+
+func TestAuthenticateCachesInvalidCookiesBriefly(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     server.URL,
+		BetterAuthSessionPath: "/get-session",
+		CacheTTL:              time.Minute,
+		NegativeCacheTTL:      100 * time.Millisecond,
+	})
+
+	for i := 0; i < 3; i++ {
+		if _, err := authenticator.Authenticate(context.Background(), "telos.session_token=bad"); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected invalid cookie to be cached, got %d calls", calls)
+	}
+	time.Sleep(150 * time.Millisecond)
+	_, _ = authenticator.Authenticate(context.Background(), "telos.session_token=bad")
+	if calls != 2 {
+		t.Fatalf("expected negative cache to expire, got %d calls", calls)
+	}
+}
+
+func TestInvalidCookieFloodDoesNotEvictValidSessions(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("Cookie") != "telos.session_token=ok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"user":{"id":"user-1"}}`))
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     server.URL,
+		BetterAuthSessionPath: "/get-session",
+		CacheTTL:              time.Minute,
+		CacheSize:             20,
+	})
+	if _, err := authenticator.Authenticate(context.Background(), "telos.session_token=ok"); err != nil {
+		t.Fatalf("expected valid session: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		_, _ = authenticator.Authenticate(context.Background(), "telos.session_token=random-"+strconv.Itoa(i))
+	}
+	if n := authenticator.negativeCache.len(); n != 2 {
+		t.Fatalf("expected negative cache to be bounded to 1/10 of the cache, got %d", n)
+	}
+
+	before := atomic.LoadInt32(&calls)
+	if _, err := authenticator.Authenticate(context.Background(), "telos.session_token=ok"); err != nil {
+		t.Fatalf("expected valid session: %v", err)
+	}
+	if atomic.LoadInt32(&calls) != before {
+		t.Fatal("expected valid session to stay cached during an invalid cookie flood")
+	}
+}
+
+func TestAuthenticateOpensCircuitWhenBetterAuthFails(t *testing.T) {
+	var calls int32
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte(`{"user":{"id":"user-1"}}`))
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     server.URL,
+		BetterAuthSessionPath: "/get-session",
+		BreakerThreshold:      2,
+		BreakerCooldown:       100 * time.Millisecond,
+	})
+	authenticate := func(cookie string) error {
+		_, err := authenticator.Authenticate(context.Background(), "telos.session_token="+cookie)
+		return err
+	}
+
+	for _, cookie := range []string{"a", "b"} {
+		if err := authenticate(cookie); err == nil || errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expected upstream failure, got %v", err)
+		}
+	}
+	// 熔断打开后直接失败，不再请求 Better Auth
+	if err := authenticate("c"); !errors.Is(err, ErrAuthUnavailable) {
+		t.Fatalf("expected ErrAuthUnavailable, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected open circuit to skip Better Auth, got %d calls", calls)
+	}
+
+	// 冷却后探测成功，熔断关闭
+	healthy.Store(true)
+	time.Sleep(150 * time.Millisecond)
+	if err := authenticate("d"); err != nil {
+		t.Fatalf("expected probe to succeed: %v", err)
+	}
+	if err := authenticate("e"); err != nil {
+		t.Fatalf("expected circuit to be closed: %v", err)
+	}
+}
+
+// roundTripFunc 用函数模拟 Better Auth 的传输层
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestBreakerProbeIsReleasedWhenLookupPanics(t *testing.T) {
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     "http://better-auth.test",
+		BetterAuthSessionPath: "/get-session",
+		BreakerThreshold:      1,
+		BreakerCooldown:       50 * time.Millisecond,
+	})
+	var mode atomic.Value
+	authenticator.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		switch mode.Load() {
+		case "panic":
+			panic("boom")
+		case "healthy":
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"user":{"id":"user-1"}}`))}, nil
+		}
+		return nil, errors.New("connection refused")
+	})
+	authenticate := func(cookie string) error {
+		_, err := authenticator.Authenticate(context.Background(), "telos.session_token="+cookie)
+		return err
+	}
+
+	mode.Store("down")
+	if err := authenticate("a"); err == nil {
+		t.Fatal("expected upstream failure to open the circuit")
+	}
+	// 半开探测时查询 panic
+	mode.Store("panic")
+	time.Sleep(60 * time.Millisecond)
+	if err := authenticate("b"); !errors.Is(err, ErrAuthUnavailable) {
+		t.Fatalf("expected panicking probe to fail with ErrAuthUnavailable, got %v", err)
+	}
+	// panic 的探测记为失败，下一次冷却结束后仍能探测并关闭熔断
+	mode.Store("healthy")
+	time.Sleep(60 * time.Millisecond)
+	if err := authenticate("c"); err != nil {
+		t.Fatalf("expected a new probe after the panicking one, got %v", err)
+	}
+}
+
+func TestIdentityCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newIdentityCache(2)
+	now := time.Now()
+	entry := func(userID string) cacheEntry {
+		return cacheEntry{identity: Identity{UserID: userID}, expiresAt: now.Add(time.Minute)}
+	}
+
+	cache.set("a", entry("a"))
+	cache.set("b", entry("b"))
+	cache.get("a", now) // a 变为最近使用
+	cache.set("c", entry("c"))
+
+	if _, ok := cache.get("b", now); ok {
+		t.Fatal("expected least recently used entry b to be evicted")
+	}
+	if _, ok := cache.get("a", now); !ok {
+		t.Fatal("expected recently used entry a to survive")
+	}
+	if _, ok := cache.get("c", now.Add(2*time.Minute)); ok {
+		t.Fatal("expected expired entry to be dropped")
+	}
+	if cache.len() != 1 {
+		t.Fatalf("expected expired entry to be removed, got %d entries", cache.len())
+	}
+}
+
+func TestRevokeSessionPurgesCachedIdentity(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		cookie, _ := r.Cookie("telos.session_token")
+		_, _ = w.Write([]byte(`{"session":{"id":"session-` + cookie.Value + `"},"user":{"id":"user-1"}}`))
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     server.URL,
+		BetterAuthSessionPath: "/get-session",
+		CacheTTL:              time.Minute,
+	})
+	defer authenticator.Stop()
+
+	for _, cookie := range []string{"a", "b"} {
+		if _, err := authenticator.Authenticate(context.Background(), "telos.session_token="+cookie); err != nil {
+			t.Fatalf("expected authenticate to succeed: %v", err)
+		}
+	}
+	if revoked := authenticator.RevokeSession("session-a"); revoked != 1 {
+		t.Fatalf("expected one entry to be revoked, got %d", revoked)
+	}
+	_, _ = authenticator.Authenticate(context.Background(), "telos.session_token=a")
+	_, _ = authenticator.Authenticate(context.Background(), "telos.session_token=b")
+	if calls != 3 {
+		t.Fatalf("expected only the revoked session to be looked up again, got %d calls", calls)
+	}
+
+	if revoked := authenticator.RevokeUser("user-1"); revoked != 2 {
+		t.Fatalf("expected all sessions of user-1 to be revoked, got %d", revoked)
+	}
+	authenticator.Stop() // 重复调用不会 panic
+}
+
+func TestAuthenticateUsesOnlySessionCookies(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if got := r.Header.Get("Cookie"); got != "__Secure-telos.session_token=ok" {
+			t.Errorf("expected only the session cookie to be forwarded, got %q", got)
+		}
+		_, _ = w.Write([]byte(`{"user":{"id":"user-1"}}`))
+	}))
+	defer server.Close()
+
+	authenticator := NewAuthenticator(Config{
+		BetterAuthBaseURL:     server.URL,
+		BetterAuthSessionPath: "/get-session",
+		CacheTTL:              time.Minute,
+	})
+	defer authenticator.Stop()
+
+	// 统计类 Cookie 变化不影响缓存命中
+	for _, header := range []string{
+		"_ga=GA1.1; __Secure-telos.session_token=ok; theme=dark",
+		"_ga=GA1.2; __Secure-telos.session_token=ok",
+	} {
+		if _, err := authenticator.Authenticate(context.Background(), header); err != nil {
+			t.Fatalf("expected authenticate to succeed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected unrelated cookies not to affect the cache key, got %d calls", calls)
+	}
+	if _, err := authenticator.Authenticate(context.Background(), "_ga=GA1.1; theme=dark"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected request without session cookie to be rejected, got %v", err)
+	}
+
+	header := http.Header{}
+	header.Set("Cookie", "_ga=GA1.1; telos.session_token=ok; __Secure-telos.session_data=x; theme=dark")
+	authenticator.StripSessionCookies(header)
+	if got := header.Get("Cookie"); got != "_ga=GA1.1; theme=dark" {
+		t.Fatalf("expected session cookies to be stripped, got %q", got)
+	}
+	header.Set("Cookie", "telos.session_token=ok")
+	authenticator.StripSessionCookies(header)
+	if _, ok := header["Cookie"]; ok {
+		t.Fatal("expected empty cookie header to be removed")
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize 认证缓存默认容量；认证失败缓存的容量为其 1/negativeCacheRatio
+const (
+	defaultCacheSize   = 10000
+	negativeCacheRatio = 10
+)
+
+func cacheSize(capacity int) int {
+	if capacity <= 0 {
+		return defaultCacheSize
+	}
+	return capacity
+}
+
+// identityCache 带 TTL 的 LRU 缓存，容量满时淘汰最久未使用的条目
+type identityCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // 队首为最近使用
+	items    map[string]*list.Element
+}
+
+type cacheItem struct {
+	key   string
+	entry cacheEntry
+}
+
+func newIdentityCache(capacity int) *identityCache {
+	return &identityCache{
+		capacity: cacheSize(capacity),
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get 返回未过期的条目并标记为最近使用，过期条目会被删除
+func (c *identityCache) get(key string, now time.Time) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	item := elem.Value.(*cacheItem)
+	if now.After(item.entry.expiresAt) {
+		c.removeElement(elem)
+		return cacheEntry{}, false
+	}
+	c.order.MoveToFront(elem)
+	return item.entry, true
+}
+
+func (c *identityCache) set(key string, entry cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheItem).entry = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheItem{key: key, entry: entry})
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// removeFunc 删除所有满足条件的条目，返回删除数量
+func (c *identityCache) removeFunc(match func(entry cacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if match(elem.Value.(*cacheItem).entry) {
+			c.removeElement(elem)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+// purgeExpired 清理过期条目
+func (c *identityCache) purgeExpired(now time.Time) int {
+	return c.removeFunc(func(entry cacheEntry) bool {
+		return now.After(entry.expiresAt)
+	})
+}
+
+func (c *identityCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *identityCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*cacheItem).key)
+}
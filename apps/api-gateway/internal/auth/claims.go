@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 可提取的会话声明名称，用于 Config.ClaimPaths 与 Config.PropagateClaims
+const (
+	ClaimUserID           = "user_id"
+	ClaimEmail            = "email"
+	ClaimRoles            = "roles"
+	ClaimOrganization     = "organization"
+	ClaimSessionExpiresAt = "session_expires_at"
+	ClaimSessionID        = "session_id"
+)
+
+// DefaultClaimPaths Better Auth get-session 响应中各声明的默认位置（点分隔的 JSON 路径）
+var DefaultClaimPaths = map[string]string{
+	ClaimUserID:           "user.id",
+	ClaimEmail:            "user.email",
+	ClaimRoles:            "user.role",
+	ClaimOrganization:     "session.activeOrganizationId",
+	ClaimSessionExpiresAt: "session.expiresAt",
+	ClaimSessionID:        "session.id",
+}
+
+// DefaultPropagateClaims 默认以签名头转发给后端的声明
+var DefaultPropagateClaims = []string{ClaimEmail, ClaimRoles, ClaimOrganization}
+
+// claimHeaders 声明转发到后端时使用的请求头，均会被网关从客户端请求中剥离
+var claimHeaders = map[string]string{
+	ClaimEmail:            "X-User-Email",
+	ClaimRoles:            "X-User-Roles",
+	ClaimOrganization:     "X-Organization-ID",
+	ClaimSessionExpiresAt: "X-Session-Expires-At",
+}
+
+// ClaimHeaderNames 返回所有声明头，供代理剥离客户端伪造的同名头
+func ClaimHeaderNames() []string {
+	names := make([]string, 0, len(claimHeaders))
+	for _, header := range claimHeaders {
+		names = append(names, header)
+	}
+	return names
+}
+
+// ClaimHeaders 按配置返回需要转发的声明头，空值不转发
+func (a *Authenticator) ClaimHeaders(identity *Identity) map[string]string {
+	if identity == nil {
+		return nil
+	}
+	claims := a.cfg.PropagateClaims
+	if claims == nil {
+		claims = DefaultPropagateClaims
+	}
+	headers := make(map[string]string, len(claims))
+	for _, claim := range claims {
+		header, ok := claimHeaders[claim]
+		if !ok {
+			continue
+		}
+		if value := identity.claimValue(claim); value != "" {
+			headers[header] = value
+		}
+	}
+	return headers
+}
+
+func (i *Identity) claimValue(claim string) string {
+	switch claim {
+	case ClaimEmail:
+		return i.Email
+	case ClaimRoles:
+		return strings.Join(i.Roles, ",")
+	case ClaimOrganization:
+		return i.OrganizationID
+	case ClaimSessionExpiresAt:
+		if i.ExpiresAt.IsZero() {
+			return ""
+		}
+		return strconv.FormatInt(i.ExpiresAt.Unix(), 10)
+	default:
+		return ""
+	}
+}
+
+// parseSessionIdentity 按声明路径从会话响应中提取身份，兼容包在 data 中的响应
+func (a *Authenticator) parseSessionIdentity(body []byte) (*Identity, error) {
+	var root map[string]any
+	if err := json.Unmarshal(body, &root); err != nil {
+		return nil, ErrUnauthorized
+	}
+	if data, ok := root["data"].(map[string]any); ok && root["user"] == nil {
+		root = data
+	}
+
+	identity := &Identity{
+		UserID:         lookupString(root, a.claimPath(ClaimUserID)),
+		Method:         MethodSession,
+		Email:          lookupString(root, a.claimPath(ClaimEmail)),
+		Roles:          lookupList(root, a.claimPath(ClaimRoles)),
+		OrganizationID: lookupString(root, a.claimPath(ClaimOrganization)),
+		ExpiresAt:      lookupTime(root, a.claimPath(ClaimSessionExpiresAt)),
+		SessionID:      lookupString(root, a.claimPath(ClaimSessionID)),
+	}
+	if identity.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	return identity, nil
+}
+
+func (a *Authenticator) claimPath(claim string) string {
+	if path, ok := a.cfg.ClaimPaths[claim]; ok {
+		return path
+	}
+	return DefaultClaimPaths[claim]
+}
+
+func lookupPath(root map[string]any, path string) any {
+	if path == "" {
+		return nil
+	}
+	var current any = root
+	for _, part := range strings.Split(path, ".") {
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil
+		}
+		current = object[part]
+	}
+	return current
+}
+
+func lookupString(root map[string]any, path string) string {
+	switch value := lookupPath(root, path).(type) {
+	case string:
+		return strings.TrimSpace(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+// lookupList 同时支持数组和逗号分隔的字符串（Better Auth admin 插件的 role 字段）
+func lookupList(root map[string]any, path string) []string {
+	var items []string
+	switch value := lookupPath(root, path).(type) {
+	case string:
+		items = strings.Split(value, ",")
+	case []any:
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return nil
+	}
+	return list
+}
+
+// lookupTime 支持 RFC 3339 字符串和 Unix 时间戳（秒或毫秒）
+func lookupTime(root map[string]any, path string) time.Time {
+	switch value := lookupPath(root, path).(type) {
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(value))
+		if err != nil {
+			return time.Time{}
+		}
+		return parsed
+	case float64:
+		if value > 1e12 {
+			return time.UnixMilli(int64(value))
+		}
+		return time.Unix(int64(value), 0)
+	default:
+		return time.Time{}
+	}
+}
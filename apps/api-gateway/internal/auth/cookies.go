@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DefaultSessionCookieNames Better Auth（cookiePrefix = telos）使用的会话 Cookie，
+// 生产环境开启 Secure 时带 __Secure- 前缀
+var DefaultSessionCookieNames = []string{
+	"telos.session_token",
+	"__Secure-telos.session_token",
+	"telos.session_data",
+	"__Secure-telos.session_data",
+	"telos.dont_remember",
+	"__Secure-telos.dont_remember",
+}
+
+func (a *Authenticator) sessionCookieNames() []string {
+	if len(a.cfg.SessionCookieNames) > 0 {
+		return a.cfg.SessionCookieNames
+	}
+	return DefaultSessionCookieNames
+}
+
+// SessionCookies 从 Cookie 头中只挑出会话 Cookie，按配置顺序拼接；没有会话 Cookie 时返回空字符串
+// 无关 Cookie（如统计类）不参与缓存键，也不会发送给 Better Auth
+func (a *Authenticator) SessionCookies(cookieHeader string) string {
+	values := parseCookieHeader(cookieHeader)
+	parts := make([]string, 0, 2)
+	for _, name := range a.sessionCookieNames() {
+		if value, ok := values[name]; ok && value != "" {
+			parts = append(parts, name+"="+value)
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// StripSessionCookies 从转发给后端的请求中移除会话 Cookie，其余 Cookie 保留
+func (a *Authenticator) StripSessionCookies(header http.Header) {
+	names := make(map[string]bool)
+	for _, name := range a.sessionCookieNames() {
+		names[name] = true
+	}
+
+	var kept []string
+	for _, line := range header.Values("Cookie") {
+		for _, part := range strings.Split(line, ";") {
+			part = strings.TrimSpace(part)
+			name, _, _ := strings.Cut(part, "=")
+			if part == "" || names[strings.TrimSpace(name)] {
+				continue
+			}
+			kept = append(kept, part)
+		}
+	}
+	header.Del("Cookie")
+	if len(kept) > 0 {
+		header.Set("Cookie", strings.Join(kept, "; "))
+	}
+}
+
+// parseCookieHeader 宽松地解析 Cookie 头，忽略格式不规范的条目；同名 Cookie 取第一个
+func parseCookieHeader(cookieHeader string) map[string]string {
+	values := make(map[string]string)
+	for _, part := range strings.Split(cookieHeader, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			continue
+		}
+		if _, exists := values[name]; !exists {
+			values[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return values
+}
@@ -0,0 +1,449 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWTConfig OIDC/JWT Bearer 认证配置
+type JWTConfig struct {
+	// JWKSURL 公钥集地址；为空时通过 Issuer 的 /.well-known/openid-configuration 发现
+	JWKSURL string
+	// Issuer 必填，要求 iss 与之相等
+	Issuer string
+	// Audiences 必填，要求 aud 至少包含其中之一，避免接受同一 IdP 签发给其他应用的令牌
+	Audiences []string
+	// RefreshInterval JWKS 缓存时间，默认 10 分钟；遇到未知 kid 时会提前刷新，
+	// 但两次拉取（无论成败）至少间隔 30 秒
+	RefreshInterval time.Duration
+}
+
+const (
+	defaultJWKSRefreshInterval = 10 * time.Minute
+	// jwksMinRefreshInterval 两次拉取 JWKS 的最小间隔（无论上次成功与否），避免伪造 kid 或 IdP 故障时打满 IdP
+	jwksMinRefreshInterval = 30 * time.Second
+	maxJWKSResponseBytes   = 1 << 20
+)
+
+var errUnsupportedAlgorithm = errors.New("unsupported jwt algorithm")
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Email     string          `json:"email"`
+	Roles     json.RawMessage `json:"roles"`
+	Scope     string          `json:"scope"`
+	OrgID     string          `json:"org_id"`
+}
+
+// jwtVerifier 校验 JWT 签名与标准声明，JWKS 按需拉取并缓存
+type jwtVerifier struct {
+	cfg       JWTConfig
+	clockSkew time.Duration
+	client    *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	jwksURL   string
+
+	// refreshMu 保护以下字段：同一时间只有一次拉取，其余调用者等待它的结果
+	refreshMu   sync.Mutex
+	refreshing  *jwksRefresh
+	attemptedAt time.Time
+	attemptErr  error
+}
+
+// jwksRefresh 一次进行中的 JWKS 拉取
+type jwksRefresh struct {
+	done chan struct{}
+	err  error
+}
+
+// Validate 检查必填项：未配置 iss/aud 时同一 IdP 签发给任何应用的令牌都能通过校验
+func (c JWTConfig) Validate() error {
+	if c.Issuer == "" {
+		return errors.New("jwt: Issuer is required")
+	}
+	if len(c.Audiences) == 0 {
+		return errors.New("jwt: Audiences is required")
+	}
+	return nil
+}
+
+func newJWTVerifier(cfg JWTConfig, clockSkew time.Duration, client *http.Client) *jwtVerifier {
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = defaultJWKSRefreshInterval
+	}
+	return &jwtVerifier{cfg: cfg, clockSkew: clockSkew, client: client, jwksURL: cfg.JWKSURL}
+}
+
+// AuthenticateJWT 校验 OIDC/JWT Bearer 令牌，sub 映射为用户 ID
+func (a *Authenticator) AuthenticateJWT(ctx context.Context, token string) (*Identity, error) {
+	if a.jwt == nil {
+		return nil, ErrUnauthorized
+	}
+	return a.jwt.verify(ctx, strings.TrimSpace(token), time.Now())
+}
+
+// looksLikeJWT 判断 Bearer 凭证是否为 JWT（三段 base64url）
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
+
+func (v *jwtVerifier) verify(ctx context.Context, token string, now time.Time) (*Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnauthorized
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrUnauthorized
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, ErrUnauthorized
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrUnauthorized
+	}
+	if err := v.validateClaims(claims, now); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		UserID:         strings.TrimSpace(claims.Subject),
+		Method:         MethodJWT,
+		Email:          claims.Email,
+		Roles:          stringOrList(claims.Roles),
+		Scopes:         strings.Fields(claims.Scope),
+		OrganizationID: claims.OrgID,
+		ExpiresAt:      time.Unix(int64(*claims.ExpiresAt), 0),
+	}
+	if identity.UserID == "" {
+		return nil, ErrUnauthorized
+	}
+	return identity, nil
+}
+
+func (v *jwtVerifier) validateClaims(claims jwtClaims, now time.Time) error {
+	if claims.ExpiresAt == nil || now.After(time.Unix(int64(*claims.ExpiresAt), 0).Add(v.clockSkew)) {
+		return ErrUnauthorized
+	}
+	if claims.NotBefore != nil && now.Add(v.clockSkew).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return ErrUnauthorized
+	}
+	// 配置缺少 iss/aud 时拒绝所有令牌，而不是跳过校验
+	if v.cfg.Issuer == "" || claims.Issuer != v.cfg.Issuer {
+		return ErrUnauthorized
+	}
+	if len(v.cfg.Audiences) == 0 || !containsAudience(stringOrList(claims.Audience), v.cfg.Audiences) {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// key 返回 kid 对应的公钥；缓存过期或遇到未知 kid 时重新拉取 JWKS
+func (v *jwtVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.RLock()
+	key, ok := v.keys[kid]
+	age := time.Since(v.fetchedAt)
+	v.mu.RUnlock()
+	if ok && age < v.cfg.RefreshInterval {
+		return key, nil
+	}
+
+	if err := v.refreshKeys(ctx); err != nil {
+		// IdP 暂时不可用时继续使用缓存中的公钥
+		if ok {
+			return key, nil
+		}
+		return nil, err
+	}
+	v.mu.RLock()
+	key, ok = v.keys[kid]
+	v.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	return key, nil
+}
+
+// refreshKeys 合并并发的 JWKS 拉取：已有拉取进行中时等待它的结果；
+// 距离上次拉取不足 jwksMinRefreshInterval 时不再请求 IdP，直接返回上次的结果
+func (v *jwtVerifier) refreshKeys(ctx context.Context) error {
+	v.refreshMu.Lock()
+	if call := v.refreshing; call != nil {
+		v.refreshMu.Unlock()
+		select {
+		case <-call.done:
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if !v.attemptedAt.IsZero() && time.Since(v.attemptedAt) < jwksMinRefreshInterval {
+		err := v.attemptErr
+		v.refreshMu.Unlock()
+		return err
+	}
+	call := &jwksRefresh{done: make(chan struct{})}
+	v.refreshing = call
+	v.refreshMu.Unlock()
+
+	defer func() {
+		v.refreshMu.Lock()
+		v.refreshing = nil
+		v.attemptedAt = time.Now()
+		v.attemptErr = call.err
+		v.refreshMu.Unlock()
+		close(call.done)
+	}()
+	// 发起拉取的请求被取消时不影响其他等待者，拉取时长由 HTTP 客户端的超时限制
+	call.err = v.refresh(context.WithoutCancel(ctx))
+	return call.err
+}
+
+func (v *jwtVerifier) refresh(ctx context.Context) error {
+	jwksURL, err := v.resolveJWKSURL(ctx)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.fetchJSON(ctx, jwksURL, &set); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// 跳过不支持的密钥类型，不影响其他密钥
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+// resolveJWKSURL 未配置 JWKS 地址时通过 OIDC 发现文档获取 jwks_uri
+func (v *jwtVerifier) resolveJWKSURL(ctx context.Context) (string, error) {
+	v.mu.RLock()
+	jwksURL := v.jwksURL
+	v.mu.RUnlock()
+	if jwksURL != "" {
+		return jwksURL, nil
+	}
+	if v.cfg.Issuer == "" {
+		return "", errors.New("jwt: JWKSURL or Issuer is required")
+	}
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	discoveryURL := strings.TrimRight(v.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := v.fetchJSON(ctx, discoveryURL, &discovery); err != nil {
+		return "", err
+	}
+	if discovery.JWKSURI == "" {
+		return "", errors.New("jwt: discovery document has no jwks_uri")
+	}
+	v.mu.Lock()
+	v.jwksURL = discovery.JWKSURI
+	v.mu.Unlock()
+	return discovery.JWKSURI, nil
+}
+
+func (v *jwtVerifier) fetchJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwt: fetch %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxJWKSResponseBytes)).Decode(out)
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errUnsupportedAlgorithm
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return nil, errors.New("jwt: invalid EC key")
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errUnsupportedAlgorithm
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jwt: invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errUnsupportedAlgorithm
+	}
+}
+
+// verifyJWTSignature 校验签名，算法必须与密钥类型一致，拒绝 none 和 HMAC
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errUnsupportedAlgorithm
+		}
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, sum[:], signature)
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errUnsupportedAlgorithm
+		}
+		sum := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, sum[:], r, s) {
+			return ErrUnauthorized
+		}
+		return nil
+	case "EdDSA":
+		edKey, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errUnsupportedAlgorithm
+		}
+		if !ed25519.Verify(edKey, signed, signature) {
+			return ErrUnauthorized
+		}
+		return nil
+	default:
+		return errUnsupportedAlgorithm
+	}
+}
+
+func decodeSegment(segment string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("jwt: invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// stringOrList 解析字符串或字符串数组形式的声明（aud、roles）
+func stringOrList(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		if single == "" {
+			return nil
+		}
+		return []string{single}
+	}
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+	return nil
+}
+
+func containsAudience(audiences []string, allowed []string) bool {
+	for _, aud := range audiences {
+		for _, a := range allowed {
+			if aud == a {
+				return true
+			}
+		}
+	}
+	return false
+}
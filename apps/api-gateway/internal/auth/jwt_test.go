@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testSigner struct {
+	kid  string
+	alg  string
+	jwk  map[string]string
+	sign func(data []byte) []byte
+}
+
+func newTestSigners(t *testing.T) []testSigner {
+	t.Helper()
+	b64 := base64.RawURLEncoding.EncodeToString
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	return []testSigner{
+		{
+			kid: "rsa", alg: "RS256",
+			jwk: map[string]string{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			sign: func(data []byte) []byte {
+				sum := sha256.Sum256(data)
+				sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sum[:])
+				return sig
+			},
+		},
+		{
+			kid: "ec", alg: "ES256",
+			jwk: map[string]string{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			sign: func(data []byte) []byte {
+				sum := sha256.Sum256(data)
+				r, s, _ := ecdsa.Sign(rand.Reader, ecKey, sum[:])
+				return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+			},
+		},
+		{
+			kid: "ed", alg: "EdDSA",
+			jwk:  map[string]string{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+			sign: func(data []byte) []byte { return ed25519.Sign(edPriv, data) },
+		},
+	}
+}
+
+func (s testSigner) token(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": s.alg, "kid": s.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(s.sign([]byte(signed)))
+}
+
+func newJWKSServer(t *testing.T, signers []testSigner, fetches *int32) *httptest.Server {
+	t.Helper()
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"issuer": server.URL, "jwks_uri": server.URL + "/jwks"})
+		case "/jwks":
+			atomic.AddInt32(fetches, 1)
+			keys := make([]map[string]string, 0, len(signers))
+			for _, s := range signers {
+				keys = append(keys, s.jwk)
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAuthenticateJWTSupportsRSAECDSAAndEdDSA(t *testing.T) {
+	signers := newTestSigners(t)
+	var fetches int32
+	server := newJWKSServer(t, signers, &fetches)
+	authenticator := NewAuthenticator(Config{
+		ClockSkew: time.Minute,
+		JWT:       &JWTConfig{Issuer: server.URL, Audiences: []string{"telos"}},
+	})
+
+	now := time.Now()
+	for _, signer := range signers {
+		token := signer.token(map[string]any{
+			"sub": "user-" + signer.kid, "iss": server.URL, "aud": []string{"other", "telos"},
+			"exp": now.Add(time.Hour).Unix(), "nbf": now.Unix(), "email": "a@example.com", "scope": "agents:read runs:read",
+		})
+		req := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		identity, err := authenticator.AuthenticateRequest(context.Background(), req, []Method{MethodSession, MethodJWT})
+		if err != nil {
+			t.Fatalf("%s: expected token to verify: %v", signer.alg, err)
+		}
+		if identity.UserID != "user-"+signer.kid || identity.Method != MethodJWT || len(identity.Scopes) != 2 {
+			t.Fatalf("%s: unexpected identity %+v", signer.alg, identity)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("expected JWKS to be cached, got %d fetches", fetches)
+	}
+}
+
+func TestAuthenticateJWTRejectsInvalidClaims(t *testing.T) {
+	signers := newTestSigners(t)
+	var fetches int32
+	server := newJWKSServer(t, signers, &fetches)
+	authenticator := NewAuthenticator(Config{
+		ClockSkew: time.Minute,
+		JWT:       &JWTConfig{JWKSURL: server.URL + "/jwks", Issuer: "https://issuer", Audiences: []string{"telos"}},
+	})
+
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{"sub": "user-1", "iss": "https://issuer", "aud": "telos", "exp": now.Add(time.Hour).Unix()}
+	}
+	cases := map[string]func(claims map[string]any){
+		"wrong issuer":   func(c map[string]any) { c["iss"] = "https://evil" },
+		"wrong audience": func(c map[string]any) { c["aud"] = "other" },
+		"expired":        func(c map[string]any) { c["exp"] = now.Add(-2 * time.Minute).Unix() },
+		"not yet valid":  func(c map[string]any) { c["nbf"] = now.Add(2 * time.Minute).Unix() },
+		"missing exp":    func(c map[string]any) { delete(c, "exp") },
+		"missing sub":    func(c map[string]any) { delete(c, "sub") },
+	}
+	for name, mutate := range cases {
+		claims := valid()
+		mutate(claims)
+		if _, err := authenticator.AuthenticateJWT(context.Background(), signers[0].token(claims)); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("%s: expected ErrUnauthorized, got %v", name, err)
+		}
+	}
+
+	// 在时钟偏差范围内过期的令牌仍然有效
+	claims := valid()
+	claims["exp"] = now.Add(-30 * time.Second).Unix()
+	if _, err := authenticator.AuthenticateJWT(context.Background(), signers[1].token(claims)); err != nil {
+		t.Fatalf("expected token within clock skew to verify: %v", err)
+	}
+
+	// 篡改签名、未知 kid 与 alg=none 都会被拒绝
+	token := signers[2].token(valid())
+	tampered := token[:len(token)-4] + "AAAA"
+	unknown := testSigner{kid: "missing", alg: "EdDSA", sign: signers[2].sign}.token(valid())
+	none := testSigner{kid: "rsa", alg: "none", sign: func([]byte) []byte { return nil }}.token(valid())
+	for _, bad := range []string{tampered, unknown, none} {
+		if _, err := authenticator.AuthenticateJWT(context.Background(), bad); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expected invalid token to be rejected, got %v", err)
+		}
+	}
+}
+
+func TestAuthenticateRequestRequiresJWTMethodOnRoute(t *testing.T) {
+	signers := newTestSigners(t)
+	var fetches int32
+	server := newJWKSServer(t, signers, &fetches)
+	authenticator := NewAuthenticator(Config{JWT: &JWTConfig{JWKSURL: server.URL + "/jwks", Issuer: "https://issuer", Audiences: []string{"telos"}}})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	req.Header.Set("Authorization", "Bearer "+signers[0].token(map[string]any{
+		"sub": "user-1", "iss": "https://issuer", "aud": "telos", "exp": time.Now().Add(time.Hour).Unix(),
+	}))
+	if _, err := authenticator.AuthenticateRequest(context.Background(), req, []Method{MethodSession}); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected JWT to be rejected on cookie-only route, got %v", err)
+	}
+	if identity, err := authenticator.AuthenticateRequest(context.Background(), req, []Method{MethodJWT}); err != nil || identity.UserID != "user-1" {
+		t.Fatalf("expected JWT to be accepted on JWT route, got %+v, %v", identity, err)
+	}
+}
+
+func TestAuthenticateJWTRequiresIssuerAndAudience(t *testing.T) {
+	signers := newTestSigners(t)
+	var fetches int32
+	server := newJWKSServer(t, signers, &fetches)
+	// 同一 IdP 签发给其他应用的令牌
+	token := signers[0].token(map[string]any{
+		"sub": "user-1", "iss": "https://issuer", "aud": "another-app", "exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	for name, cfg := range map[string]JWTConfig{
+		"missing audience": {JWKSURL: server.URL + "/jwks", Issuer: "https://issuer"},
+		"missing issuer":   {JWKSURL: server.URL + "/jwks", Audiences: []string{"another-app"}},
+		"wrong audience":   {JWKSURL: server.URL + "/jwks", Issuer: "https://issuer", Audiences: []string{"telos"}},
+	} {
+		if name != "wrong audience" && cfg.Validate() == nil {
+			t.Fatalf("%s: expected config to be rejected", name)
+		}
+		authenticator := NewAuthenticator(Config{JWT: &cfg})
+		if _, err := authenticator.AuthenticateJWT(context.Background(), token); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("%s: expected token to be rejected, got %v", name, err)
+		}
+	}
+}
+
+func TestJWKSRefreshIsCoalescedAndRateLimited(t *testing.T) {
+	signers := newTestSigners(t)
+	var fetches int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		// 拉取期间让并发请求都进入等待
+		time.Sleep(50 * time.Millisecond)
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{signers[0].jwk}})
+	}))
+	t.Cleanup(server.Close)
+
+	claims := map[string]any{"sub": "user-1", "iss": "https://issuer", "aud": "telos", "exp": time.Now().Add(time.Hour).Unix()}
+	burst := func(authenticator *Authenticator, token string) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _ = authenticator.AuthenticateJWT(context.Background(), token)
+			}()
+		}
+		wg.Wait()
+	}
+
+	// 伪造 kid 的并发请求只触发一次拉取，之后的最小间隔内不再拉取
+	authenticator := NewAuthenticator(Config{JWT: &JWTConfig{JWKSURL: server.URL, Issuer: "https://issuer", Audiences: []string{"telos"}}})
+	forged := testSigner{kid: "forged", alg: "EdDSA", sign: signers[2].sign}.token(claims)
+	burst(authenticator, forged)
+	burst(authenticator, forged)
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Fatalf("expected unknown kids to share one JWKS fetch, got %d", got)
+	}
+	// 已拉取的公钥仍然可用
+	if _, err := authenticator.AuthenticateJWT(context.Background(), signers[0].token(claims)); err != nil {
+		t.Fatalf("expected known kid to verify: %v", err)
+	}
+
+	// IdP 故障时失败的拉取同样受最小间隔限制
+	failing.Store(true)
+	atomic.StoreInt32(&fetches, 0)
+	authenticator = NewAuthenticator(Config{JWT: &JWTConfig{JWKSURL: server.URL, Issuer: "https://issuer", Audiences: []string{"telos"}}})
+	burst(authenticator, signers[0].token(claims))
+	if _, err := authenticator.AuthenticateJWT(context.Background(), signers[0].token(claims)); err == nil {
+		t.Fatal("expected verification to fail while the IdP is down")
+	}
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Fatalf("expected failed fetches to be rate limited, got %d", got)
+	}
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// flightGroup 合并相同 key 的并发调用，只有第一个调用者真正执行
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done     chan struct{}
+	identity *Identity
+	err      error
+}
+
+// do 执行 fn，同一 key 的并发调用共享同一个结果
+// fn panic 时恢复并以 ErrAuthUnavailable 返回给所有调用者，避免等待者永远阻塞
+func (g *flightGroup) do(key string, fn func() (*Identity, error)) (*Identity, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-call.done
+		return copyIdentity(call.identity), call.err
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	g.call(key, call, fn)
+	return copyIdentity(call.identity), call.err
+}
+
+func (g *flightGroup) call(key string, call *flightCall, fn func() (*Identity, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			tlog.Error("会话查询 panic", "panic", r)
+			call.identity, call.err = nil, fmt.Errorf("%w: session lookup panicked: %v", ErrAuthUnavailable, r)
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.identity, call.err = fn()
+}
+
+func copyIdentity(identity *Identity) *Identity {
+	if identity == nil {
+		return nil
+	}
+	copied := *identity
+	return &copied
+}
@@ -0,0 +1,314 @@
+// Package capture 按条件记录完整的请求/响应（请求头、截断的请求体与响应体、带时间的 SSE 事件），
+// 保存在有界的环形缓冲区中，通过管理接口导出为 HAR，用于排查单个用户的问题
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认的缓冲区条目数与单个请求体/响应体的记录上限
+const (
+	DefaultBufferSize   = 100
+	DefaultMaxBodyBytes = 32 << 10
+)
+
+// Config 记录器配置，数值为 0 时使用默认值
+type Config struct {
+	// BufferSize 缓冲区保留的请求数，写满后覆盖最早的记录
+	BufferSize int
+	// MaxBodyBytes 每个请求体、响应体（SSE 为全部事件）最多记录的字节数
+	MaxBodyBytes int
+	// RedactHeaders 额外需要脱敏的请求头/响应头，Cookie、Authorization、签名等默认脱敏
+	RedactHeaders []string
+}
+
+// Filter 记录条件，满足任意一项即记录；启用但没有任何条件时记录所有请求
+type Filter struct {
+	Enabled bool `json:"enabled"`
+	// UserIDs 认证后的用户 ID
+	UserIDs []string `json:"userIds,omitempty"`
+	// Routes 请求路径前缀，如 /api/agent
+	Routes []string `json:"routes,omitempty"`
+	// Header 请求带有该请求头（值非空）且通过认证时记录，如 X-Debug-Capture；
+	// 未认证的请求不能通过该请求头把自己加入记录，避免挤占缓冲区
+	Header string `json:"header,omitempty"`
+}
+
+func (f *Filter) matchesAll() bool {
+	return len(f.UserIDs) == 0 && len(f.Routes) == 0 && f.Header == ""
+}
+
+// matchesRoute 在请求开始时就能判断的条件
+func (f *Filter) matchesRoute(r *http.Request) bool {
+	for _, route := range f.Routes {
+		if strings.HasPrefix(r.URL.Path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchesUser(userID string) bool {
+	for _, id := range f.UserIDs {
+		if userID != "" && id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Recorder 请求记录器，并发安全
+type Recorder struct {
+	maxBodyBytes int
+	redact       map[string]bool
+	filter       atomic.Pointer[Filter]
+
+	mu      sync.Mutex
+	entries []*Entry
+	next    int
+	full    bool
+}
+
+// NewRecorder 创建记录器，初始条件为 filter
+func NewRecorder(cfg Config, filter Filter) *Recorder {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = DefaultBufferSize
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		cfg.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	rec := &Recorder{
+		maxBodyBytes: cfg.MaxBodyBytes,
+		redact:       make(map[string]bool, len(cfg.RedactHeaders)),
+		entries:      make([]*Entry, cfg.BufferSize),
+	}
+	for _, name := range cfg.RedactHeaders {
+		rec.redact[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+	rec.SetFilter(filter)
+	return rec
+}
+
+// SetFilter 更新记录条件，对之后开始的请求生效
+func (rec *Recorder) SetFilter(filter Filter) {
+	rec.filter.Store(&filter)
+}
+
+// Filter 返回当前的记录条件
+func (rec *Recorder) Filter() Filter {
+	return *rec.filter.Load()
+}
+
+// Entries 按时间顺序返回缓冲区中的记录
+func (rec *Recorder) Entries() []*Entry {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var entries []*Entry
+	if rec.full {
+		entries = append(entries, rec.entries[rec.next:]...)
+	}
+	return append(entries, rec.entries[:rec.next]...)
+}
+
+// Clear 清空缓冲区
+func (rec *Recorder) Clear() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	clear(rec.entries)
+	rec.next = 0
+	rec.full = false
+}
+
+func (rec *Recorder) store(entry *Entry) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.entries[rec.next] = entry
+	rec.next = (rec.next + 1) % len(rec.entries)
+	if rec.next == 0 {
+		rec.full = true
+	}
+}
+
+// exchangeKey 请求上下文中当前记录的键，代理认证成功后通过 SetUser 补充用户 ID
+type exchangeKey struct{}
+
+// SetUser 记录请求的用户 ID，用户在记录条件中或请求带有记录请求头时开始记录请求体和响应；
+// 请求没有被跟踪时不做任何事。代理在认证之后、读取请求体和写出响应之前调用
+func SetUser(ctx context.Context, userID string) {
+	if entry, ok := ctx.Value(exchangeKey{}).(*Entry); ok {
+		entry.mu.Lock()
+		entry.UserID = userID
+		entry.mu.Unlock()
+		if userID != "" && (entry.headerRequested || entry.filter.matchesUser(userID)) {
+			entry.active.Store(true)
+		}
+	}
+}
+
+// Middleware 记录满足条件的请求；管理接口（/_gateway）本身不记录
+// 需注册在压缩中间件之后，记录的是未压缩的响应体
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := rec.filter.Load()
+		if !filter.Enabled || strings.HasPrefix(r.URL.Path, "/_gateway") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		matched := filter.matchesAll() || filter.matchesRoute(r)
+		headerRequested := filter.Header != "" && r.Header.Get(filter.Header) != ""
+		if !matched && !headerRequested && len(filter.UserIDs) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 按用户或请求头过滤时要等认证完成才能判断：只快照请求头，代理认证后通过 SetUser 开始记录，
+		// 其他用户和未认证请求的请求体与响应不会被复制
+		entry := rec.newEntry(r)
+		entry.filter = filter
+		entry.headerRequested = headerRequested
+		entry.active.Store(matched)
+		r = r.WithContext(context.WithValue(r.Context(), exchangeKey{}, entry))
+		if r.Body != nil && r.Body != http.NoBody {
+			r.Body = &teeBody{ReadCloser: r.Body, entry: entry, limit: rec.maxBodyBytes}
+		}
+		cw := &captureWriter{ResponseWriter: w, entry: entry, limit: rec.maxBodyBytes, redact: rec.redactHeaders}
+		defer func() {
+			if entry.active.Load() {
+				entry.finish(cw)
+				rec.redactBodies(entry)
+				rec.store(entry)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// teeBody 在请求体被代理读取时复制前 limit 字节
+type teeBody struct {
+	io.ReadCloser
+	entry *Entry
+	limit int
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && b.entry.active.Load() {
+		b.entry.mu.Lock()
+		b.entry.Request.BodySize += int64(n)
+		if remaining := b.limit - len(b.entry.Request.Body); remaining > 0 {
+			b.entry.Request.Body = append(b.entry.Request.Body, p[:min(n, remaining)]...)
+		}
+		b.entry.mu.Unlock()
+	}
+	return n, err
+}
+
+// captureWriter 记录状态码、响应头、响应体；text/event-stream 响应按事件记录到达时间
+type captureWriter struct {
+	http.ResponseWriter
+	entry  *Entry
+	limit  int
+	redact func(http.Header) []NameValue
+
+	wroteHeader bool
+	sse         bool
+	pending     []byte
+	recorded    int
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.sse = strings.HasPrefix(cw.Header().Get("Content-Type"), "text/event-stream")
+		cw.recordHeader(code)
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) recordHeader(code int) {
+	if cw.entry.active.Load() {
+		cw.entry.mu.Lock()
+		cw.entry.Response.Status = code
+		cw.entry.Response.Headers = cw.redact(cw.Header())
+		cw.entry.Response.ContentType = cw.Header().Get("Content-Type")
+		cw.entry.firstByte = time.Since(cw.entry.Started)
+		cw.entry.mu.Unlock()
+	}
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.entry.active.Load() {
+		cw.record(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *captureWriter) record(p []byte) {
+	cw.entry.mu.Lock()
+	defer cw.entry.mu.Unlock()
+	cw.entry.Response.BodySize += int64(len(p))
+	if !cw.sse {
+		if remaining := cw.limit - len(cw.entry.Response.Body); remaining > 0 {
+			cw.entry.Response.Body = append(cw.entry.Response.Body, p[:min(len(p), remaining)]...)
+		}
+		return
+	}
+	// SSE 事件以空行分隔，记录每个事件完整到达的时间
+	cw.pending = append(cw.pending, p...)
+	for {
+		index := bytes.Index(cw.pending, []byte("\n\n"))
+		if index < 0 {
+			break
+		}
+		data := string(cw.pending[:index])
+		cw.pending = cw.pending[index+2:]
+		if cw.recorded+len(data) > cw.limit {
+			cw.entry.Response.Truncated = true
+			continue
+		}
+		cw.recorded += len(data)
+		cw.entry.Response.Events = append(cw.entry.Response.Events, Event{
+			Offset: time.Since(cw.entry.Started),
+			Data:   data,
+		})
+	}
+	// 单个事件不会无限增长：超过上限的部分直接丢弃
+	if len(cw.pending) > cw.limit {
+		cw.pending = cw.pending[:0]
+		cw.entry.Response.Truncated = true
+	}
+}
+
+// Flush 实现 http.Flusher 接口，支持 SSE 流式响应
+func (cw *captureWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 实现 http.Hijacker 接口，WebSocket 升级后的帧不记录
+func (cw *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		cw.entry.mu.Lock()
+		cw.entry.Response.Status = http.StatusSwitchingProtocols
+		cw.entry.mu.Unlock()
+		return hijacker.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
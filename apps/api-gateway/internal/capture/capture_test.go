@@ -0,0 +1,171 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecorderRedactsSecrets(t *testing.T) {
+	rec := NewRecorder(Config{RedactHeaders: []string{"X-Internal"}}, Filter{Enabled: true})
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		http.SetCookie(w, &http.Cookie{Name: "telos.session_token", Value: "new-secret", Path: "/", HttpOnly: true})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/agent/chat?sig=abc&page=2&access_token=t", strings.NewReader(`{"q":"hi","password":"hunter2","max_tokens":100,"nested":{"refresh_token":"rt-\"1"}}`))
+	req.Header.Set("Cookie", "telos.session_token=secret; theme=dark")
+	req.Header.Set("Authorization", "Bearer sk-123")
+	req.Header.Set("X-Gateway-Signature", "v2=deadbeef")
+	req.Header.Set("X-Internal", "hidden")
+	req.Header.Set("User-Agent", "test")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	encoded, err := json.Marshal(rec.HAR(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret", "sk-123", "deadbeef", "hidden", "abc", "new-secret", "hunter2", "rt-"} {
+		if strings.Contains(string(encoded), secret) {
+			t.Fatalf("HAR leaks %q: %s", secret, encoded)
+		}
+	}
+	for _, kept := range []string{"telos.session_token=[REDACTED]; theme=[REDACTED]", `"page"`, `{\"q\":\"hi\",\"password\":\"[REDACTED]\",\"max_tokens\":100`, `"test"`} {
+		if !strings.Contains(string(encoded), kept) {
+			t.Fatalf("HAR missing %q: %s", kept, encoded)
+		}
+	}
+}
+
+func TestRecorderRingBuffer(t *testing.T) {
+	rec := NewRecorder(Config{BufferSize: 2}, Filter{Enabled: true, Routes: []string{"/api/agent"}})
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, path := range []string{"/api/agent/1", "/api/user/skipped", "/_gateway/capture", "/api/agent/2", "/api/agent/3"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	entries := rec.Entries()
+	if len(entries) != 2 || !strings.HasSuffix(entries[0].Request.URL, "/api/agent/2") || !strings.HasSuffix(entries[1].Request.URL, "/api/agent/3") {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if entries[0].Response.Status != http.StatusOK {
+		t.Fatalf("expected implicit 200, got %d", entries[0].Response.Status)
+	}
+	rec.Clear()
+	if len(rec.Entries()) != 0 {
+		t.Fatal("expected buffer to be cleared")
+	}
+}
+
+func TestRecorderFiltersByUserAfterAuthentication(t *testing.T) {
+	rec := NewRecorder(Config{}, Filter{Enabled: true, UserIDs: []string{"u_1"}})
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r.Context(), r.Header.Get("X-Test-User"))
+	}))
+	for _, user := range []string{"u_1", "u_2", ""} {
+		req := httptest.NewRequest(http.MethodGet, "/api/agent", nil)
+		req.Header.Set("X-Test-User", user)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries := rec.Entries()
+	if len(entries) != 1 || entries[0].UserID != "u_1" {
+		t.Fatalf("expected only u_1 to be captured, got %+v", entries)
+	}
+	if har := rec.HAR("u_2"); len(har.Log.Entries) != 0 {
+		t.Fatalf("expected user filter on export, got %+v", har.Log.Entries)
+	}
+}
+
+func TestRecorderCopiesBodiesOnlyForMatchedUsers(t *testing.T) {
+	rec := NewRecorder(Config{}, Filter{Enabled: true, UserIDs: []string{"u_1"}})
+	var other *Entry
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r.Context(), r.Header.Get("X-Test-User"))
+		if r.Header.Get("X-Test-User") != "u_1" {
+			other = r.Context().Value(exchangeKey{}).(*Entry)
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	for _, user := range []string{"u_2", "u_1"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/agent", strings.NewReader("payload-"+user))
+		req.Header.Set("X-Test-User", user)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// 其他用户的请求只快照了请求头，请求体和响应没有被复制
+	if other == nil || other.Request.BodySize != 0 || len(other.Response.Body) != 0 || other.Response.Status != 0 {
+		t.Fatalf("expected unmatched request not to be copied, got %+v", other)
+	}
+	entries := rec.Entries()
+	if len(entries) != 1 || string(entries[0].Request.Body) != "payload-u_1" || string(entries[0].Response.Body) != "payload-u_1" {
+		t.Fatalf("expected u_1 bodies to be captured, got %+v", entries)
+	}
+}
+
+func TestRecorderHonoursCaptureHeaderOnlyAfterAuthentication(t *testing.T) {
+	rec := NewRecorder(Config{}, Filter{Enabled: true, Header: "X-Debug-Capture"})
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 模拟代理：只有认证通过的请求才会调用 SetUser
+		if user := r.Header.Get("X-Test-User"); user != "" {
+			SetUser(r.Context(), user)
+		}
+		w.Write([]byte("ok"))
+	}))
+	for _, user := range []string{"", "u_1"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/agent", nil)
+		req.Header.Set("X-Debug-Capture", "1")
+		req.Header.Set("X-Test-User", user)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	entries := rec.Entries()
+	if len(entries) != 1 || entries[0].UserID != "u_1" {
+		t.Fatalf("expected only the authenticated request to be captured, got %+v", entries)
+	}
+}
+
+func TestRecorderCapturesSSEEvents(t *testing.T) {
+	rec := NewRecorder(Config{MaxBodyBytes: 32}, Filter{Enabled: true, Header: "X-Debug-Capture"})
+	handler := rec.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r.Context(), "u_1")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Request-ID", "req-1")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("data: one\n\ndata: t"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("wo\n\n"))
+		w.Write([]byte("data: " + strings.Repeat("x", 40) + "\n\n"))
+		w.Write([]byte(`data: {"token":"tok-1"}`))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agent/stream", nil)
+	req.Header.Set("X-Debug-Capture", "1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/agent/stream", nil))
+
+	har := rec.HAR("")
+	if len(har.Log.Entries) != 1 {
+		t.Fatalf("expected only the flagged request, got %d", len(har.Log.Entries))
+	}
+	entry := har.Log.Entries[0]
+	var events []string
+	for _, event := range entry.Response.SSEEvents {
+		events = append(events, event.Data)
+	}
+	if strings.Join(events, "|") != `data: one|data: two|data: {"token":"[REDACTED]"}` {
+		t.Fatalf("unexpected events %q", events)
+	}
+	if entry.Response.Content.Comment != "truncated" || entry.RequestID != "req-1" || entry.Response.Status != http.StatusOK {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	if entry.Time < entry.Timings.Wait || entry.Timings.Receive < 0 {
+		t.Fatalf("unexpected timings %+v (time %v)", entry.Timings, entry.Time)
+	}
+}
@@ -0,0 +1,215 @@
+package capture
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// redacted 脱敏后的值
+const redacted = "[REDACTED]"
+
+// sensitiveNames 名称包含这些片段的请求头和查询参数默认脱敏
+var sensitiveNames = []string{"cookie", "authorization", "token", "secret", "signature", "password", "api-key", "apikey", "api_key"}
+
+// NameValue 请求头、查询参数
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Entry 一次请求的记录
+type Entry struct {
+	mu sync.Mutex
+
+	Started   time.Time
+	Duration  time.Duration
+	RequestID string
+	UserID    string
+	Request   Request
+	Response  Response
+
+	// firstByte 从请求开始到写出响应头的时间
+	firstByte time.Duration
+	// filter 请求开始时的记录条件，headerRequested 为请求是否带有记录请求头；
+	// active 为 false 时不复制请求体和响应，等待 SetUser 判断用户
+	filter          *Filter
+	headerRequested bool
+	active          atomic.Bool
+}
+
+// Request 请求记录，请求头在进入代理之前快照，不包含网关注入的身份头
+type Request struct {
+	Method      string
+	URL         string
+	Proto       string
+	Headers     []NameValue
+	Query       []NameValue
+	ContentType string
+	// Body 代理实际读取到的请求体，最多 MaxBodyBytes 字节；BodySize 为读取到的总字节数
+	Body     []byte
+	BodySize int64
+}
+
+// Response 响应记录，SSE 响应只记录 Events，不记录 Body
+type Response struct {
+	Status      int
+	Headers     []NameValue
+	ContentType string
+	Body        []byte
+	BodySize    int64
+	Truncated   bool
+	Events      []Event
+}
+
+// Event 一个 SSE 事件及其相对请求开始的到达时间
+type Event struct {
+	Offset time.Duration
+	Data   string
+}
+
+func (rec *Recorder) newEntry(r *http.Request) *Entry {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	} else if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	query := rec.redactQuery(r.URL.Query())
+	target := url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path}
+	params := make([]string, 0, len(query))
+	for _, param := range query {
+		value := param.Value
+		if value != redacted {
+			value = url.QueryEscape(value)
+		}
+		params = append(params, url.QueryEscape(param.Name)+"="+value)
+	}
+	target.RawQuery = strings.Join(params, "&")
+	return &Entry{
+		Started: time.Now(),
+		Request: Request{
+			Method:      r.Method,
+			URL:         target.String(),
+			Proto:       r.Proto,
+			Headers:     rec.redactHeaders(r.Header),
+			Query:       query,
+			ContentType: r.Header.Get("Content-Type"),
+		},
+	}
+}
+
+func (e *Entry) finish(cw *captureWriter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Duration = time.Since(e.Started)
+	if !cw.wroteHeader && e.Response.Status == 0 {
+		e.Response.Status = http.StatusOK
+		e.firstByte = e.Duration
+	}
+	if len(cw.pending) > 0 {
+		e.Response.Events = append(e.Response.Events, Event{Offset: e.Duration, Data: string(cw.pending)})
+	}
+	e.RequestID = cw.Header().Get("X-Request-ID")
+}
+
+func (rec *Recorder) sensitive(name string) bool {
+	if rec.redact[http.CanonicalHeaderKey(name)] {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, part := range sensitiveNames {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactHeaders 按名称排序复制请求头，Cookie 只保留名称
+func (rec *Recorder) redactHeaders(header http.Header) []NameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var result []NameValue
+	for _, name := range names {
+		for _, value := range header[name] {
+			switch {
+			case name == "Cookie":
+				value = redactCookies(value)
+			case name == "Set-Cookie":
+				value, _, _ = strings.Cut(value, ";")
+				value = redactCookies(value)
+			case rec.sensitive(name):
+				value = redacted
+			}
+			result = append(result, NameValue{Name: name, Value: value})
+		}
+	}
+	return result
+}
+
+func redactCookies(value string) string {
+	var cookies []string
+	for _, cookie := range strings.Split(value, ";") {
+		name, _, _ := strings.Cut(strings.TrimSpace(cookie), "=")
+		if name != "" {
+			cookies = append(cookies, name+"="+redacted)
+		}
+	}
+	return strings.Join(cookies, "; ")
+}
+
+// jsonStringField 匹配 JSON 中值为字符串的字段 "name": "value"，末尾被截断的字符串同样匹配
+var jsonStringField = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)"(?:[^"\\]|\\.)*(?:"|$)`)
+
+// redactBodies 脱敏请求体、响应体与 SSE 事件中名称敏感的 JSON 字符串字段，
+// 只处理字符串值，max_tokens 之类的数值字段保留；截断后无法解析的 JSON 同样处理
+func (rec *Recorder) redactBodies(e *Entry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.Request.Body = rec.redactJSON(e.Request.Body)
+	e.Response.Body = rec.redactJSON(e.Response.Body)
+	for i := range e.Response.Events {
+		e.Response.Events[i].Data = string(rec.redactJSON([]byte(e.Response.Events[i].Data)))
+	}
+}
+
+func (rec *Recorder) redactJSON(data []byte) []byte {
+	if len(data) == 0 || !utf8.Valid(data) {
+		return data
+	}
+	return jsonStringField.ReplaceAllFunc(data, func(field []byte) []byte {
+		match := jsonStringField.FindSubmatch(field)
+		if !rec.sensitive(string(match[1])) {
+			return field
+		}
+		return []byte(`"` + string(match[1]) + `"` + string(match[2]) + `"` + redacted + `"`)
+	})
+}
+
+func (rec *Recorder) redactQuery(query url.Values) []NameValue {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var result []NameValue
+	for _, name := range names {
+		for _, value := range query[name] {
+			if rec.sensitive(name) || strings.EqualFold(name, "key") || strings.EqualFold(name, "sig") {
+				value = redacted
+			}
+			result = append(result, NameValue{Name: name, Value: value})
+		}
+	}
+	return result
+}
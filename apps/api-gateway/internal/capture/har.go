@@ -0,0 +1,205 @@
+package capture
+
+import (
+	"encoding/base64"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR HTTP Archive 1.2，可直接导入浏览器开发者工具或 Charles 等工具
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog HAR 的 log 对象
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+	Comment string     `json:"comment,omitempty"`
+}
+
+// HARCreator 生成 HAR 的程序
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry 一次请求，_ 开头的字段为 HAR 允许的自定义字段
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	UserID          string      `json:"_userId,omitempty"`
+	RequestID       string      `json:"_requestId,omitempty"`
+}
+
+// HARRequest HAR 的 request 对象
+type HARRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []NameValue  `json:"cookies"`
+	Headers     []NameValue  `json:"headers"`
+	QueryString []NameValue  `json:"queryString"`
+	PostData    *HARPostData `json:"postData,omitempty"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int64        `json:"bodySize"`
+}
+
+// HARPostData HAR 的 postData 对象
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARResponse HAR 的 response 对象
+type HARResponse struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     HARContent  `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+	SSEEvents   []HAREvent  `json:"_sseEvents,omitempty"`
+}
+
+// HARContent HAR 的 content 对象
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HAREvent 一个 SSE 事件，Time 为相对请求开始的毫秒数
+type HAREvent struct {
+	Time float64 `json:"time"`
+	Data string  `json:"data"`
+}
+
+// HARTimings HAR 的 timings 对象，wait 为等待响应头的时间，receive 为接收响应体的时间
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HAR 导出缓冲区中的记录，userID 非空时只导出该用户的请求
+func (rec *Recorder) HAR(userID string) HAR {
+	har := HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "telos-api-gateway", Version: "1.0"},
+		Entries: []HAREntry{},
+	}}
+	for _, entry := range rec.Entries() {
+		if harEntry, ok := entry.har(userID); ok {
+			har.Log.Entries = append(har.Log.Entries, harEntry)
+		}
+	}
+	return har
+}
+
+// Merge 合并其他副本导出的记录，按开始时间排序
+func (h *HAR) Merge(entries []HAREntry) {
+	h.Log.Entries = append(h.Log.Entries, entries...)
+	sort.SliceStable(h.Log.Entries, func(i, j int) bool {
+		a, _ := time.Parse(time.RFC3339Nano, h.Log.Entries[i].StartedDateTime)
+		b, _ := time.Parse(time.RFC3339Nano, h.Log.Entries[j].StartedDateTime)
+		return a.Before(b)
+	})
+}
+
+func (e *Entry) har(userID string) (HAREntry, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if userID != "" && e.UserID != userID {
+		return HAREntry{}, false
+	}
+
+	request := HARRequest{
+		Method:      e.Request.Method,
+		URL:         e.Request.URL,
+		HTTPVersion: e.Request.Proto,
+		Cookies:     []NameValue{},
+		Headers:     nonNil(e.Request.Headers),
+		QueryString: nonNil(e.Request.Query),
+		HeadersSize: -1,
+		BodySize:    e.Request.BodySize,
+	}
+	if e.Request.BodySize > 0 {
+		text, encoding := bodyText(e.Request.Body)
+		request.PostData = &HARPostData{MimeType: e.Request.ContentType, Text: text, Encoding: encoding}
+		if int64(len(e.Request.Body)) < e.Request.BodySize {
+			request.PostData.Comment = "truncated"
+		}
+	}
+
+	response := HARResponse{
+		Status:      e.Response.Status,
+		StatusText:  http.StatusText(e.Response.Status),
+		HTTPVersion: e.Request.Proto,
+		Cookies:     []NameValue{},
+		Headers:     nonNil(e.Response.Headers),
+		Content:     HARContent{Size: e.Response.BodySize, MimeType: e.Response.ContentType},
+		HeadersSize: -1,
+		BodySize:    e.Response.BodySize,
+	}
+	response.Content.Text, response.Content.Encoding = bodyText(e.Response.Body)
+	if e.Response.Truncated || int64(len(e.Response.Body)) < e.Response.BodySize && len(e.Response.Events) == 0 {
+		response.Content.Comment = "truncated"
+	}
+	// SSE 事件同时拼接为 content.text，便于在不认识 _sseEvents 的工具中查看
+	var events []string
+	for _, event := range e.Response.Events {
+		response.SSEEvents = append(response.SSEEvents, HAREvent{Time: milliseconds(event.Offset), Data: event.Data})
+		events = append(events, event.Data)
+	}
+	if len(events) > 0 {
+		response.Content.Text = strings.Join(events, "\n\n")
+	}
+
+	return HAREntry{
+		StartedDateTime: e.Started.Format(time.RFC3339Nano),
+		Time:            milliseconds(e.Duration),
+		Request:         request,
+		Response:        response,
+		Timings: HARTimings{
+			Wait:    milliseconds(e.firstByte),
+			Receive: milliseconds(e.Duration - e.firstByte),
+		},
+		UserID:    e.UserID,
+		RequestID: e.RequestID,
+	}, true
+}
+
+// bodyText 文本原样输出，二进制内容按 HAR 约定使用 base64
+func bodyText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func nonNil(values []NameValue) []NameValue {
+	if values == nil {
+		return []NameValue{}
+	}
+	return values
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -17,8 +17,136 @@ type Config struct {
 	BetterAuthBaseURL     string
 	BetterAuthSessionPath string
 	GatewayInternalSecret string
+	SigningKeyID          string
+	SigningKeysFile       string
+	SignatureVersion      string
+	DigestMaxBytes        int64
+	DigestSpillBytes      int64
 	AuthCacheTTLSeconds   int
 	AuthClockSkewSeconds  int
+	APIKeysFile           string
+
+	// 服务发现：轮询间隔（秒，默认 10）；长轮询等待时间（秒，默认 30，-1 关闭长轮询只使用轮询）
+	RegistryRefreshSeconds int
+	RegistryWatchSeconds   int
+
+	// 会话声明：AUTH_CLAIM_PATHS 覆盖声明的 JSON 路径，AUTH_PROPAGATE_CLAIMS 为转发给后端的声明
+	AuthClaimPaths      map[string]string
+	AuthPropagateClaims []string
+
+	// MCP 服务器管理：MCPAdminRoles 为写操作所需的角色，默认 admin；
+	// 角色来自会话的 user.role，由 Better Auth 的 admin 插件维护（或通过 AUTH_CLAIM_PATHS 指向其他字段）
+	MCPAdminRoles []string
+
+	// OIDC/JWT Bearer 认证
+	JWTJWKSURL   string
+	JWTIssuer    string
+	JWTAudiences []string
+
+	// Better Auth 查询保护：无效 Cookie 缓存秒数（-1 关闭）、熔断阈值与冷却秒数
+	AuthNegativeCacheSeconds   int
+	AuthBreakerThreshold       int
+	AuthBreakerCooldownSeconds int
+
+	// 认证缓存最大条目数
+	AuthCacheSize int
+	// 用于认证的会话 Cookie 名（逗号分隔），为空时使用 Better Auth 默认的 telos.* Cookie
+	AuthSessionCookies []string
+
+	// 管理接口：令牌为空时不启用；AdminAddr 为管理接口独立监听的内网地址，为空时与业务接口共用端口；
+	// Peers 为其他网关副本管理接口的地址，吊销等操作会广播
+	AdminToken string
+	AdminAddr  string
+	Peers      []string
+
+	// 维护模式：启动时整个网关或指定路由（RouteConfig.Path）进入维护状态，运行时可通过管理接口切换；
+	// MaintenanceFile 保存运行时状态，存在时优先于环境变量并在修改后自动重新加载；
+	// 白名单中的用户 ID 和 IP/CIDR（需要 TRUSTED_PROXIES）在维护期间仍可访问
+	MaintenanceFile        string
+	MaintenanceEnabled     bool
+	MaintenanceRoutes      []string
+	MaintenanceMessage     string
+	MaintenanceRetryAfter  int
+	MaintenanceBypassUsers []string
+	MaintenanceBypassIPs   []string
+
+	// 流量捕获：满足条件（用户 ID、路径前缀、请求头）的请求记录到环形缓冲区，通过管理接口导出为 HAR；
+	// 条件可在运行时通过管理接口修改，Cookie、Authorization、签名等请求头默认脱敏
+	CaptureEnabled       bool
+	CaptureUsers         []string
+	CaptureRoutes        []string
+	CaptureHeader        string
+	CaptureBufferSize    int
+	CaptureMaxBodyBytes  int
+	CaptureRedactHeaders []string
+
+	// 跨域策略：为空时使用网关默认的方法、请求头和预检缓存时间
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+	CORSExposedHeaders []string
+	CORSMaxAge         int
+
+	// 安全响应头与请求加固：字符串为空或数值为 0 时使用网关默认值
+	SecurityHSTSMaxAge            int
+	SecurityHSTSIncludeSubdomains bool
+	SecurityReferrerPolicy        string
+	SecurityFrameOptions          string
+	SecurityContentSecurityPolicy string
+	MaxURLLength                  int
+	MaxHeaderBytes                int
+
+	// IP 访问控制：可信代理决定如何解析客户端 IP；全局允许/拒绝列表（CIDR、列表文件、国家、ASN）；
+	// AdminIPAllow 限制管理类路由（如 /api/mcp-servers）只能从办公网/VPN 访问
+	TrustedProxies    []string
+	IPAllow           []string
+	IPDeny            []string
+	IPAllowFiles      []string
+	IPDenyFiles       []string
+	IPAllowCountries  []string
+	IPDenyCountries   []string
+	IPAllowASNs       []string
+	IPDenyASNs        []string
+	GeoIPDatabases    []string
+	AdminIPAllow      []string
+	AdminIPAllowFiles []string
+
+	// HTTPS：证书文件（续期后自动重新加载）和/或 ACME 自动签发；都为空时以明文 HTTP 监听
+	TLSCertFile         string
+	TLSKeyFile          string
+	TLSACMEDomains      []string
+	TLSACMEDirectoryURL string
+	TLSACMEEmail        string
+	TLSACMECacheDir     string
+	// 上游 TLS：按服务名配置 CA、mTLS 客户端证书和 SNI 的 JSON 文件，用于注册元数据 scheme=https 的实例
+	UpstreamTLSFile string
+	// 上游协议：UPSTREAM_PROTOCOLS 为 服务名=协议（http1、h2、h2c），长连接流可通过 HTTP/2 复用连接；连接池限制对所有服务生效
+	UpstreamProtocols           map[string]string
+	UpstreamMaxConnsPerHost     int
+	UpstreamMaxIdleConnsPerHost int
+	// 上游错误拦截：默认把后端返回的 5xx（HTML 错误页、空响应等）转换为统一错误格式，UPSTREAM_ERROR_PASSTHROUGH 关闭；
+	// UPSTREAM_ERROR_PAGES 为 状态码=文件路径 的自定义错误页，UPSTREAM_ERROR_LOG_BYTES 为日志中记录的后端响应体长度
+	UpstreamErrorPassthrough bool
+	UpstreamErrorPages       map[string]string
+	UpstreamErrorLogBytes    int
+
+	// gRPC：GRPC_ROUTES 为 gRPC 服务全名=注册中心服务名，如 telos.agent.v1.AgentService=agent-service；
+	// 明文部署时需开启 SERVER_H2C 才能接收原生 gRPC（gRPC-Web 可走 HTTP/1.1）
+	GRPCRoutes map[string]string
+	ServerH2C  bool
+
+	// CSRF 防护：允许发起写请求的来源（默认取 CORS_ORIGINS 中的具体来源）、是否启用双重提交令牌及令牌 Cookie 的 Domain
+	CSRFTrustedOrigins []string
+	CSRFDoubleSubmit   bool
+	CSRFCookieDomain   string
+
+	// 每用户并发请求限制：上限未设置时为 20，小于等于 0 表示不限制；
+	// 排队时间未设置时为 1000ms，0 或负数表示不排队、槽位已满时立即拒绝
+	UserConcurrencyLimit   int
+	UserConcurrencyQueueMs int
+
+	// 响应压缩：最小压缩字节数未设置时为 1024，0 表示压缩所有响应
+	CompressionEnabled bool
+	CompressionMinSize int
 
 	// 日志配置
 	LogFormat string
@@ -43,8 +171,69 @@ func LoadConfig() *Config {
 		BetterAuthBaseURL:     viper.GetString("BETTER_AUTH_BASE_URL"),
 		BetterAuthSessionPath: viper.GetString("BETTER_AUTH_SESSION_PATH"),
 		GatewayInternalSecret: viper.GetString("GATEWAY_INTERNAL_SECRET"),
+		SigningKeyID:          viper.GetString("GATEWAY_SIGNING_KEY_ID"),
+		SigningKeysFile:       viper.GetString("GATEWAY_SIGNING_KEYS_FILE"),
+		SignatureVersion:      viper.GetString("GATEWAY_SIGNATURE_VERSION"),
+		DigestMaxBytes:        viper.GetInt64("GATEWAY_DIGEST_MAX_BYTES"),
+		DigestSpillBytes:      viper.GetInt64("GATEWAY_DIGEST_SPILL_BYTES"),
 		AuthCacheTTLSeconds:   viper.GetInt("AUTH_CACHE_TTL_SECONDS"),
 		AuthClockSkewSeconds:  viper.GetInt("AUTH_CLOCK_SKEW_SECONDS"),
+		APIKeysFile:           viper.GetString("API_KEYS_FILE"),
+		JWTJWKSURL:            viper.GetString("JWT_JWKS_URL"),
+		JWTIssuer:             viper.GetString("JWT_ISSUER"),
+
+		RegistryRefreshSeconds: viper.GetInt("REGISTRY_REFRESH_INTERVAL"),
+		RegistryWatchSeconds:   viper.GetInt("REGISTRY_WATCH_WAIT"),
+
+		AuthNegativeCacheSeconds:   viper.GetInt("AUTH_NEGATIVE_CACHE_SECONDS"),
+		AuthBreakerThreshold:       viper.GetInt("AUTH_BREAKER_THRESHOLD"),
+		AuthBreakerCooldownSeconds: viper.GetInt("AUTH_BREAKER_COOLDOWN_SECONDS"),
+
+		AuthCacheSize: viper.GetInt("AUTH_CACHE_SIZE"),
+		AdminToken:    viper.GetString("GATEWAY_ADMIN_TOKEN"),
+		AdminAddr:     viper.GetString("GATEWAY_ADMIN_ADDR"),
+
+		MaintenanceFile:       viper.GetString("MAINTENANCE_FILE"),
+		MaintenanceEnabled:    viper.GetBool("MAINTENANCE_ENABLED"),
+		MaintenanceMessage:    viper.GetString("MAINTENANCE_MESSAGE"),
+		MaintenanceRetryAfter: viper.GetInt("MAINTENANCE_RETRY_AFTER"),
+
+		CaptureEnabled:      viper.GetBool("CAPTURE_ENABLED"),
+		CaptureHeader:       viper.GetString("CAPTURE_HEADER"),
+		CaptureBufferSize:   viper.GetInt("CAPTURE_BUFFER_SIZE"),
+		CaptureMaxBodyBytes: viper.GetInt("CAPTURE_MAX_BODY_BYTES"),
+
+		CORSMaxAge: viper.GetInt("CORS_MAX_AGE"),
+
+		SecurityHSTSMaxAge:            viper.GetInt("SECURITY_HSTS_MAX_AGE"),
+		SecurityHSTSIncludeSubdomains: viper.GetBool("SECURITY_HSTS_INCLUDE_SUBDOMAINS"),
+		SecurityReferrerPolicy:        viper.GetString("SECURITY_REFERRER_POLICY"),
+		SecurityFrameOptions:          viper.GetString("SECURITY_FRAME_OPTIONS"),
+		SecurityContentSecurityPolicy: viper.GetString("SECURITY_CSP"),
+		MaxURLLength:                  viper.GetInt("MAX_URL_LENGTH"),
+		MaxHeaderBytes:                viper.GetInt("MAX_HEADER_BYTES"),
+
+		TLSCertFile:         viper.GetString("TLS_CERT_FILE"),
+		TLSKeyFile:          viper.GetString("TLS_KEY_FILE"),
+		TLSACMEDirectoryURL: viper.GetString("TLS_ACME_DIRECTORY_URL"),
+		TLSACMEEmail:        viper.GetString("TLS_ACME_EMAIL"),
+		TLSACMECacheDir:     viper.GetString("TLS_ACME_CACHE_DIR"),
+		UpstreamTLSFile:     viper.GetString("UPSTREAM_TLS_FILE"),
+
+		UpstreamMaxConnsPerHost:     viper.GetInt("UPSTREAM_MAX_CONNS_PER_HOST"),
+		UpstreamMaxIdleConnsPerHost: viper.GetInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST"),
+		UpstreamErrorPassthrough:    viper.GetBool("UPSTREAM_ERROR_PASSTHROUGH"),
+		UpstreamErrorLogBytes:       viper.GetInt("UPSTREAM_ERROR_LOG_BYTES"),
+		ServerH2C:                   viper.GetBool("SERVER_H2C"),
+
+		CSRFDoubleSubmit: viper.GetBool("CSRF_DOUBLE_SUBMIT"),
+		CSRFCookieDomain: viper.GetString("CSRF_COOKIE_DOMAIN"),
+
+		UserConcurrencyLimit:   viper.GetInt("USER_CONCURRENCY_LIMIT"),
+		UserConcurrencyQueueMs: viper.GetInt("USER_CONCURRENCY_QUEUE_MS"),
+
+		CompressionEnabled: viper.GetBool("COMPRESSION_ENABLED"),
+		CompressionMinSize: viper.GetInt("COMPRESSION_MIN_SIZE"),
 	}
 
 	if cfg.Port == "" {
@@ -77,6 +266,12 @@ func LoadConfig() *Config {
 	if cfg.GatewayInternalSecret == "" {
 		cfg.GatewayInternalSecret = "dev-gateway-internal-secret-change-me"
 	}
+	if cfg.SigningKeyID == "" {
+		cfg.SigningKeyID = "default"
+	}
+	if cfg.SignatureVersion == "" {
+		cfg.SignatureVersion = "v2"
+	}
 	if cfg.AuthCacheTTLSeconds == 0 {
 		cfg.AuthCacheTTLSeconds = 60
 	}
@@ -84,6 +279,41 @@ func LoadConfig() *Config {
 		cfg.AuthClockSkewSeconds = 300
 	}
 
+	// 未设置时使用默认值；上限小于等于 0 表示不限制，排队时间为 0 或负数表示不排队、立即拒绝
+	if unset("USER_CONCURRENCY_LIMIT") {
+		cfg.UserConcurrencyLimit = 20
+	}
+	if unset("USER_CONCURRENCY_QUEUE_MS") {
+		cfg.UserConcurrencyQueueMs = 1000
+	}
+	// 未设置时使用默认值，0 表示压缩所有响应
+	if unset("COMPRESSION_MIN_SIZE") {
+		cfg.CompressionMinSize = -1
+	}
+
+	// 格式：email=user.email,roles=user.role
+	cfg.AuthClaimPaths = parseKeyValueList(viper.GetString("AUTH_CLAIM_PATHS"))
+	cfg.UpstreamProtocols = parseKeyValueList(viper.GetString("UPSTREAM_PROTOCOLS"))
+	cfg.UpstreamErrorPages = parseKeyValueList(viper.GetString("UPSTREAM_ERROR_PAGES"))
+	cfg.GRPCRoutes = parseKeyValueList(viper.GetString("GRPC_ROUTES"))
+	// 未设置时使用默认声明，"none" 表示不转发任何声明
+	if claims := strings.TrimSpace(viper.GetString("AUTH_PROPAGATE_CLAIMS")); claims != "" {
+		cfg.AuthPropagateClaims = []string{}
+		if claims != "none" {
+			cfg.AuthPropagateClaims = splitList(claims)
+		}
+	}
+
+	cfg.JWTAudiences = splitList(viper.GetString("JWT_AUDIENCE"))
+	cfg.Peers = splitList(viper.GetString("GATEWAY_PEERS"))
+	cfg.MaintenanceRoutes = splitList(viper.GetString("MAINTENANCE_ROUTES"))
+	cfg.MaintenanceBypassUsers = splitList(viper.GetString("MAINTENANCE_BYPASS_USERS"))
+	cfg.MaintenanceBypassIPs = splitList(viper.GetString("MAINTENANCE_BYPASS_IPS"))
+	cfg.CaptureUsers = splitList(viper.GetString("CAPTURE_USERS"))
+	cfg.CaptureRoutes = splitList(viper.GetString("CAPTURE_ROUTES"))
+	cfg.CaptureRedactHeaders = splitList(viper.GetString("CAPTURE_REDACT_HEADERS"))
+	cfg.AuthSessionCookies = splitList(viper.GetString("AUTH_SESSION_COOKIES"))
+
 	corsOrigins := viper.GetString("CORS_ORIGINS")
 	if corsOrigins == "" {
 		corsOrigins = "*"
@@ -93,5 +323,68 @@ func LoadConfig() *Config {
 		cfg.CORSOrigins[i] = strings.TrimSpace(origin)
 	}
 
+	cfg.CORSAllowedMethods = splitList(viper.GetString("CORS_ALLOWED_METHODS"))
+	cfg.CORSAllowedHeaders = splitList(viper.GetString("CORS_ALLOWED_HEADERS"))
+	cfg.CORSExposedHeaders = splitList(viper.GetString("CORS_EXPOSED_HEADERS"))
+
+	cfg.TrustedProxies = splitList(viper.GetString("TRUSTED_PROXIES"))
+	cfg.IPAllow = splitList(viper.GetString("IP_ALLOW"))
+	cfg.IPDeny = splitList(viper.GetString("IP_DENY"))
+	cfg.IPAllowFiles = splitList(viper.GetString("IP_ALLOW_FILE"))
+	cfg.IPDenyFiles = splitList(viper.GetString("IP_DENY_FILE"))
+	cfg.IPAllowCountries = splitList(viper.GetString("IP_ALLOW_COUNTRIES"))
+	cfg.IPDenyCountries = splitList(viper.GetString("IP_DENY_COUNTRIES"))
+	cfg.IPAllowASNs = splitList(viper.GetString("IP_ALLOW_ASNS"))
+	cfg.IPDenyASNs = splitList(viper.GetString("IP_DENY_ASNS"))
+	cfg.GeoIPDatabases = splitList(viper.GetString("GEOIP_DATABASES"))
+	cfg.AdminIPAllow = splitList(viper.GetString("ADMIN_IP_ALLOW"))
+	cfg.AdminIPAllowFiles = splitList(viper.GetString("ADMIN_IP_ALLOW_FILE"))
+	cfg.MCPAdminRoles = splitList(viper.GetString("MCP_ADMIN_ROLES"))
+	if len(cfg.MCPAdminRoles) == 0 {
+		cfg.MCPAdminRoles = []string{"admin"}
+	}
+	cfg.TLSACMEDomains = splitList(viper.GetString("TLS_ACME_DOMAINS"))
+	if len(cfg.TLSACMEDomains) > 0 && cfg.TLSACMECacheDir == "" {
+		cfg.TLSACMECacheDir = "data/acme"
+	}
+
+	// CSRF 来源默认沿用 CORS 白名单，但 "*" 不能作为可信来源
+	cfg.CSRFTrustedOrigins = splitList(viper.GetString("CSRF_TRUSTED_ORIGINS"))
+	if len(cfg.CSRFTrustedOrigins) == 0 {
+		for _, origin := range cfg.CORSOrigins {
+			if origin != "" && origin != "*" {
+				cfg.CSRFTrustedOrigins = append(cfg.CSRFTrustedOrigins, origin)
+			}
+		}
+	}
+
 	return cfg
 }
+
+// unset 环境变量未设置或为空，用于区分"未设置"与显式设置的 0
+func unset(key string) bool {
+	return strings.TrimSpace(viper.GetString(key)) == ""
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseKeyValueList(value string) map[string]string {
+	items := splitList(value)
+	if len(items) == 0 {
+		return nil
+	}
+	pairs := make(map[string]string, len(items))
+	for _, item := range items {
+		key, val, _ := strings.Cut(item, "=")
+		pairs[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return pairs
+}
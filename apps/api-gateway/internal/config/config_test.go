@@ -0,0 +1,24 @@
+package config
+
+import "testing"
+
+func TestLoadConfigDistinguishesUnsetFromZero(t *testing.T) {
+	cases := []struct {
+		limit, queue           string
+		wantLimit, wantQueueMs int
+	}{
+		{"", "", 20, 1000},
+		{"0", "0", 0, 0},
+		{"-1", "-1", -1, -1},
+		{"5", "250", 5, 250},
+	}
+	for _, tc := range cases {
+		t.Setenv("USER_CONCURRENCY_LIMIT", tc.limit)
+		t.Setenv("USER_CONCURRENCY_QUEUE_MS", tc.queue)
+		cfg := LoadConfig()
+		if cfg.UserConcurrencyLimit != tc.wantLimit || cfg.UserConcurrencyQueueMs != tc.wantQueueMs {
+			t.Fatalf("limit=%q queue=%q: expected %d/%d, got %d/%d",
+				tc.limit, tc.queue, tc.wantLimit, tc.wantQueueMs, cfg.UserConcurrencyLimit, cfg.UserConcurrencyQueueMs)
+		}
+	}
+}
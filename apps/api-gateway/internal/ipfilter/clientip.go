@@ -0,0 +1,131 @@
+// Package ipfilter 基于客户端 IP 的访问控制：CIDR 允许/拒绝列表、可热更新的列表文件
+// 以及基于离线 MaxMind 数据库的国家/ASN 规则
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver 根据可信代理解析真实客户端 IP
+// 只有直连地址属于可信代理时才读取 X-Forwarded-For / X-Real-IP，避免客户端伪造来源
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver 创建客户端 IP 解析器，trustedProxies 为可信代理的 IP 或 CIDR
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	prefixes, err := ParsePrefixes(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("可信代理配置无效: %w", err)
+	}
+	return &Resolver{trusted: prefixes}, nil
+}
+
+// ClientIP 返回请求的客户端 IP
+// X-Forwarded-For 从右向左跳过可信代理，第一个不可信的地址即为客户端；全部可信时取最左侧的地址
+func (res *Resolver) ClientIP(r *http.Request) (netip.Addr, bool) {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if !res.isTrusted(peer) {
+		return peer, true
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	if len(forwarded) == 0 {
+		if realIP, ok := parseAddr(r.Header.Get("X-Real-IP")); ok {
+			return realIP, true
+		}
+		return peer, true
+	}
+
+	var hops []string
+	for _, value := range forwarded {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseAddr(hops[i])
+		if !ok {
+			// 无法解析的条目之前的内容都不可信
+			break
+		}
+		client = addr
+		if !res.isTrusted(addr) {
+			break
+		}
+	}
+	return client, true
+}
+
+// HasTrustedProxies 是否配置了可信代理；未配置时前置代理后的客户端 IP 都是代理自身的地址
+func (res *Resolver) HasTrustedProxies() bool {
+	return res != nil && len(res.trusted) > 0
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	return containsAddr(res.trusted, addr)
+}
+
+// ParsePrefixes 解析 IP 或 CIDR 列表，单个 IP 视为 /32 或 /128
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("无效的 CIDR %q", value)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("无效的 IP %q", value)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseAddr 解析 "ip"、"ip:port" 或 "[ipv6]:port"，IPv4 映射的 IPv6 地址统一转为 IPv4
+func parseAddr(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return netip.Addr{}, false
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Rules IP 访问规则，可用于全局和单个路由
+// 先检查拒绝规则，命中即拒绝；配置了任一允许规则时，客户端必须命中其中之一
+type Rules struct {
+	// Allow、Deny 为 IP 或 CIDR
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	// AllowFiles、DenyFiles 为列表文件，每行一个 IP 或 CIDR，修改后自动重新加载
+	AllowFiles []string `json:"allowFiles"`
+	DenyFiles  []string `json:"denyFiles"`
+	// 国家代码（ISO 3166-1，如 CN、US）与 ASN（如 64500 或 AS64500），需要 GeoIP 数据库
+	AllowCountries []string `json:"allowCountries"`
+	DenyCountries  []string `json:"denyCountries"`
+	AllowASNs      []string `json:"allowAsns"`
+	DenyASNs       []string `json:"denyAsns"`
+}
+
+// Filter 编译后的访问规则
+type Filter struct {
+	allow          *cidrList
+	deny           *cidrList
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+	allowASNs      map[uint32]bool
+	denyASNs       map[uint32]bool
+}
+
+// Compile 校验规则并加载列表文件
+func Compile(rules Rules) (*Filter, error) {
+	allow, err := newCIDRList(rules.Allow, rules.AllowFiles)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := newCIDRList(rules.Deny, rules.DenyFiles)
+	if err != nil {
+		return nil, err
+	}
+	allowASNs, err := parseASNs(rules.AllowASNs)
+	if err != nil {
+		return nil, err
+	}
+	denyASNs, err := parseASNs(rules.DenyASNs)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{
+		allow:          allow,
+		deny:           deny,
+		allowCountries: parseCountries(rules.AllowCountries),
+		denyCountries:  parseCountries(rules.DenyCountries),
+		allowASNs:      allowASNs,
+		denyASNs:       denyASNs,
+	}, nil
+}
+
+// Empty 规则为空时不需要执行检查
+func (f *Filter) Empty() bool {
+	return f.allow.empty() && f.deny.empty() && !f.NeedsGeo()
+}
+
+// NeedsGeo 是否包含国家或 ASN 规则
+func (f *Filter) NeedsGeo() bool {
+	return len(f.allowCountries) > 0 || len(f.denyCountries) > 0 || len(f.allowASNs) > 0 || len(f.denyASNs) > 0
+}
+
+// Check 返回拒绝原因，空字符串表示允许
+// geo 为 nil 或查不到归属时，国家/ASN 允许规则视为未命中，拒绝规则视为未命中
+func (f *Filter) Check(addr netip.Addr, geo GeoLookup) string {
+	var info GeoInfo
+	if geo != nil && f.NeedsGeo() {
+		info = geo.LookupGeo(addr)
+	}
+
+	if f.deny.contains(addr) {
+		return "IP 在拒绝列表中"
+	}
+	if info.Country != "" && f.denyCountries[info.Country] {
+		return "国家 " + info.Country + " 被拒绝"
+	}
+	if info.ASN != 0 && f.denyASNs[info.ASN] {
+		return "ASN " + strconv.FormatUint(uint64(info.ASN), 10) + " 被拒绝"
+	}
+
+	hasAllow := !f.allow.empty() || len(f.allowCountries) > 0 || len(f.allowASNs) > 0
+	if !hasAllow {
+		return ""
+	}
+	if f.allow.contains(addr) ||
+		(info.Country != "" && f.allowCountries[info.Country]) ||
+		(info.ASN != 0 && f.allowASNs[info.ASN]) {
+		return ""
+	}
+	return "IP 不在允许列表中"
+}
+
+func parseCountries(values []string) map[string]bool {
+	countries := make(map[string]bool, len(values))
+	for _, value := range values {
+		if value = strings.ToUpper(strings.TrimSpace(value)); value != "" {
+			countries[value] = true
+		}
+	}
+	return countries
+}
+
+func parseASNs(values []string) (map[uint32]bool, error) {
+	asns := make(map[uint32]bool, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		trimmed := strings.TrimPrefix(strings.ToUpper(value), "AS")
+		asn, err := strconv.ParseUint(trimmed, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 ASN %q", value)
+		}
+		asns[uint32(asn)] = true
+	}
+	return asns, nil
+}
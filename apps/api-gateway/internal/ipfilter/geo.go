@@ -0,0 +1,159 @@
+package ipfilter
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// geoFileCheckInterval 数据库文件的检查间隔，GeoLite2 等数据库通常按周更新
+const geoFileCheckInterval = time.Minute
+
+// GeoInfo 客户端 IP 的地理与网络归属信息，未知字段为零值
+type GeoInfo struct {
+	Country string // ISO 3166-1 二位国家代码，大写
+	ASN     uint32
+}
+
+// GeoLookup 按 IP 查询地理与 ASN 信息
+type GeoLookup interface {
+	LookupGeo(addr netip.Addr) GeoInfo
+}
+
+// geoRecord 同时兼容 Country/City 与 ASN 数据库的字段
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+	AutonomousSystemNumber uint32 `maxminddb:"autonomous_system_number"`
+}
+
+// GeoDB 由一个或多个 MaxMind 格式（.mmdb）数据库组成，如 GeoLite2-Country 与 GeoLite2-ASN
+// 数据库整体读入内存，文件替换后自动重新加载，旧数据由 GC 回收，查询无需加锁等待
+type GeoDB struct {
+	files []*geoFile
+}
+
+type geoFile struct {
+	path string
+
+	mu          sync.RWMutex
+	reader      *maxminddb.Reader
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+// OpenGeoDB 打开 MaxMind 格式数据库
+func OpenGeoDB(paths ...string) (*GeoDB, error) {
+	db := &GeoDB{}
+	for _, path := range paths {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		file := &geoFile{path: path}
+		if err := file.reload(); err != nil {
+			return nil, err
+		}
+		db.files = append(db.files, file)
+	}
+	if len(db.files) == 0 {
+		return nil, fmt.Errorf("未指定 GeoIP 数据库文件")
+	}
+	return db, nil
+}
+
+// LookupGeo 依次查询所有数据库，合并国家与 ASN 信息
+func (db *GeoDB) LookupGeo(addr netip.Addr) GeoInfo {
+	var info GeoInfo
+	if db == nil {
+		return info
+	}
+	for _, file := range db.files {
+		var record geoRecord
+		if !file.lookup(addr, &record) {
+			continue
+		}
+		if info.Country == "" {
+			info.Country = strings.ToUpper(record.Country.ISOCode)
+			if info.Country == "" {
+				info.Country = strings.ToUpper(record.RegisteredCountry.ISOCode)
+			}
+		}
+		if info.ASN == 0 {
+			info.ASN = record.AutonomousSystemNumber
+		}
+	}
+	return info
+}
+
+func (f *geoFile) lookup(addr netip.Addr, record *geoRecord) bool {
+	f.reloadIfChanged()
+	f.mu.RLock()
+	reader := f.reader
+	f.mu.RUnlock()
+
+	result := reader.Lookup(addr)
+	if !result.Found() {
+		return false
+	}
+	if err := result.Decode(record); err != nil {
+		tlog.Warn("GeoIP 查询失败", "path", f.path, "error", err)
+		return false
+	}
+	return true
+}
+
+func (f *geoFile) reloadIfChanged() {
+	f.mu.RLock()
+	due := time.Since(f.lastChecked) >= geoFileCheckInterval
+	f.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	info, err := os.Stat(f.path)
+	f.mu.Lock()
+	f.lastChecked = time.Now()
+	unchanged := err != nil || info.ModTime().Equal(f.modTime)
+	f.mu.Unlock()
+	if unchanged {
+		return
+	}
+	if err := f.reload(); err != nil {
+		tlog.Error("重新加载 GeoIP 数据库失败", "path", f.path, "error", err)
+		return
+	}
+	tlog.Info("GeoIP 数据库已重新加载", "path", f.path)
+}
+
+func (f *geoFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("读取 GeoIP 数据库失败: %w", err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("读取 GeoIP 数据库失败: %w", err)
+	}
+	reader, err := maxminddb.OpenBytes(data)
+	if err != nil {
+		return fmt.Errorf("解析 GeoIP 数据库 %s 失败: %w", f.path, err)
+	}
+
+	f.mu.Lock()
+	f.reader = reader
+	f.modTime = info.ModTime()
+	f.lastChecked = time.Now()
+	f.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,242 @@
+package ipfilter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResolverTrustsForwardedForOnlyFromTrustedProxies(t *testing.T) {
+	resolver, err := NewResolver([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{name: "direct client ignores header", remoteAddr: "198.51.100.7:4321", forwarded: "1.2.3.4", expected: "198.51.100.7"},
+		{name: "single proxy", remoteAddr: "10.1.2.3:80", forwarded: "203.0.113.9", expected: "203.0.113.9"},
+		{name: "spoofed leftmost entry", remoteAddr: "10.1.2.3:80", forwarded: "1.2.3.4, 203.0.113.9, 192.0.2.1", expected: "203.0.113.9"},
+		{name: "all trusted", remoteAddr: "10.1.2.3:80", forwarded: "10.9.9.9, 192.0.2.1", expected: "10.9.9.9"},
+		{name: "garbage stops the walk", remoteAddr: "10.1.2.3:80", forwarded: "203.0.113.9, not-an-ip", expected: "10.1.2.3"},
+		{name: "real ip header", remoteAddr: "10.1.2.3:80", realIP: "203.0.113.10", expected: "203.0.113.10"},
+		{name: "ipv4-mapped ipv6", remoteAddr: "[::ffff:198.51.100.7]:1", expected: "198.51.100.7"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			if tc.realIP != "" {
+				r.Header.Set("X-Real-IP", tc.realIP)
+			}
+			addr, ok := resolver.ClientIP(r)
+			if !ok || addr.String() != tc.expected {
+				t.Fatalf("expected %s, got %s (ok=%v)", tc.expected, addr, ok)
+			}
+		})
+	}
+}
+
+func TestFilterAppliesDenyBeforeAllow(t *testing.T) {
+	filter, err := Compile(Rules{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32"},
+		Deny:  []string{"10.6.6.0/24"},
+	})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	cases := map[string]bool{
+		"10.1.2.3":    true,
+		"10.6.6.6":    false,
+		"2001:db8::1": true,
+		"203.0.113.1": false,
+	}
+	for ip, allowed := range cases {
+		addr, _ := netip.ParseAddr(ip)
+		if got := filter.Check(addr, nil) == ""; got != allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", ip, allowed, got)
+		}
+	}
+}
+
+func TestFilterReloadsListFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deny.txt")
+	if err := os.WriteFile(path, []byte("# abusive networks\n198.51.100.0/24\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := Compile(Rules{DenyFiles: []string{path}})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	blocked := netip.MustParseAddr("198.51.100.7")
+	other := netip.MustParseAddr("203.0.113.7")
+	if filter.Check(blocked, nil) == "" || filter.Check(other, nil) != "" {
+		t.Fatal("unexpected initial list result")
+	}
+
+	if err := os.WriteFile(path, []byte("203.0.113.0/24 # new abuse\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	file := filter.deny.files[0]
+	file.mu.Lock()
+	file.lastChecked = time.Time{}
+	file.mu.Unlock()
+
+	if filter.Check(blocked, nil) != "" || filter.Check(other, nil) == "" {
+		t.Fatal("expected list file to be reloaded")
+	}
+
+	// 写坏的文件不生效，保留上一次的列表
+	if err := os.WriteFile(path, []byte("not-a-cidr\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := future.Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	file.mu.Lock()
+	file.lastChecked = time.Time{}
+	file.mu.Unlock()
+	if filter.Check(other, nil) == "" {
+		t.Fatal("expected previous list to be kept after a bad reload")
+	}
+}
+
+func TestCompileRejectsInvalidRules(t *testing.T) {
+	for name, rules := range map[string]Rules{
+		"cidr":    {Allow: []string{"10.0.0.0/33"}},
+		"ip":      {Deny: []string{"example.com"}},
+		"asn":     {DenyASNs: []string{"AS-bad"}},
+		"missing": {AllowFiles: []string{"/nonexistent/allow.txt"}},
+	} {
+		if _, err := Compile(rules); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestFilterCountryAndASNRulesWithGeoDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	if err := os.WriteFile(path, buildTestMMDB(t, netip.MustParsePrefix("203.0.113.0/24"), "cn", 64500), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	geo, err := OpenGeoDB(path)
+	if err != nil {
+		t.Fatalf("OpenGeoDB: %v", err)
+	}
+	mapped := netip.MustParseAddr("203.0.113.5")
+	unknown := netip.MustParseAddr("198.51.100.5")
+	if info := geo.LookupGeo(mapped); info.Country != "CN" || info.ASN != 64500 {
+		t.Fatalf("unexpected geo info %+v", info)
+	}
+	if info := geo.LookupGeo(unknown); info != (GeoInfo{}) {
+		t.Fatalf("expected empty geo info, got %+v", info)
+	}
+
+	deny, err := Compile(Rules{DenyASNs: []string{"AS64500"}})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if deny.Check(mapped, geo) == "" || deny.Check(unknown, geo) != "" {
+		t.Fatal("unexpected ASN deny result")
+	}
+
+	allow, err := Compile(Rules{AllowCountries: []string{"CN"}})
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if !allow.NeedsGeo() {
+		t.Fatal("expected country rules to need a geo database")
+	}
+	if allow.Check(mapped, geo) != "" {
+		t.Fatal("expected allowed country to pass")
+	}
+	// 查不到归属的地址不满足允许规则
+	if allow.Check(unknown, geo) == "" {
+		t.Fatal("expected unknown country to be denied")
+	}
+}
+
+// buildTestMMDB 生成只包含一个 IPv4 前缀的最小 MaxMind 格式数据库
+func buildTestMMDB(t *testing.T, prefix netip.Prefix, country string, asn uint32) []byte {
+	t.Helper()
+	bits := prefix.Bits()
+	nodeCount := uint32(bits)
+	ip := prefix.Addr().As4()
+	ipBits := binary.BigEndian.Uint32(ip[:])
+
+	var buf bytes.Buffer
+	writeRecord := func(value uint32) {
+		buf.Write([]byte{byte(value >> 16), byte(value >> 8), byte(value)})
+	}
+	for i := 0; i < bits; i++ {
+		next := uint32(i + 1)
+		if i == bits-1 {
+			next = nodeCount + 16 // 指向数据区偏移 0
+		}
+		if ipBits>>(31-i)&1 == 0 {
+			writeRecord(next)
+			writeRecord(nodeCount)
+		} else {
+			writeRecord(nodeCount)
+			writeRecord(next)
+		}
+	}
+	buf.Write(make([]byte, 16))
+
+	buf.Write(mmdbMap(
+		mmdbString("country"), mmdbMap(mmdbString("iso_code"), mmdbString(country)),
+		mmdbString("autonomous_system_number"), mmdbUint(6, uint64(asn), 4),
+	))
+
+	buf.WriteString("\xab\xcd\xefMaxMind.com")
+	buf.Write(mmdbMap(
+		mmdbString("node_count"), mmdbUint(6, uint64(nodeCount), 4),
+		mmdbString("record_size"), mmdbUint(5, 24, 2),
+		mmdbString("ip_version"), mmdbUint(5, 4, 2),
+		mmdbString("database_type"), mmdbString("Telos-Test"),
+		mmdbString("languages"), []byte{0x00, 0x04}, // 空数组（扩展类型 11）
+		mmdbString("binary_format_major_version"), mmdbUint(5, 2, 2),
+		mmdbString("binary_format_minor_version"), mmdbUint(5, 0, 2),
+		mmdbString("build_epoch"), append([]byte{0x08, 0x02}, binary.BigEndian.AppendUint64(nil, uint64(time.Now().Unix()))...),
+		mmdbString("description"), mmdbMap(),
+	))
+	return buf.Bytes()
+}
+
+func mmdbString(s string) []byte {
+	return append([]byte{2<<5 | byte(len(s))}, s...)
+}
+
+func mmdbUint(typ byte, value uint64, size int) []byte {
+	out := []byte{typ<<5 | byte(size)}
+	for i := size - 1; i >= 0; i-- {
+		out = append(out, byte(value>>(8*i)))
+	}
+	return out
+}
+
+func mmdbMap(pairs ...[]byte) []byte {
+	out := []byte{7<<5 | byte(len(pairs)/2)}
+	for _, part := range pairs {
+		out = append(out, part...)
+	}
+	return out
+}
@@ -0,0 +1,142 @@
+package ipfilter
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// listFileCheckInterval 列表文件的检查间隔，文件修改后在下一次匹配时重新加载
+const listFileCheckInterval = 5 * time.Second
+
+// listFile 每行一个 IP 或 CIDR 的列表文件，"#" 之后为注释
+type listFile struct {
+	path string
+
+	mu          sync.RWMutex
+	prefixes    []netip.Prefix
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+func newListFile(path string) (*listFile, error) {
+	file := &listFile{path: path}
+	if err := file.reload(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f *listFile) contains(addr netip.Addr) bool {
+	f.reloadIfChanged()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return containsAddr(f.prefixes, addr)
+}
+
+func (f *listFile) reloadIfChanged() {
+	f.mu.RLock()
+	due := time.Since(f.lastChecked) >= listFileCheckInterval
+	f.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	info, err := os.Stat(f.path)
+	f.mu.Lock()
+	f.lastChecked = time.Now()
+	unchanged := err != nil || info.ModTime().Equal(f.modTime)
+	f.mu.Unlock()
+	if unchanged {
+		return
+	}
+	// 重新加载失败时保留旧列表，避免写坏的文件放开或封禁全部流量
+	if err := f.reload(); err != nil {
+		tlog.Error("重新加载 IP 列表文件失败", "path", f.path, "error", err)
+		return
+	}
+	tlog.Info("IP 列表文件已重新加载", "path", f.path)
+}
+
+func (f *listFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("读取 IP 列表文件失败: %w", err)
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("读取 IP 列表文件失败: %w", err)
+	}
+
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		prefix, err := parsePrefix(text)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", f.path, line, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取 IP 列表文件失败: %w", err)
+	}
+
+	f.mu.Lock()
+	f.prefixes = prefixes
+	f.modTime = info.ModTime()
+	f.lastChecked = time.Now()
+	f.mu.Unlock()
+	return nil
+}
+
+// cidrList 静态 CIDR 与列表文件的并集
+type cidrList struct {
+	prefixes []netip.Prefix
+	files    []*listFile
+}
+
+func newCIDRList(cidrs, files []string) (*cidrList, error) {
+	prefixes, err := ParsePrefixes(cidrs)
+	if err != nil {
+		return nil, err
+	}
+	list := &cidrList{prefixes: prefixes}
+	for _, path := range files {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		file, err := newListFile(path)
+		if err != nil {
+			return nil, err
+		}
+		list.files = append(list.files, file)
+	}
+	return list, nil
+}
+
+func (l *cidrList) empty() bool {
+	return len(l.prefixes) == 0 && len(l.files) == 0
+}
+
+func (l *cidrList) contains(addr netip.Addr) bool {
+	if containsAddr(l.prefixes, addr) {
+		return true
+	}
+	for _, file := range l.files {
+		if file.contains(addr) {
+			return true
+		}
+	}
+	return false
+}
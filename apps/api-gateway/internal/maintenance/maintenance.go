@@ -0,0 +1,279 @@
+// Package maintenance 网关的运行时维护开关：整个网关或单个路由进入维护状态时返回 503，
+// 白名单中的用户或 IP 仍可访问，用于维护期间的验证
+package maintenance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/ipfilter"
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// DefaultRetryAfter 维护响应默认的 Retry-After（秒）
+const DefaultRetryAfter = 300
+
+// fileCheckInterval 状态文件的检查间隔，文件被修改后在下一次检查时重新加载
+const fileCheckInterval = 5 * time.Second
+
+// Mode 一个维护开关的状态
+type Mode struct {
+	Enabled bool `json:"enabled"`
+	// Message 返回给客户端的提示，为空时使用按语言本地化的默认提示
+	Message string `json:"message,omitempty"`
+	// RetryAfter 响应的 Retry-After（秒），0 表示使用默认值
+	RetryAfter int `json:"retryAfter,omitempty"`
+	// Since 进入维护的时间
+	Since time.Time `json:"since,omitzero"`
+}
+
+// Status 所有维护开关的当前状态
+type Status struct {
+	Global Mode            `json:"global"`
+	Routes map[string]Mode `json:"routes"`
+}
+
+// Switch 维护开关，并发安全；路由按 RouteConfig.Path 标识
+type Switch struct {
+	mu     sync.RWMutex
+	global Mode
+	routes map[string]Mode
+	known  map[string]bool
+
+	bypassUsers map[string]bool
+	bypassIPs   []netip.Prefix
+
+	// file 状态文件，为空时状态只保存在内存中
+	file        string
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+// NewSwitch 创建维护开关，routes 为可以单独维护的路由路径，bypassIPs 支持单个 IP 与 CIDR
+func NewSwitch(routes, bypassUsers, bypassIPs []string) (*Switch, error) {
+	prefixes, err := ipfilter.ParsePrefixes(bypassIPs)
+	if err != nil {
+		return nil, fmt.Errorf("维护白名单 IP 无效: %w", err)
+	}
+	s := &Switch{
+		routes:      make(map[string]Mode),
+		known:       make(map[string]bool, len(routes)),
+		bypassUsers: make(map[string]bool, len(bypassUsers)),
+		bypassIPs:   prefixes,
+	}
+	for _, route := range routes {
+		s.known[route] = true
+	}
+	for _, user := range bypassUsers {
+		s.bypassUsers[user] = true
+	}
+	return s, nil
+}
+
+// Set 设置维护状态，route 为空表示整个网关；关闭时清除该开关
+// 配置了状态文件时同时写回文件，写入失败只记录日志，内存中的状态仍然生效
+func (s *Switch) Set(route string, mode Mode) error {
+	mode, err := s.validate(route, mode)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case route == "" && mode.Enabled:
+		s.global = mode
+	case route == "":
+		s.global = Mode{}
+	case mode.Enabled:
+		s.routes[route] = mode
+	default:
+		delete(s.routes, route)
+	}
+	if s.file != "" {
+		if err := s.saveLocked(); err != nil {
+			tlog.Error("保存维护状态文件失败", "path", s.file, "error", err)
+		}
+	}
+	return nil
+}
+
+func (s *Switch) validate(route string, mode Mode) (Mode, error) {
+	if route != "" && !s.known[route] {
+		return mode, fmt.Errorf("未知路由 %q", route)
+	}
+	if mode.RetryAfter < 0 {
+		return mode, fmt.Errorf("retryAfter 不能为负数")
+	}
+	if mode.Enabled && mode.Since.IsZero() {
+		mode.Since = time.Now()
+	}
+	return mode, nil
+}
+
+// UseFile 使用状态文件（与 Status 相同的 JSON）保存维护状态：文件存在时以其内容替换当前状态并返回 true，
+// 之后 Set 会写回文件，文件被外部修改（手动编辑、共享存储上的其他副本）后自动重新加载
+func (s *Switch) UseFile(path string) (bool, error) {
+	s.mu.Lock()
+	s.file = path
+	s.lastChecked = time.Now()
+	s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Switch) reloadIfChanged() {
+	s.mu.RLock()
+	due := s.file != "" && time.Since(s.lastChecked) >= fileCheckInterval
+	s.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	info, err := os.Stat(s.file)
+	s.mu.Lock()
+	s.lastChecked = time.Now()
+	unchanged := err != nil || info.ModTime().Equal(s.modTime)
+	s.mu.Unlock()
+	if unchanged {
+		return
+	}
+	// 重新加载失败时保留当前状态，避免写坏的文件让整个网关上线或下线
+	if err := s.reload(); err != nil {
+		tlog.Error("重新加载维护状态文件失败", "path", s.file, "error", err)
+		return
+	}
+	tlog.Warn("维护状态文件已重新加载", "path", s.file, "status", s.Status())
+}
+
+func (s *Switch) reload() error {
+	info, err := os.Stat(s.file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		return err
+	}
+	var status Status
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("解析维护状态文件失败: %w", err)
+	}
+
+	global := Mode{}
+	if status.Global.Enabled {
+		if global, err = s.validate("", status.Global); err != nil {
+			return err
+		}
+	}
+	routes := make(map[string]Mode, len(status.Routes))
+	for route, mode := range status.Routes {
+		if !mode.Enabled {
+			continue
+		}
+		if routes[route], err = s.validate(route, mode); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.global = global
+	s.routes = routes
+	s.modTime = info.ModTime()
+	s.lastChecked = time.Now()
+	return nil
+}
+
+// saveLocked 先写临时文件再重命名，读取方不会看到写了一半的文件；调用方需持有写锁
+func (s *Switch) saveLocked() error {
+	data, err := json.MarshalIndent(Status{Global: s.global, Routes: s.routes}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), ".maintenance-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.file); err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.file); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// Status 返回当前状态的副本
+func (s *Switch) Status() Status {
+	s.reloadIfChanged()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	routes := make(map[string]Mode, len(s.routes))
+	for route, mode := range s.routes {
+		routes[route] = mode
+	}
+	return Status{Global: s.global, Routes: routes}
+}
+
+// Active 返回路由当前生效的维护状态，路由级开关优先于全局开关
+func (s *Switch) Active(route string) (Mode, bool) {
+	s.reloadIfChanged()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	mode, ok := s.routes[route]
+	if !ok {
+		mode = s.global
+	}
+	if !mode.Enabled {
+		return Mode{}, false
+	}
+	if mode.RetryAfter == 0 {
+		mode.RetryAfter = DefaultRetryAfter
+	}
+	return mode, true
+}
+
+// HasUserBypass 是否配置了用户白名单，未配置时维护期间不需要认证请求
+func (s *Switch) HasUserBypass() bool {
+	return len(s.bypassUsers) > 0
+}
+
+// BypassUser 用户是否在白名单中
+func (s *Switch) BypassUser(userID string) bool {
+	return userID != "" && s.bypassUsers[userID]
+}
+
+// BypassIP 客户端 IP 是否在白名单中
+func (s *Switch) BypassIP(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range s.bypassIPs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package maintenance
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSwitchRoutePrecedenceAndBypass(t *testing.T) {
+	sw, err := NewSwitch([]string{"/api/runs", "/api/agent"}, []string{"user-1"}, []string{"10.8.0.0/16"})
+	if err != nil {
+		t.Fatalf("NewSwitch: %v", err)
+	}
+	if err := sw.Set("/api/unknown", Mode{Enabled: true}); err == nil {
+		t.Fatal("expected unknown route to be rejected")
+	}
+
+	if err := sw.Set("/api/runs", Mode{Enabled: true, Message: "迁移中"}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if mode, ok := sw.Active("/api/runs"); !ok || mode.Message != "迁移中" || mode.RetryAfter != DefaultRetryAfter || mode.Since.IsZero() {
+		t.Fatalf("unexpected route mode %+v %v", mode, ok)
+	}
+	if _, ok := sw.Active("/api/agent"); ok {
+		t.Fatal("expected other routes to stay online")
+	}
+
+	// 全局维护对所有路由生效，路由自己的配置优先
+	if err := sw.Set("", Mode{Enabled: true, RetryAfter: 60}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if mode, ok := sw.Active("/api/agent"); !ok || mode.RetryAfter != 60 {
+		t.Fatalf("expected global maintenance, got %+v %v", mode, ok)
+	}
+	if mode, _ := sw.Active("/api/runs"); mode.Message != "迁移中" {
+		t.Fatalf("expected route mode to take precedence, got %+v", mode)
+	}
+
+	_ = sw.Set("", Mode{})
+	_ = sw.Set("/api/runs", Mode{})
+	if status := sw.Status(); status.Global.Enabled || len(status.Routes) != 0 {
+		t.Fatalf("expected maintenance to be cleared, got %+v", status)
+	}
+
+	if !sw.BypassIP(netip.MustParseAddr("10.8.3.4")) || sw.BypassIP(netip.MustParseAddr("10.9.0.1")) || sw.BypassIP(netip.Addr{}) {
+		t.Fatal("unexpected IP bypass result")
+	}
+	if !sw.BypassUser("user-1") || sw.BypassUser("") || sw.BypassUser("user-2") {
+		t.Fatal("unexpected user bypass result")
+	}
+}
+
+func TestSwitchLoadsPersistsAndReloadsStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance.json")
+	newSwitch := func() *Switch {
+		sw, err := NewSwitch([]string{"/api/runs", "/api/agent"}, nil, nil)
+		if err != nil {
+			t.Fatalf("NewSwitch: %v", err)
+		}
+		return sw
+	}
+
+	// 文件不存在时保留启动配置
+	sw := newSwitch()
+	if loaded, err := sw.UseFile(path); err != nil || loaded {
+		t.Fatalf("expected missing file to be ignored, got %v %v", loaded, err)
+	}
+	if err := sw.Set("/api/runs", Mode{Enabled: true, Message: "迁移中", RetryAfter: 60}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// 重启后从文件恢复
+	restarted := newSwitch()
+	if loaded, err := restarted.UseFile(path); err != nil || !loaded {
+		t.Fatalf("expected state file to be loaded, got %v %v", loaded, err)
+	}
+	if mode, ok := restarted.Active("/api/runs"); !ok || mode.Message != "迁移中" || mode.RetryAfter != 60 {
+		t.Fatalf("expected persisted route mode, got %+v %v", mode, ok)
+	}
+
+	// 外部修改在下一次检查时生效，无效内容保留当前状态
+	write := func(content string, modTime time.Time) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+		restarted.mu.Lock()
+		restarted.lastChecked = time.Time{}
+		restarted.mu.Unlock()
+	}
+	write(`{"routes":{"/api/unknown":{"enabled":true}}}`, time.Now().Add(time.Minute))
+	if _, ok := restarted.Active("/api/runs"); !ok {
+		t.Fatal("expected invalid file to keep the current state")
+	}
+	write(`{"global":{"enabled":true},"routes":{}}`, time.Now().Add(2*time.Minute))
+	if _, ok := restarted.Active("/api/runs"); !ok {
+		t.Fatal("expected global maintenance after reload")
+	}
+	if status := restarted.Status(); len(status.Routes) != 0 || !status.Global.Enabled || status.Global.Since.IsZero() {
+		t.Fatalf("unexpected reloaded status %+v", status)
+	}
+
+	// 写坏的文件在启动时直接报错
+	os.WriteFile(path, []byte("{"), 0o600)
+	if _, err := newSwitch().UseFile(path); err == nil {
+		t.Fatal("expected invalid state file to be rejected at startup")
+	}
+}
@@ -0,0 +1,149 @@
+// Package metrics 提供网关内部的轻量计数器，并以 Prometheus 文本格式导出
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry 计数器注册表
+type Registry struct {
+	mu       sync.RWMutex
+	counters []*CounterVec
+}
+
+// Default 网关进程级的默认注册表
+var Default = &Registry{}
+
+// CounterVec 带标签的单调递增计数器
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.RWMutex
+	values map[string]*counter
+}
+
+type counter struct {
+	labelValues []string
+	value       atomic.Uint64
+}
+
+// NewCounterVec 在默认注册表中创建计数器
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewCounterVec 创建并注册计数器，同名计数器重复注册时返回已有的实例
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.counters {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &CounterVec{name: name, help: help, labels: labels, values: make(map[string]*counter)}
+	r.counters = append(r.counters, c)
+	return c
+}
+
+// Inc 对指定标签值的计数器加一，标签值个数必须与声明的标签一致
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.get(labelValues).value.Add(1)
+}
+
+// Value 返回指定标签值的当前计数
+func (c *CounterVec) Value(labelValues ...string) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if entry, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return entry.value.Load()
+	}
+	return 0
+}
+
+func (c *CounterVec) get(labelValues []string) *counter {
+	if len(labelValues) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s 需要 %d 个标签值，实际 %d 个", c.name, len(c.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	c.mu.RLock()
+	entry, ok := c.values[key]
+	c.mu.RUnlock()
+	if ok {
+		return entry
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok = c.values[key]; !ok {
+		entry = &counter{labelValues: append([]string(nil), labelValues...)}
+		c.values[key] = entry
+	}
+	return entry
+}
+
+// WriteTo 以 Prometheus 文本格式（0.0.4）写出所有计数器
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	r.mu.RLock()
+	counters := append([]*CounterVec(nil), r.counters...)
+	r.mu.RUnlock()
+	sort.Slice(counters, func(i, j int) bool { return counters[i].name < counters[j].name })
+
+	for _, c := range counters {
+		fmt.Fprintf(&b, "# HELP %s %s\n", c.name, escapeHelp(c.help))
+		fmt.Fprintf(&b, "# TYPE %s counter\n", c.name)
+		c.mu.RLock()
+		keys := make([]string, 0, len(c.values))
+		for key := range c.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := c.values[key]
+			b.WriteString(c.name)
+			if len(c.labels) > 0 {
+				b.WriteByte('{')
+				for i, label := range c.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", label, escapeLabelValue(entry.labelValues[i]))
+				}
+				b.WriteByte('}')
+			}
+			fmt.Fprintf(&b, " %d\n", entry.value.Load())
+		}
+		c.mu.RUnlock()
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Handler 导出默认注册表
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// Handler 以 Prometheus 文本格式导出注册表
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWritesPrometheusText(t *testing.T) {
+	registry := &Registry{}
+	rejected := registry.NewCounterVec("gateway_requests_rejected_total", "Requests rejected by the gateway.", "reason")
+	rejected.Inc("url_too_long")
+	rejected.Inc("url_too_long")
+	rejected.Inc(`quote"d`)
+
+	if registry.NewCounterVec("gateway_requests_rejected_total", "", "reason") != rejected {
+		t.Fatal("expected duplicate registration to return the existing counter")
+	}
+	if got := rejected.Value("url_too_long"); got != 2 {
+		t.Fatalf("expected 2, got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	expected := strings.Join([]string{
+		"# HELP gateway_requests_rejected_total Requests rejected by the gateway.",
+		"# TYPE gateway_requests_rejected_total counter",
+		`gateway_requests_rejected_total{reason="quote\"d"} 1`,
+		`gateway_requests_rejected_total{reason="url_too_long"} 2`,
+		"",
+	}, "\n")
+	if body != expected {
+		t.Fatalf("unexpected exposition:\n%s", body)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,311 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultCompressionMinSize 默认的最小压缩字节数
+const DefaultCompressionMinSize = 1024
+
+// CompressionConfig 响应压缩配置
+type CompressionConfig struct {
+	// MinSize 非流式响应小于该字节数时不压缩，0 表示压缩所有响应，负数表示使用默认值 1024
+	MinSize int
+	// ContentTypes 可压缩的媒体类型，支持 "text/*" 形式的通配和 "+json" 形式的后缀
+	ContentTypes []string
+}
+
+// DefaultCompressibleTypes 默认可压缩的响应类型
+var DefaultCompressibleTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"+json",
+	"+xml",
+}
+
+var (
+	gzipWriterPool   = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	brotliWriterPool = sync.Pool{New: func() any { return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression) }}
+)
+
+// CompressionMiddleware 根据 Accept-Encoding 协商 br/gzip 压缩响应
+// SSE 响应在每次 Flush 时同步刷新压缩器，保证事件实时到达客户端；
+// 后端已经压缩过（带 Content-Encoding）的响应原样透传
+func CompressionMiddleware(cfg CompressionConfig) func(http.Handler) http.Handler {
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultCompressibleTypes
+	}
+	if cfg.MinSize < 0 {
+		cfg.MinSize = DefaultCompressionMinSize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead || isUpgradeRequest(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// 无论最终是否压缩，响应内容都取决于 Accept-Encoding
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			cw := &compressWriter{ResponseWriter: w, cfg: cfg, encoding: encoding, status: http.StatusOK}
+			defer cw.close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+type responseEncoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+// compressWriter 延迟决定是否压缩：先缓冲不超过 MinSize 的数据，
+// 根据响应头和数据量决定压缩还是透传
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      CompressionConfig
+	encoding string
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	encoder     responseEncoder
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	// 1xx 信息性响应直接透传
+	if code >= 100 && code < 200 {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified || !cw.compressible() {
+		cw.passthrough()
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	// 已声明的 Content-Length 足以提前判断
+	if length, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil && length < cw.cfg.MinSize {
+		cw.passthrough()
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.cfg.MinSize {
+		if err := cw.startCompression(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush 实现 http.Flusher 接口
+// 流式响应在首次 Flush 时即开始压缩，之后每次 Flush 都会把压缩器中的数据刷出
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.decided {
+			if isEventStream(cw.Header().Get("Content-Type")) || len(cw.buf) >= cw.cfg.MinSize {
+				_ = cw.startCompression()
+			} else {
+				cw.passthrough()
+			}
+		}
+	}
+	if cw.encoder != nil {
+		_ = cw.encoder.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 实现 http.Hijacker 接口，支持 WebSocket
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// 处理器没有写任何内容，交给底层 ResponseWriter 的默认行为
+			return
+		}
+		if len(cw.buf) >= cw.cfg.MinSize && len(cw.buf) > 0 {
+			_ = cw.startCompression()
+		} else {
+			cw.passthrough()
+		}
+	}
+	if cw.encoder != nil {
+		_ = cw.encoder.Close()
+		cw.releaseEncoder()
+	}
+}
+
+// compressible 判断当前响应头是否允许压缩
+func (cw *compressWriter) compressible() bool {
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+	return matchContentType(header.Get("Content-Type"), cw.cfg.ContentTypes)
+}
+
+func (cw *compressWriter) passthrough() {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) > 0 {
+		_, _ = cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+	}
+}
+
+func (cw *compressWriter) startCompression() error {
+	if !cw.compressible() {
+		cw.passthrough()
+		return nil
+	}
+	cw.decided = true
+
+	header := cw.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", cw.encoding)
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	switch cw.encoding {
+	case "br":
+		bw := brotliWriterPool.Get().(*brotli.Writer)
+		bw.Reset(cw.ResponseWriter)
+		cw.encoder = bw
+	default:
+		gw := gzipWriterPool.Get().(*gzip.Writer)
+		gw.Reset(cw.ResponseWriter)
+		cw.encoder = gw
+	}
+
+	if len(cw.buf) > 0 {
+		_, err := cw.encoder.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+	return nil
+}
+
+func (cw *compressWriter) releaseEncoder() {
+	switch enc := cw.encoder.(type) {
+	case *brotli.Writer:
+		brotliWriterPool.Put(enc)
+	case *gzip.Writer:
+		gzipWriterPool.Put(enc)
+	}
+	cw.encoder = nil
+}
+
+// negotiateEncoding 解析 Accept-Encoding，优先 br，其次 gzip
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, encoding := range []string{"br", "gzip"} {
+		if enabled, ok := accepted[encoding]; ok {
+			if enabled {
+				return encoding
+			}
+			continue
+		}
+		if accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+func matchContentType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range patterns {
+		switch {
+		case strings.HasSuffix(pattern, "/*"):
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		case strings.HasPrefix(pattern, "+"):
+			if strings.HasSuffix(mediaType, pattern) {
+				return true
+			}
+		case mediaType == pattern:
+			return true
+		}
+	}
+	return false
+}
+
+func isEventStream(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "text/event-stream")
+}
+
+func isUpgradeRequest(r *http.Request) bool {
+	return r.Header.Get("Upgrade") != "" && strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateEncodingPrefersBrotliAndHonorsQValues(t *testing.T) {
+	cases := map[string]string{
+		"":                    "",
+		"gzip, deflate, br":   "br",
+		"gzip":                "gzip",
+		"br;q=0, gzip;q=0.5":  "gzip",
+		"*":                   "br",
+		"identity":            "",
+		"gzip;q=0, deflate":   "",
+		"br;q=0, *;q=1, gzip": "gzip",
+	}
+	for header, expected := range cases {
+		if got := negotiateEncoding(header); got != expected {
+			t.Fatalf("Accept-Encoding %q: expected %q, got %q", header, expected, got)
+		}
+	}
+}
+
+func TestCompressionMiddlewareCompressesLargeJSON(t *testing.T) {
+	body := strings.Repeat(`{"hello":"world"}`, 200)
+	handler := CompressionMiddleware(CompressionConfig{MinSize: 512})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("expected gzip body: %v", err)
+	}
+	decoded, _ := io.ReadAll(reader)
+	if string(decoded) != body {
+		t.Fatal("expected decompressed body to match original")
+	}
+}
+
+func TestCompressionMiddlewareSkipsSmallAndPrecompressedResponses(t *testing.T) {
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"small", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}},
+		{"precompressed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write([]byte(strings.Repeat("x", 4096)))
+		}},
+		{"binary", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte(strings.Repeat("x", 4096)))
+		}},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "br, gzip")
+		rec := httptest.NewRecorder()
+		CompressionMiddleware(CompressionConfig{MinSize: 1024})(tc.handler).ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != "" && tc.name != "precompressed" {
+			t.Fatalf("%s: expected no compression, got %q", tc.name, got)
+		}
+		if tc.name == "precompressed" && rec.Body.Len() != 4096 {
+			t.Fatalf("precompressed: expected body to pass through untouched, got %d bytes", rec.Body.Len())
+		}
+	}
+}
+
+func TestCompressionMiddlewareFlushesEachSSEEvent(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(CompressionMiddleware(CompressionConfig{MinSize: 1024})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		_, _ = w.Write([]byte("data: second\n\n"))
+	})))
+	defer server.Close()
+	defer close(release)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip SSE stream, got %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	// 第一个事件必须在处理器结束前即可解压读出
+	lines := make(chan string, 1)
+	go func() {
+		reader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			lines <- "error: " + err.Error()
+			return
+		}
+		line, _ := bufio.NewReader(reader).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		if line != "data: first\n" {
+			t.Fatalf("expected first event, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("first SSE event was not flushed through the compressor")
+	}
+}
+
+func TestCompressionMiddlewareMinSizeZeroCompressesEverything(t *testing.T) {
+	for _, tc := range []struct {
+		minSize    int
+		compressed bool
+	}{{0, true}, {-1, false}} {
+		handler := CompressionMiddleware(CompressionConfig{MinSize: tc.minSize})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if compressed := rec.Header().Get("Content-Encoding") == "gzip"; compressed != tc.compressed {
+			t.Fatalf("MinSize %d: expected compressed=%v, got %q", tc.minSize, tc.compressed, rec.Header().Get("Content-Encoding"))
+		}
+	}
+}
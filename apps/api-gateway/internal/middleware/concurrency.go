@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrConcurrencyLimited 并发槽位已满且排队超时
+var ErrConcurrencyLimited = errors.New("concurrency limit exceeded")
+
+// ConcurrencyLimiter 按键（通常是用户 ID）限制同时进行的请求数
+// 槽位已满时请求会短暂排队，超过排队时间仍未获得槽位则拒绝
+type ConcurrencyLimiter struct {
+	limit        int
+	queueTimeout time.Duration
+
+	mu    sync.Mutex
+	slots map[string]*concurrencySlot
+}
+
+type concurrencySlot struct {
+	sem  chan struct{}
+	refs int
+}
+
+// NewConcurrencyLimiter 创建并发限制器，limit <= 0 表示不限制
+func NewConcurrencyLimiter(limit int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		limit:        limit,
+		queueTimeout: queueTimeout,
+		slots:        make(map[string]*concurrencySlot),
+	}
+}
+
+// Acquire 为 key 获取一个并发槽位，limit <= 0 时使用默认上限
+// 返回的 release 必须在请求结束时调用，重复调用是安全的
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context, key string, limit int) (func(), error) {
+	if limit <= 0 {
+		limit = l.limit
+	}
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	slot := l.retain(key, limit)
+
+	// 优先尝试直接获取，避免无谓地创建定时器
+	select {
+	case slot.sem <- struct{}{}:
+		return l.releaseFunc(key, slot), nil
+	default:
+	}
+
+	if l.queueTimeout <= 0 {
+		l.drop(key, slot)
+		return nil, ErrConcurrencyLimited
+	}
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+
+	select {
+	case slot.sem <- struct{}{}:
+		return l.releaseFunc(key, slot), nil
+	case <-timer.C:
+		l.drop(key, slot)
+		return nil, ErrConcurrencyLimited
+	case <-ctx.Done():
+		l.drop(key, slot)
+		return nil, ctx.Err()
+	}
+}
+
+// InFlight 返回 key 当前占用的槽位数
+func (l *ConcurrencyLimiter) InFlight(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if slot, ok := l.slots[key]; ok {
+		return len(slot.sem)
+	}
+	return 0
+}
+
+func (l *ConcurrencyLimiter) retain(key string, limit int) *concurrencySlot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot, ok := l.slots[key]
+	if !ok {
+		slot = &concurrencySlot{sem: make(chan struct{}, limit)}
+		l.slots[key] = slot
+	}
+	slot.refs++
+	return slot
+}
+
+// drop 减少引用计数，无人使用时删除槽位，避免 map 无限增长
+func (l *ConcurrencyLimiter) drop(key string, slot *concurrencySlot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slot.refs--
+	if slot.refs <= 0 && l.slots[key] == slot {
+		delete(l.slots, key)
+	}
+}
+
+func (l *ConcurrencyLimiter) releaseFunc(key string, slot *concurrencySlot) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-slot.sem
+			l.drop(key, slot)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterRejectsAfterQueueTimeout(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, 20*time.Millisecond)
+
+	release, err := limiter.Acquire(context.Background(), "user:1", 0)
+	if err != nil {
+		t.Fatalf("expected first acquire to succeed: %v", err)
+	}
+	defer release()
+
+	if _, err := limiter.Acquire(context.Background(), "user:1", 0); !errors.Is(err, ErrConcurrencyLimited) {
+		t.Fatalf("expected ErrConcurrencyLimited, got %v", err)
+	}
+	if _, err := limiter.Acquire(context.Background(), "user:2", 0); err != nil {
+		t.Fatalf("expected other users to be unaffected: %v", err)
+	}
+}
+
+func TestConcurrencyLimiterQueuedRequestGetsReleasedSlot(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, time.Second)
+
+	release, err := limiter.Acquire(context.Background(), "user:1", 0)
+	if err != nil {
+		t.Fatalf("expected first acquire to succeed: %v", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		release()
+		release() // 重复释放不应多归还槽位
+	}()
+
+	second, err := limiter.Acquire(context.Background(), "user:1", 0)
+	if err != nil {
+		t.Fatalf("expected queued acquire to succeed after release: %v", err)
+	}
+	if got := limiter.InFlight("user:1"); got != 1 {
+		t.Fatalf("expected 1 in-flight request, got %d", got)
+	}
+	second()
+	if got := limiter.InFlight("user:1"); got != 0 {
+		t.Fatalf("expected slot to be released, got %d in flight", got)
+	}
+	if len(limiter.slots) != 0 {
+		t.Fatalf("expected idle slots to be removed, got %d", len(limiter.slots))
+	}
+}
+
+func TestConcurrencyLimiterHonorsContextCancellation(t *testing.T) {
+	limiter := NewConcurrencyLimiter(1, time.Minute)
+	release, _ := limiter.Acquire(context.Background(), "user:1", 0)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := limiter.Acquire(ctx, "user:1", 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 默认允许的方法与请求头；列表之外的请求头需要在策略中显式声明
+var (
+	DefaultCORSMethods = []string{
+		http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions,
+	}
+	DefaultCORSHeaders = []string{
+		"Content-Type", "Authorization", "X-Requested-With", "X-Request-ID", "X-Agent-ID",
+		"X-CSRF-Token", "Idempotency-Key", "traceparent", "tracestate",
+		// gRPC-Web 客户端发送的请求头
+		"X-Grpc-Web", "X-User-Agent", "Grpc-Timeout",
+	}
+	// Grpc-Status、Grpc-Message 供 gRPC-Web 客户端读取只有头部的错误响应
+	DefaultCORSExposedHeaders = []string{"X-Request-ID", "Retry-After", "Grpc-Status", "Grpc-Message"}
+)
+
+// DefaultCORSMaxAge 预检结果的默认缓存秒数
+const DefaultCORSMaxAge = 600
+
+// CORSPolicy 跨域策略
+type CORSPolicy struct {
+	// AllowedOrigins 允许的来源：精确来源（https://app.example.com）、
+	// 子域通配（https://*.example.com，不含 example.com 本身）或 "*"（任意来源，不能与凭证同时使用）
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// AllowedMethods 预检允许的方法，GET/HEAD/POST 属于简单方法始终允许
+	AllowedMethods []string `json:"allowedMethods"`
+	// AllowedHeaders 预检允许的请求头（不区分大小写），"*" 表示允许任意请求头
+	AllowedHeaders []string `json:"allowedHeaders"`
+	// ExposedHeaders 浏览器脚本可以读取的响应头
+	ExposedHeaders []string `json:"exposedHeaders"`
+	// AllowCredentials 是否允许携带 Cookie 等凭证
+	AllowCredentials bool `json:"allowCredentials"`
+	// MaxAge 预检结果缓存秒数，0 表示不下发 Access-Control-Max-Age
+	MaxAge int `json:"maxAge"`
+
+	compiled  bool
+	anyOrigin bool
+	origins   []originPattern
+	methods   map[string]bool
+	anyHeader bool
+	headers   map[string]bool
+
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+}
+
+// originPattern 规范化后的来源，wildcard 为 true 时 host 是必须匹配的后缀（如 ".example.com"）
+type originPattern struct {
+	scheme   string
+	host     string
+	port     string
+	wildcard bool
+}
+
+// CORSPolicyResolver 按请求路径返回路由级的跨域策略，返回 nil 时使用默认策略
+type CORSPolicyResolver func(path string) *CORSPolicy
+
+// DefaultCORSPolicy 使用默认的方法、请求头和缓存时间，来源包含 "*" 时不允许凭证
+func DefaultCORSPolicy(origins []string) CORSPolicy {
+	allowCredentials := true
+	for _, origin := range origins {
+		if origin == "*" {
+			allowCredentials = false
+		}
+	}
+	return CORSPolicy{
+		AllowedOrigins:   origins,
+		AllowedMethods:   DefaultCORSMethods,
+		AllowedHeaders:   DefaultCORSHeaders,
+		ExposedHeaders:   DefaultCORSExposedHeaders,
+		AllowCredentials: allowCredentials,
+		MaxAge:           DefaultCORSMaxAge,
+	}
+}
+
+// Compile 校验策略并预处理匹配规则，策略在使用前必须编译
+func (p *CORSPolicy) Compile() error {
+	p.anyOrigin = false
+	p.origins = nil
+	for _, origin := range p.AllowedOrigins {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if origin == "*" {
+			p.anyOrigin = true
+			continue
+		}
+		pattern, err := parseOriginPattern(origin)
+		if err != nil {
+			return err
+		}
+		p.origins = append(p.origins, pattern)
+	}
+	if p.anyOrigin && p.AllowCredentials {
+		return fmt.Errorf("AllowCredentials 不能与来源 \"*\" 同时使用")
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("MaxAge 不能为负数: %d", p.MaxAge)
+	}
+
+	p.methods = make(map[string]bool, len(p.AllowedMethods))
+	methods := make([]string, 0, len(p.AllowedMethods))
+	for _, method := range p.AllowedMethods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" || p.methods[method] {
+			continue
+		}
+		p.methods[method] = true
+		methods = append(methods, method)
+	}
+
+	p.anyHeader = false
+	p.headers = make(map[string]bool, len(p.AllowedHeaders))
+	headers := make([]string, 0, len(p.AllowedHeaders))
+	for _, header := range p.AllowedHeaders {
+		header = strings.TrimSpace(header)
+		if header == "*" {
+			p.anyHeader = true
+			continue
+		}
+		if header == "" || p.headers[strings.ToLower(header)] {
+			continue
+		}
+		p.headers[strings.ToLower(header)] = true
+		headers = append(headers, header)
+	}
+
+	p.allowMethods = strings.Join(methods, ", ")
+	p.allowHeaders = strings.Join(headers, ", ")
+	p.exposeHeaders = strings.Join(p.ExposedHeaders, ", ")
+	p.compiled = true
+	return nil
+}
+
+// AllowsOrigin 判断来源是否在策略允许范围内
+func (p *CORSPolicy) AllowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := normalizePort(scheme, u.Port())
+	for _, pattern := range p.origins {
+		if pattern.scheme != scheme || pattern.port != port {
+			continue
+		}
+		if pattern.wildcard {
+			if strings.HasSuffix(host, pattern.host) {
+				return true
+			}
+		} else if pattern.host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsMethod 简单方法无需在预检中声明
+func (p *CORSPolicy) allowsMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost:
+		return true
+	}
+	return p.methods[method]
+}
+
+// allowsHeaders 校验 Access-Control-Request-Headers 中的每个请求头
+func (p *CORSPolicy) allowsHeaders(requested []string) bool {
+	if p.anyHeader {
+		return true
+	}
+	for _, header := range requested {
+		if !p.headers[header] {
+			return false
+		}
+	}
+	return true
+}
+
+func parseOriginPattern(origin string) (originPattern, error) {
+	scheme, rest, ok := strings.Cut(origin, "://")
+	if !ok || scheme == "" || rest == "" {
+		return originPattern{}, fmt.Errorf("无效的跨域来源: %q", origin)
+	}
+	wildcard := strings.HasPrefix(rest, "*.")
+	rest = strings.TrimPrefix(rest, "*.")
+	u, err := url.Parse(scheme + "://" + rest)
+	if err != nil || u.Hostname() == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return originPattern{}, fmt.Errorf("无效的跨域来源: %q", origin)
+	}
+	if strings.Contains(u.Hostname(), "*") {
+		return originPattern{}, fmt.Errorf("通配符只能出现在来源主机名开头: %q", origin)
+	}
+	pattern := originPattern{
+		scheme:   strings.ToLower(u.Scheme),
+		host:     strings.ToLower(u.Hostname()),
+		wildcard: wildcard,
+	}
+	pattern.port = normalizePort(pattern.scheme, u.Port())
+	if wildcard {
+		pattern.host = "." + pattern.host
+	}
+	return pattern, nil
+}
+
+// normalizePort 去掉 scheme 的默认端口，使 https://a.com 与 https://a.com:443 等价
+func normalizePort(scheme, port string) string {
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		return ""
+	}
+	return port
+}
+
+// CORSMiddleware CORS 中间件，处理跨域请求与预检
+// resolve 可以为 nil；路由策略需要预先编译
+func CORSMiddleware(policy CORSPolicy, resolve CORSPolicyResolver) (func(http.Handler) http.Handler, error) {
+	if err := policy.Compile(); err != nil {
+		return nil, err
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			active := &policy
+			if resolve != nil {
+				if routePolicy := resolve(r.URL.Path); routePolicy != nil && routePolicy.compiled {
+					active = routePolicy
+				}
+			}
+
+			header := w.Header()
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && origin != "" &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+
+			// 响应内容随 Origin 变化，缓存必须区分来源
+			if !active.anyOrigin {
+				header.Add("Vary", "Origin")
+			}
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+				handlePreflight(w, r, active, origin)
+				return
+			}
+
+			if origin != "" && active.AllowsOrigin(origin) {
+				setAllowOrigin(header, active, origin)
+				if active.exposeHeaders != "" {
+					header.Set("Access-Control-Expose-Headers", active.exposeHeaders)
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// handlePreflight 应答预检请求，来源、方法或请求头不被允许时返回 403 且不带任何 CORS 头
+func handlePreflight(w http.ResponseWriter, r *http.Request, policy *CORSPolicy, origin string) {
+	method := strings.ToUpper(strings.TrimSpace(r.Header.Get("Access-Control-Request-Method")))
+	requested := parseHeaderList(r.Header.Values("Access-Control-Request-Headers"))
+	if !policy.AllowsOrigin(origin) || !policy.allowsMethod(method) || !policy.allowsHeaders(requested) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	header := w.Header()
+	setAllowOrigin(header, policy, origin)
+	if policy.allowMethods != "" {
+		header.Set("Access-Control-Allow-Methods", policy.allowMethods)
+	}
+	if len(requested) > 0 {
+		if policy.anyHeader {
+			// 携带凭证时浏览器把 "*" 当作字面量，因此回显实际请求的头
+			header.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+		} else {
+			header.Set("Access-Control-Allow-Headers", policy.allowHeaders)
+		}
+	}
+	if policy.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(policy.MaxAge))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setAllowOrigin(header http.Header, policy *CORSPolicy, origin string) {
+	if policy.anyOrigin && !policy.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if policy.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// parseHeaderList 解析逗号分隔的请求头列表，统一为小写
+func parseHeaderList(values []string) []string {
+	var headers []string
+	for _, value := range values {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.ToLower(strings.TrimSpace(header)); header != "" {
+				headers = append(headers, header)
+			}
+		}
+	}
+	return headers
+}
@@ -0,0 +1,271 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newCORSHandler(t *testing.T, policy CORSPolicy, resolve CORSPolicyResolver) (http.Handler, *int) {
+	t.Helper()
+	mw, err := CORSMiddleware(policy, resolve)
+	if err != nil {
+		t.Fatalf("CORSMiddleware: %v", err)
+	}
+	calls := 0
+	return mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+	})), &calls
+}
+
+func preflight(origin, method, headers string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, "/api/agents", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestCORSPolicyMatchesOrigins(t *testing.T) {
+	policy := CORSPolicy{AllowedOrigins: []string{"https://app.example.com", "https://*.preview.example.com", "http://localhost:3000"}}
+	if err := policy.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	cases := map[string]bool{
+		"https://app.example.com":             true,
+		"https://APP.example.com:443":         true,
+		"http://app.example.com":              false,
+		"https://app.example.com:8443":        false,
+		"https://pr-1.preview.example.com":    true,
+		"https://a.b.preview.example.com":     true,
+		"https://preview.example.com":         false,
+		"https://evilpreview.example.com":     false,
+		"https://preview.example.com.evil.io": false,
+		"http://localhost:3000":               true,
+		"http://localhost:3001":               false,
+		"null":                                false,
+		"https://app.example.com/path":        false,
+	}
+	for origin, expected := range cases {
+		if got := policy.AllowsOrigin(origin); got != expected {
+			t.Errorf("origin %q: expected %v, got %v", origin, expected, got)
+		}
+	}
+}
+
+func TestCORSPolicyCompileRejectsInvalidPolicies(t *testing.T) {
+	cases := map[string]CORSPolicy{
+		"credentials with wildcard": {AllowedOrigins: []string{"*"}, AllowCredentials: true},
+		"missing scheme":            {AllowedOrigins: []string{"app.example.com"}},
+		"inner wildcard":            {AllowedOrigins: []string{"https://app.*.example.com"}},
+		"path":                      {AllowedOrigins: []string{"https://app.example.com/api"}},
+		"negative max age":          {AllowedOrigins: []string{"https://app.example.com"}, MaxAge: -1},
+	}
+	for name, policy := range cases {
+		if err := policy.Compile(); err == nil {
+			t.Errorf("%s: expected compile error", name)
+		}
+	}
+}
+
+func TestCORSPreflightAllowed(t *testing.T) {
+	handler, calls := newCORSHandler(t, DefaultCORSPolicy([]string{"https://app.example.com"}), nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, preflight("https://app.example.com", http.MethodPatch, "Content-Type, Idempotency-Key, traceparent"))
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if *calls != 0 {
+		t.Fatal("preflight must not reach the next handler")
+	}
+	header := rec.Header()
+	if got := header.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("unexpected Allow-Origin %q", got)
+	}
+	if header.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatal("expected credentials to be allowed")
+	}
+	if !strings.Contains(header.Get("Access-Control-Allow-Methods"), "PATCH") {
+		t.Fatalf("expected PATCH in Allow-Methods, got %q", header.Get("Access-Control-Allow-Methods"))
+	}
+	if !strings.Contains(header.Get("Access-Control-Allow-Headers"), "Idempotency-Key") {
+		t.Fatalf("expected Idempotency-Key in Allow-Headers, got %q", header.Get("Access-Control-Allow-Headers"))
+	}
+	if header.Get("Access-Control-Max-Age") != "600" {
+		t.Fatalf("unexpected Max-Age %q", header.Get("Access-Control-Max-Age"))
+	}
+	vary := strings.Join(header.Values("Vary"), ",")
+	for _, field := range []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"} {
+		if !strings.Contains(vary, field) {
+			t.Fatalf("expected Vary to contain %s, got %q", field, vary)
+		}
+	}
+}
+
+func TestCORSPreflightRejected(t *testing.T) {
+	policy := DefaultCORSPolicy([]string{"https://app.example.com"})
+	policy.AllowedMethods = []string{http.MethodGet, http.MethodPost}
+	handler, calls := newCORSHandler(t, policy, nil)
+
+	cases := map[string]*http.Request{
+		"origin":  preflight("https://evil.example.com", http.MethodPost, ""),
+		"method":  preflight("https://app.example.com", http.MethodDelete, ""),
+		"headers": preflight("https://app.example.com", http.MethodPost, "Content-Type, X-Custom"),
+	}
+	for name, req := range cases {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: expected 403, got %d", name, rec.Code)
+		}
+		if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: rejected preflight must not carry CORS headers", name)
+		}
+	}
+	if *calls != 0 {
+		t.Fatal("rejected preflight must not reach the next handler")
+	}
+}
+
+func TestCORSPreflightSimpleMethodAlwaysAllowed(t *testing.T) {
+	policy := DefaultCORSPolicy([]string{"https://app.example.com"})
+	policy.AllowedMethods = []string{http.MethodPut}
+	handler, _ := newCORSHandler(t, policy, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, preflight("https://app.example.com", http.MethodPost, "content-type"))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+}
+
+func TestCORSPlainOptionsPassesThrough(t *testing.T) {
+	handler, calls := newCORSHandler(t, DefaultCORSPolicy([]string{"https://app.example.com"}), nil)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodOptions, "/api/agents", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	handler.ServeHTTP(rec, req)
+	if *calls != 1 {
+		t.Fatal("OPTIONS without Access-Control-Request-Method is not a preflight")
+	}
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	handler, calls := newCORSHandler(t, DefaultCORSPolicy([]string{"https://app.example.com"}), nil)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Fatalf("unexpected Allow-Origin %q", rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rec.Header().Get("Access-Control-Expose-Headers") != "X-Request-ID, Retry-After, Grpc-Status, Grpc-Message" {
+		t.Fatalf("unexpected Expose-Headers %q", rec.Header().Get("Access-Control-Expose-Headers"))
+	}
+	if rec.Header().Get("Vary") != "Origin" {
+		t.Fatalf("expected Vary: Origin, got %q", rec.Header().Get("Vary"))
+	}
+
+	// 不允许的来源照常转发，但不带 CORS 头，由浏览器拦截
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("disallowed origin must not receive Allow-Origin")
+	}
+	if rec.Header().Get("Vary") != "Origin" {
+		t.Fatal("expected Vary: Origin on disallowed origin too")
+	}
+
+	// 同源或非浏览器请求没有 Origin 头，仍需要 Vary 以免缓存污染
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/agents", nil))
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Vary") != "Origin" {
+		t.Fatalf("unexpected headers for request without Origin: %v", rec.Header())
+	}
+	if *calls != 3 {
+		t.Fatalf("expected all actual requests to reach the handler, got %d", *calls)
+	}
+}
+
+func TestCORSWildcardOriginNeverAllowsCredentials(t *testing.T) {
+	handler, _ := newCORSHandler(t, DefaultCORSPolicy([]string{"*"}), nil)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	req.Header.Set("Origin", "https://anywhere.example.com")
+	handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Fatalf("expected literal *, got %q", rec.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatal("wildcard origin must not allow credentials")
+	}
+}
+
+func TestCORSAnyHeaderEchoesRequestedHeaders(t *testing.T) {
+	policy := DefaultCORSPolicy([]string{"https://app.example.com"})
+	policy.AllowedHeaders = []string{"*"}
+	handler, _ := newCORSHandler(t, policy, nil)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, preflight("https://app.example.com", http.MethodPut, "X-Custom, content-type"))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Headers"); got != "x-custom, content-type" {
+		t.Fatalf("expected requested headers to be echoed, got %q", got)
+	}
+}
+
+func TestCORSRouteOverride(t *testing.T) {
+	embed := CORSPolicy{
+		AllowedOrigins: []string{"https://*.partner.example.com"},
+		AllowedMethods: []string{http.MethodGet},
+	}
+	if err := embed.Compile(); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	resolve := func(path string) *CORSPolicy {
+		if strings.HasPrefix(path, "/api/embed") {
+			return &embed
+		}
+		return nil
+	}
+	handler, _ := newCORSHandler(t, DefaultCORSPolicy([]string{"https://app.example.com"}), resolve)
+
+	req := preflight("https://widget.partner.example.com", http.MethodGet, "")
+	req.URL.Path = "/api/embed/agents"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "https://widget.partner.example.com" {
+		t.Fatalf("expected route policy to allow partner origin, got %d %v", rec.Code, rec.Header())
+	}
+	if rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatal("route policy without credentials must not allow them")
+	}
+
+	// 路由策略整体替换全局策略
+	req = preflight("https://app.example.com", http.MethodGet, "")
+	req.URL.Path = "/api/embed/agents"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected global origin to be rejected on overridden route, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, preflight("https://app.example.com", http.MethodDelete, ""))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected default policy elsewhere, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/metrics"
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// 默认的安全响应头与请求大小上限
+const (
+	DefaultHSTSMaxAge     = 31536000
+	DefaultReferrerPolicy = "strict-origin-when-cross-origin"
+	DefaultFrameOptions   = "DENY"
+	// DefaultContentSecurityPolicy 网关自身只返回 JSON 和纯文本，不需要加载任何资源
+	DefaultContentSecurityPolicy = "default-src 'none'; frame-ancestors 'none'"
+	DefaultMaxURLLength          = 8 << 10
+	DefaultMaxHeaderBytes        = 32 << 10
+)
+
+// 请求被拒绝的原因，同时作为错误码和 gateway_requests_rejected_total 的 reason 标签
+const (
+	RejectURLTooLong      = apierror.CodeURLTooLong
+	RejectHeadersTooLarge = apierror.CodeHeadersTooLarge
+	RejectAmbiguousLength = apierror.CodeAmbiguousLength
+	RejectPathTraversal   = apierror.CodePathTraversal
+)
+
+// RejectedRequests 网关在转发前拒绝的请求数
+var RejectedRequests = metrics.NewCounterVec(
+	"gateway_requests_rejected_total",
+	"Requests rejected by the gateway before reaching an upstream.",
+	"reason",
+)
+
+// HardeningConfig 安全响应头与请求加固配置，字符串为空或数值为 0 时使用默认值
+type HardeningConfig struct {
+	// HSTSMaxAge Strict-Transport-Security 的 max-age，仅在 HTTPS 请求上下发，负数表示关闭
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	// ReferrerPolicy、FrameOptions、ContentSecurityPolicy 取 "off" 时不下发对应响应头
+	ReferrerPolicy        string
+	FrameOptions          string
+	ContentSecurityPolicy string
+	// MaxURLLength 请求 URI（路径加查询）的最大长度，负数表示不限制
+	MaxURLLength int
+	// MaxHeaderBytes 请求头总大小上限，负数表示不限制
+	MaxHeaderBytes int
+}
+
+// HardeningMiddleware 为响应补充安全头，并拒绝超长或可能被前后端不同解析的请求
+// 安全头只在后端没有设置时补充，后端可以为自己的页面声明更合适的策略
+func HardeningMiddleware(cfg HardeningConfig) func(http.Handler) http.Handler {
+	headers := securityHeaders(cfg)
+	maxURLLength := defaultInt(cfg.MaxURLLength, DefaultMaxURLLength)
+	maxHeaderBytes := defaultInt(cfg.MaxHeaderBytes, DefaultMaxHeaderBytes)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if maxURLLength > 0 && len(r.RequestURI) > maxURLLength {
+				RejectRequest(w, r, RejectURLTooLong)
+				return
+			}
+			if maxHeaderBytes > 0 && headerSize(r) > maxHeaderBytes {
+				RejectRequest(w, r, RejectHeadersTooLarge)
+				return
+			}
+			if ambiguousLength(r) {
+				RejectRequest(w, r, RejectAmbiguousLength)
+				return
+			}
+			if HasPathTraversal(r.URL) {
+				RejectRequest(w, r, RejectPathTraversal)
+				return
+			}
+
+			hw := &headerWriter{ResponseWriter: w, headers: headers}
+			if cfg.HSTSMaxAge >= 0 && isHTTPS(r) {
+				hw.hsts = hstsValue(cfg)
+			}
+			next.ServeHTTP(hw, r)
+		})
+	}
+}
+
+// HasPathTraversal 检查路径中是否有 "." / ".." 段、编码的斜杠或反斜杠，
+// 这类路径在网关和后端之间可能被规范化成不同的资源
+func HasPathTraversal(u *url.URL) bool {
+	if strings.Contains(u.Path, "\\") || strings.ContainsRune(u.Path, 0) {
+		return true
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	raw := strings.ToLower(u.RawPath)
+	return strings.Contains(raw, "%2f") || strings.Contains(raw, "%5c")
+}
+
+// RejectRequest 记录拒绝原因和指标，并返回错误响应
+func RejectRequest(w http.ResponseWriter, r *http.Request, reason apierror.Code) {
+	RejectedRequests.Inc(string(reason))
+	tlog.Warn("[API Gateway] 拒绝异常请求",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"reason", reason,
+		"client_ip", getClientIP(r),
+	)
+	apierror.Write(w, r, reason)
+}
+
+// ambiguousLength 同时出现 Transfer-Encoding 与 Content-Length，或多个 Content-Length 时，
+// 前后端可能按不同方式切分请求体（请求走私）
+// net/http 已经处理了 HTTP/1.1 下的大部分情况，这里作为额外防线覆盖其余协议和转换路径
+func ambiguousLength(r *http.Request) bool {
+	contentLengths := r.Header.Values("Content-Length")
+	if len(contentLengths) > 1 {
+		return true
+	}
+	transferEncoding := len(r.TransferEncoding) > 0 || r.Header.Get("Transfer-Encoding") != ""
+	return transferEncoding && len(contentLengths) > 0
+}
+
+// headerSize 按 HTTP/1.1 的线上格式估算请求头大小
+func headerSize(r *http.Request) int {
+	size := len(r.Host)
+	for name, values := range r.Header {
+		for _, value := range values {
+			size += len(name) + len(value) + 4 // ": " 与 "\r\n"
+		}
+	}
+	return size
+}
+
+func securityHeaders(cfg HardeningConfig) [][2]string {
+	headers := [][2]string{{"X-Content-Type-Options", "nosniff"}}
+	for _, header := range [][3]string{
+		{"Referrer-Policy", cfg.ReferrerPolicy, DefaultReferrerPolicy},
+		{"X-Frame-Options", cfg.FrameOptions, DefaultFrameOptions},
+		{"Content-Security-Policy", cfg.ContentSecurityPolicy, DefaultContentSecurityPolicy},
+	} {
+		value := header[1]
+		if value == "" {
+			value = header[2]
+		}
+		if !strings.EqualFold(value, "off") {
+			headers = append(headers, [2]string{header[0], value})
+		}
+	}
+	return headers
+}
+
+func hstsValue(cfg HardeningConfig) string {
+	value := "max-age=" + strconv.Itoa(defaultInt(cfg.HSTSMaxAge, DefaultHSTSMaxAge))
+	if cfg.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	if cfg.HSTSPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func defaultInt(value, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// headerWriter 在写出响应头前补充后端没有设置的安全头
+type headerWriter struct {
+	http.ResponseWriter
+	headers     [][2]string
+	hsts        string
+	wroteHeader bool
+}
+
+func (hw *headerWriter) WriteHeader(code int) {
+	if !hw.wroteHeader {
+		hw.wroteHeader = true
+		header := hw.ResponseWriter.Header()
+		for _, h := range hw.headers {
+			if header.Get(h[0]) == "" {
+				header.Set(h[0], h[1])
+			}
+		}
+		if hw.hsts != "" && header.Get("Strict-Transport-Security") == "" {
+			header.Set("Strict-Transport-Security", hw.hsts)
+		}
+	}
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *headerWriter) Write(p []byte) (int, error) {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(p)
+}
+
+// Flush 实现 http.Flusher 接口，支持 SSE 流式响应
+func (hw *headerWriter) Flush() {
+	if !hw.wroteHeader {
+		hw.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := hw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack 实现 http.Hijacker 接口，支持 WebSocket
+func (hw *headerWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := hw.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+func (hw *headerWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newHardenedHandler(cfg HardeningConfig, handler http.HandlerFunc) http.Handler {
+	return HardeningMiddleware(cfg)(handler)
+}
+
+func TestHardeningAddsSecurityHeaders(t *testing.T) {
+	handler := newHardenedHandler(HardeningConfig{HSTSIncludeSubdomains: true}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	expected := map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"Referrer-Policy":           DefaultReferrerPolicy,
+		"Content-Security-Policy":   DefaultContentSecurityPolicy,
+		"Strict-Transport-Security": "max-age=31536000; includeSubDomains",
+		// 后端设置的值优先
+		"X-Frame-Options": "SAMEORIGIN",
+	}
+	for name, value := range expected {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("%s: expected %q, got %q", name, value, got)
+		}
+	}
+}
+
+func TestHardeningSkipsHSTSOverPlainHTTPAndHonorsOff(t *testing.T) {
+	handler := newHardenedHandler(HardeningConfig{ContentSecurityPolicy: "off"}, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	if rec.Header().Get("Strict-Transport-Security") != "" {
+		t.Fatal("HSTS must not be sent over plain HTTP")
+	}
+	if rec.Header().Get("Content-Security-Policy") != "" {
+		t.Fatal("expected CSP to be disabled")
+	}
+}
+
+func TestHardeningRejectsOversizedRequests(t *testing.T) {
+	handler := newHardenedHandler(HardeningConfig{MaxURLLength: 64, MaxHeaderBytes: 256}, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("rejected request must not reach the handler")
+	})
+
+	before := RejectedRequests.Value(string(RejectURLTooLong))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/agents?q="+strings.Repeat("a", 64), nil))
+	if rec.Code != http.StatusRequestURITooLong {
+		t.Fatalf("expected 414, got %d", rec.Code)
+	}
+	if RejectedRequests.Value(string(RejectURLTooLong)) != before+1 {
+		t.Fatal("expected url_too_long rejection to be counted")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	req.Header.Set("X-Large", strings.Repeat("b", 256))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("expected 431, got %d", rec.Code)
+	}
+}
+
+func TestHardeningRejectsAmbiguousLength(t *testing.T) {
+	handler := newHardenedHandler(HardeningConfig{}, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("rejected request must not reach the handler")
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/agents", strings.NewReader("{}"))
+	req.TransferEncoding = []string{"chunked"}
+	req.Header.Set("Content-Length", "2")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for TE+CL, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/agents", strings.NewReader("{}"))
+	req.Header["Content-Length"] = []string{"2", "20"}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for duplicate Content-Length, got %d", rec.Code)
+	}
+}
+
+func TestHasPathTraversal(t *testing.T) {
+	cases := map[string]bool{
+		"/api/agents/1":          false,
+		"/api/agents/v1.2":       false,
+		"/api/agents/../admin":   true,
+		"/api/agents/./1":        true,
+		"/api/agents/..":         true,
+		"/api/agents/%2e%2e/x":   true,
+		"/api/agents/a%2fb":      true,
+		"/api/agents/a%5C..%5Cb": true,
+	}
+	for target, expected := range cases {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if got := HasPathTraversal(req.URL); got != expected {
+			t.Errorf("%s: expected %v, got %v", target, expected, got)
+		}
+	}
+}
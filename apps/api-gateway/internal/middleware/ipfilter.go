@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/netip"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/ipfilter"
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// RejectIPDenied 客户端 IP 被访问规则拒绝
+const RejectIPDenied = apierror.CodeIPDenied
+
+// IPFilterMiddleware 按全局 IP 规则拒绝请求，路由级规则由代理在匹配路由后检查
+func IPFilterMiddleware(resolver *ipfilter.Resolver, filter *ipfilter.Filter, geo ipfilter.GeoLookup) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if filter == nil || filter.Empty() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr, _ := resolver.ClientIP(r)
+			if reason := filter.Check(addr, geo); reason != "" {
+				RejectIP(w, r, addr, reason)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RejectIP 记录被 IP 规则拒绝的请求并返回 403
+func RejectIP(w http.ResponseWriter, r *http.Request, addr netip.Addr, reason string) {
+	RejectedRequests.Inc(string(RejectIPDenied))
+	tlog.Warn("[API Gateway] IP 访问规则拒绝请求",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"client_ip", addr.String(),
+		"reason", reason,
+	)
+	apierror.Write(w, r, RejectIPDenied)
+}
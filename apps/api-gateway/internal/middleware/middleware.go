@@ -2,23 +2,16 @@ package middleware
 
 import (
 	"bufio"
-	"encoding/json"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
 	"github.com/indulgeback/telos/pkg/tlog"
 )
 
-// ErrorResponse 统一错误响应格式
-type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message"`
-	Code    int    `json:"code"`
-}
-
 // LoggingMiddleware 日志中间件，记录请求和响应信息
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -36,61 +29,6 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// CORSMiddleware CORS 中间件，处理跨域请求
-func CORSMiddleware(allowedOrigins []string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			origin := r.Header.Get("Origin")
-
-			// 检查是否允许该来源
-			allowed := false
-			allowAll := false
-			for _, allowedOrigin := range allowedOrigins {
-				if allowedOrigin == "*" {
-					allowed = true
-					allowAll = true
-					break
-				}
-				if allowedOrigin == origin {
-					allowed = true
-					break
-				}
-			}
-
-			if allowed {
-				if allowAll {
-					// 通配符时，如果有具体的 origin，则使用具体 origin 并设置 credentials
-					// 这允许 credentials 模式工作
-					if origin != "" {
-						w.Header().Set("Access-Control-Allow-Origin", origin)
-						w.Header().Set("Access-Control-Allow-Credentials", "true")
-						w.Header().Set("Vary", "Origin")
-					} else {
-						// 没有 origin 头（如同源请求或非浏览器请求），使用 *
-						w.Header().Set("Access-Control-Allow-Origin", "*")
-					}
-				} else {
-					// 具体来源时可以设置 credentials
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-					w.Header().Set("Access-Control-Allow-Credentials", "true")
-				}
-			}
-
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, X-Request-ID, X-Agent-ID")
-			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, Content-Type")
-
-			// 处理预检请求
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
-				return
-			}
-
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
 // RateLimitMiddleware 限流中间件，基于令牌桶算法
 func RateLimitMiddleware(requests int, window time.Duration) func(http.Handler) http.Handler {
 	// 简单的内存限流器
@@ -116,7 +54,7 @@ func RateLimitMiddleware(requests int, window time.Duration) func(http.Handler)
 			clientIP := getClientIP(r)
 
 			if !limiter.Allow(clientIP) {
-				writeErrorResponse(w, "请求过于频繁，请稍后再试", http.StatusTooManyRequests)
+				apierror.Write(w, r, apierror.CodeRateLimited)
 				return
 			}
 
@@ -125,20 +63,6 @@ func RateLimitMiddleware(requests int, window time.Duration) func(http.Handler)
 	}
 }
 
-// writeErrorResponse 写入错误响应
-func writeErrorResponse(w http.ResponseWriter, message string, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-
-	errorResp := ErrorResponse{
-		Error:   http.StatusText(code),
-		Message: message,
-		Code:    code,
-	}
-
-	json.NewEncoder(w).Encode(errorResp)
-}
-
 // responseWriter 包装 ResponseWriter 以捕获状态码
 type responseWriter struct {
 	http.ResponseWriter
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+const (
+	// DefaultCSRFCookieName 双重提交令牌的默认 Cookie 名
+	DefaultCSRFCookieName = "telos.csrf_token"
+	// HeaderCSRFToken 前端回传双重提交令牌的请求头
+	HeaderCSRFToken = "X-CSRF-Token"
+)
+
+// CSRFConfig 基于 Cookie 会话的跨站请求伪造防护配置
+// 只作用于会话认证的写请求；API Key、JWT 等 Bearer 凭证不会被浏览器自动携带，无需校验
+type CSRFConfig struct {
+	// TrustedOrigins 允许发起写请求的来源（scheme://host[:port]），与网关同源的请求始终允许
+	TrustedOrigins []string
+	// DoubleSubmit 启用双重提交令牌：写请求的 X-CSRF-Token 头必须与令牌 Cookie 一致
+	DoubleSubmit bool
+	// CookieName 令牌 Cookie 名，默认 telos.csrf_token
+	CookieName string
+	// CookieDomain 令牌 Cookie 的 Domain，前端与网关跨子域部署时设置
+	CookieDomain string
+}
+
+type csrfGuard struct {
+	trusted      map[string]bool
+	doubleSubmit bool
+	cookieName   string
+	cookieDomain string
+}
+
+func newCSRFGuard(cfg CSRFConfig) *csrfGuard {
+	guard := &csrfGuard{
+		trusted:      make(map[string]bool, len(cfg.TrustedOrigins)),
+		doubleSubmit: cfg.DoubleSubmit,
+		cookieName:   cfg.CookieName,
+		cookieDomain: cfg.CookieDomain,
+	}
+	if guard.cookieName == "" {
+		guard.cookieName = DefaultCSRFCookieName
+	}
+	for _, origin := range cfg.TrustedOrigins {
+		if normalized := normalizeOrigin(origin); normalized != "" {
+			guard.trusted[normalized] = true
+		}
+	}
+	return guard
+}
+
+// SetCSRF 启用 CSRF 防护；未调用时网关不做 CSRF 校验
+func (pm *ProxyManager) SetCSRF(cfg CSRFConfig) {
+	pm.csrf = newCSRFGuard(cfg)
+}
+
+// checkCSRF 校验会话认证的写请求，拒绝时记录原因；安全方法在需要时下发双重提交令牌
+func (pm *ProxyManager) checkCSRF(w http.ResponseWriter, r *http.Request, route *RouteConfig, identity *gatewayauth.Identity) bool {
+	if pm.csrf == nil || route.SkipCSRF || identity == nil || identity.Method != gatewayauth.MethodSession {
+		return true
+	}
+	if isSafeMethod(r.Method) {
+		pm.csrf.issueToken(w, r)
+		return true
+	}
+	reason := pm.csrf.check(r)
+	if reason == "" {
+		return true
+	}
+	tlog.Warn("[API Gateway] CSRF 校验失败",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"user_id", identity.UserID,
+		"origin", r.Header.Get("Origin"),
+		"reason", reason,
+	)
+	return false
+}
+
+// check 返回拒绝原因；空字符串表示允许
+func (g *csrfGuard) check(r *http.Request) string {
+	if reason := g.checkOrigin(r); reason != "" {
+		return reason
+	}
+	if g.doubleSubmit {
+		return g.checkToken(r)
+	}
+	return ""
+}
+
+// checkOrigin 校验 Origin，缺失时回退到 Referer
+// 两者都缺失时只能是非浏览器客户端或隐私设置剥离了来源，此时依据 Sec-Fetch-Site 判断
+func (g *csrfGuard) checkOrigin(r *http.Request) string {
+	origin := r.Header.Get("Origin")
+	source := "Origin"
+	if origin == "" {
+		if referer := r.Header.Get("Referer"); referer != "" {
+			origin = refererOrigin(referer)
+			source = "Referer"
+			if origin == "" {
+				return "无法解析 Referer"
+			}
+		}
+	}
+	if origin == "" {
+		if strings.EqualFold(r.Header.Get("Sec-Fetch-Site"), "cross-site") {
+			return "跨站请求缺少来源"
+		}
+		return ""
+	}
+	if origin == "null" {
+		return source + " 为 null"
+	}
+	normalized := normalizeOrigin(origin)
+	if normalized == "" {
+		return "无法解析 " + source
+	}
+	if g.trusted[normalized] || sameHost(normalized, r.Host) {
+		return ""
+	}
+	return source + " 不在可信来源中"
+}
+
+func (g *csrfGuard) checkToken(r *http.Request) string {
+	cookie, err := r.Cookie(g.cookieName)
+	if err != nil || cookie.Value == "" {
+		return "缺少 CSRF 令牌 Cookie"
+	}
+	token := r.Header.Get(HeaderCSRFToken)
+	if token == "" {
+		return "缺少 " + HeaderCSRFToken + " 请求头"
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) != 1 {
+		return "CSRF 令牌不匹配"
+	}
+	return ""
+}
+
+// issueToken 在请求未携带令牌 Cookie 时下发一个新令牌
+// Cookie 不设置 HttpOnly，前端需要读取后放入 X-CSRF-Token 请求头
+func (g *csrfGuard) issueToken(w http.ResponseWriter, r *http.Request) {
+	if !g.doubleSubmit {
+		return
+	}
+	if cookie, err := r.Cookie(g.cookieName); err == nil && cookie.Value != "" {
+		return
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		tlog.Error("[API Gateway] 生成 CSRF 令牌失败", "error", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     g.cookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(buf),
+		Path:     "/",
+		Domain:   g.cookieDomain,
+		Secure:   getScheme(r) == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// normalizeOrigin 统一为小写的 scheme://host[:port]，去掉默认端口
+func normalizeOrigin(origin string) string {
+	u, err := url.Parse(strings.TrimSpace(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		// IPv6 地址需要保留方括号
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	return scheme + "://" + host
+}
+
+func refererOrigin(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// sameHost 判断来源是否与网关自身的 Host 相同（忽略 scheme，网关可能位于 TLS 终结代理之后）
+func sameHost(origin, host string) bool {
+	if host == "" {
+		return false
+	}
+	_, originHost, _ := strings.Cut(origin, "://")
+	host = strings.ToLower(host)
+	if originHost == host {
+		return true
+	}
+	// Host 头可能带默认端口，而规范化后的来源不带
+	trimmed := strings.TrimSuffix(strings.TrimSuffix(host, ":80"), ":443")
+	return originHost == trimmed
+}
@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+)
+
+func newCSRFRequest(method, origin string) *http.Request {
+	r := httptest.NewRequest(method, "http://gateway.example.com/api/agents", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	return r
+}
+
+func TestCheckCSRFValidatesOriginForSessionWrites(t *testing.T) {
+	pm := &ProxyManager{}
+	pm.SetCSRF(CSRFConfig{TrustedOrigins: []string{"https://app.example.com"}})
+	route := &RouteConfig{Path: "/api/agents", AuthMode: AuthModeRequired}
+	session := &gatewayauth.Identity{UserID: "user-1", Method: gatewayauth.MethodSession}
+
+	cases := []struct {
+		name    string
+		method  string
+		origin  string
+		referer string
+		fetch   string
+		allowed bool
+	}{
+		{name: "trusted origin", method: http.MethodPost, origin: "https://app.example.com", allowed: true},
+		{name: "default port", method: http.MethodPost, origin: "https://APP.example.com:443", allowed: true},
+		{name: "same host", method: http.MethodDelete, origin: "https://gateway.example.com", allowed: true},
+		{name: "foreign origin", method: http.MethodPost, origin: "https://evil.example.com"},
+		{name: "null origin", method: http.MethodPut, origin: "null"},
+		{name: "trusted referer", method: http.MethodPost, referer: "https://app.example.com/agents/1", allowed: true},
+		{name: "foreign referer", method: http.MethodPost, referer: "https://evil.example.com/form"},
+		{name: "no source", method: http.MethodPost, allowed: true},
+		{name: "cross-site without source", method: http.MethodPost, fetch: "cross-site"},
+		{name: "safe method", method: http.MethodGet, origin: "https://evil.example.com", allowed: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newCSRFRequest(tc.method, tc.origin)
+			if tc.referer != "" {
+				r.Header.Set("Referer", tc.referer)
+			}
+			if tc.fetch != "" {
+				r.Header.Set("Sec-Fetch-Site", tc.fetch)
+			}
+			if got := pm.checkCSRF(httptest.NewRecorder(), r, route, session); got != tc.allowed {
+				t.Fatalf("expected allowed=%v, got %v", tc.allowed, got)
+			}
+		})
+	}
+}
+
+func TestCheckCSRFSkipsBearerClientsAndOptedOutRoutes(t *testing.T) {
+	pm := &ProxyManager{}
+	pm.SetCSRF(CSRFConfig{})
+	route := &RouteConfig{Path: "/api/agents", AuthMode: AuthModeRequired}
+	r := newCSRFRequest(http.MethodPost, "https://evil.example.com")
+
+	for _, method := range []gatewayauth.Method{gatewayauth.MethodAPIKey, gatewayauth.MethodJWT} {
+		identity := &gatewayauth.Identity{UserID: "user-1", Method: method}
+		if !pm.checkCSRF(httptest.NewRecorder(), r, route, identity) {
+			t.Fatalf("expected %s requests to skip CSRF checks", method)
+		}
+	}
+
+	session := &gatewayauth.Identity{UserID: "user-1", Method: gatewayauth.MethodSession}
+	if pm.checkCSRF(httptest.NewRecorder(), r, route, session) {
+		t.Fatal("expected session request from foreign origin to be rejected")
+	}
+	route.SkipCSRF = true
+	if !pm.checkCSRF(httptest.NewRecorder(), r, route, session) {
+		t.Fatal("expected opted-out route to skip CSRF checks")
+	}
+}
+
+func TestCheckCSRFDoubleSubmitToken(t *testing.T) {
+	pm := &ProxyManager{}
+	pm.SetCSRF(CSRFConfig{TrustedOrigins: []string{"https://app.example.com"}, DoubleSubmit: true})
+	route := &RouteConfig{Path: "/api/agents", AuthMode: AuthModeRequired}
+	session := &gatewayauth.Identity{UserID: "user-1", Method: gatewayauth.MethodSession}
+
+	// 安全方法下发令牌
+	w := httptest.NewRecorder()
+	if !pm.checkCSRF(w, newCSRFRequest(http.MethodGet, ""), route, session) {
+		t.Fatal("expected GET to be allowed")
+	}
+	setCookie := w.Header().Get("Set-Cookie")
+	if !strings.HasPrefix(setCookie, DefaultCSRFCookieName+"=") || strings.Contains(setCookie, "HttpOnly") {
+		t.Fatalf("expected readable CSRF cookie, got %q", setCookie)
+	}
+	token := strings.TrimPrefix(strings.SplitN(setCookie, ";", 2)[0], DefaultCSRFCookieName+"=")
+
+	r := newCSRFRequest(http.MethodPost, "https://app.example.com")
+	r.AddCookie(&http.Cookie{Name: DefaultCSRFCookieName, Value: token})
+	if pm.checkCSRF(httptest.NewRecorder(), r, route, session) {
+		t.Fatal("expected write without token header to be rejected")
+	}
+	r.Header.Set(HeaderCSRFToken, "forged")
+	if pm.checkCSRF(httptest.NewRecorder(), r, route, session) {
+		t.Fatal("expected mismatched token to be rejected")
+	}
+	r.Header.Set(HeaderCSRFToken, token)
+	if !pm.checkCSRF(httptest.NewRecorder(), r, route, session) {
+		t.Fatal("expected matching token to be allowed")
+	}
+
+	// 已有令牌时不重复下发
+	get := newCSRFRequest(http.MethodGet, "")
+	get.AddCookie(&http.Cookie{Name: DefaultCSRFCookieName, Value: token})
+	w = httptest.NewRecorder()
+	pm.checkCSRF(w, get, route, session)
+	if w.Header().Get("Set-Cookie") != "" {
+		t.Fatal("expected existing token to be kept")
+	}
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/metrics"
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// 计算请求体摘要时的默认上限：内存中最多缓冲 DefaultDigestMaxBytes，超出部分写入临时文件，
+// 请求体总长度超过 DefaultDigestSpillBytes（或路由的 MaxBodyBytes）时不计算摘要
+const (
+	DefaultDigestMaxBytes   = 1 << 20
+	DefaultDigestSpillBytes = 32 << 20
+)
+
+// 签名降级为 v2（不覆盖请求体）的原因，同时作为 gateway_identity_digest_downgrades_total 的 reason 标签
+const (
+	downgradeGRPC     = "grpc"
+	downgradeTooLarge = "too_large"
+)
+
+// DigestDowngrades 配置 v3 签名但没有计算请求体摘要、降级为 v2 签名的请求数
+var DigestDowngrades = metrics.NewCounterVec(
+	"gateway_identity_digest_downgrades_total",
+	"Requests signed without a body digest although v3 signatures are enabled.",
+	"route", "reason",
+)
+
+// SetDigestLimit 设置 v3 签名计算请求体摘要时在内存中缓冲的上限，小于等于 0 时使用默认值
+func (pm *ProxyManager) SetDigestLimit(limit int64) {
+	if limit <= 0 {
+		limit = DefaultDigestMaxBytes
+	}
+	pm.digestLimit = limit
+}
+
+// SetDigestSpillLimit 设置计算请求体摘要时请求体的总上限（内存加临时文件），小于等于 0 时使用默认值
+func (pm *ProxyManager) SetDigestSpillLimit(limit int64) {
+	if limit <= 0 {
+		limit = DefaultDigestSpillBytes
+	}
+	pm.digestSpillLimit = limit
+}
+
+func (pm *ProxyManager) digestMaxBytes() int64 {
+	if pm.digestLimit <= 0 {
+		return DefaultDigestMaxBytes
+	}
+	return pm.digestLimit
+}
+
+// digestSpillBytes 路由的请求体上限更小时以路由为准，超出路由上限的请求体在校验阶段已被拒绝
+func (pm *ProxyManager) digestSpillBytes(route *RouteConfig) int64 {
+	limit := pm.digestSpillLimit
+	if limit <= 0 {
+		limit = DefaultDigestSpillBytes
+	}
+	if route.MaxBodyBytes > 0 && route.MaxBodyBytes < limit {
+		limit = route.MaxBodyBytes
+	}
+	return max(limit, pm.digestMaxBytes())
+}
+
+// digestRequestBody 边读边计算请求体的 Content-Digest，并把请求体替换为缓冲后的副本：
+// 不超过内存上限的部分保存在内存中，其余写入临时文件，临时文件在 Transport 关闭请求体时删除。
+// gRPC 请求（客户端流无法提前缓冲）和超过总上限的请求体不计算摘要，返回的 reason 为降级原因；
+// 长度未知的请求体读到总上限后仍未结束时，已读取的部分与剩余部分拼接后照常转发
+func (pm *ProxyManager) digestRequestBody(r *http.Request, route *RouteConfig) (digest string, reason string, err error) {
+	if route.Mode == RouteModeGRPC {
+		return "", downgradeGRPC, nil
+	}
+	if r.Body == nil || r.Body == http.NoBody {
+		return gatewayidentity.DigestBytes(nil), "", nil
+	}
+	limit := pm.digestSpillBytes(route)
+	if r.ContentLength > limit {
+		return "", downgradeTooLarge, nil
+	}
+
+	hash := gatewayidentity.DigestHash()
+	reader := io.TeeReader(r.Body, hash)
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, reader, pm.digestMaxBytes()+1)
+	if err != nil && err != io.EOF {
+		_ = r.Body.Close()
+		return "", "", err
+	}
+	if n <= pm.digestMaxBytes() {
+		_ = r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(buf.Bytes()))
+		r.ContentLength = n
+		return gatewayidentity.ContentDigest(hash.Sum(nil)), "", nil
+	}
+
+	// 大请求体溢出到临时文件，避免占用过多内存
+	file, err := os.CreateTemp("", "gateway-body-*")
+	if err != nil {
+		_ = r.Body.Close()
+		return "", "", err
+	}
+	spilled := &tempFileBody{File: file}
+	size, err := io.Copy(file, io.LimitReader(io.MultiReader(&buf, reader), limit+1))
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = r.Body.Close()
+		spilled.Close()
+		return "", "", err
+	}
+	if size > limit {
+		// 长度未知且超过上限：先转发已读取的部分，再继续流式转发剩余部分
+		r.Body = &spilledPrefixBody{Reader: io.MultiReader(spilled, r.Body), prefix: spilled, rest: r.Body}
+		return "", downgradeTooLarge, nil
+	}
+	_ = r.Body.Close()
+	r.Body = spilled
+	r.ContentLength = size
+	return gatewayidentity.ContentDigest(hash.Sum(nil)), "", nil
+}
+
+// logDigestDowngrade 记录签名降级，开启 Verifier.RequireDigest 的后端会拒绝这些请求
+func logDigestDowngrade(r *http.Request, route *RouteConfig, identity *gatewayauth.Identity, reason string) {
+	DigestDowngrades.Inc(route.Path, reason)
+	tlog.Warn("[API Gateway] 请求体未计算摘要，签名降级为 v2",
+		"path", r.URL.Path,
+		"route", route.Path,
+		"user_id", identity.UserID,
+		"content_length", r.ContentLength,
+		"reason", reason,
+	)
+}
+
+// tempFileBody 读完后由 Transport 关闭，关闭时删除临时文件
+type tempFileBody struct {
+	*os.File
+	once sync.Once
+}
+
+func (b *tempFileBody) Close() error {
+	b.once.Do(func() {
+		_ = b.File.Close()
+		_ = os.Remove(b.File.Name())
+	})
+	return nil
+}
+
+// spilledPrefixBody 临时文件中的前缀加上尚未读取的原始请求体
+type spilledPrefixBody struct {
+	io.Reader
+	prefix io.Closer
+	rest   io.Closer
+}
+
+func (b *spilledPrefixBody) Close() error {
+	_ = b.prefix.Close()
+	return b.rest.Close()
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
+)
+
+func newDigestAuthenticator(t *testing.T) *gatewayauth.Authenticator {
+	t.Helper()
+	betterAuth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"session":{"id":"session-1"},"user":{"id":"user-1"}}`))
+	}))
+	t.Cleanup(betterAuth.Close)
+	authenticator := gatewayauth.NewAuthenticator(gatewayauth.Config{
+		BetterAuthBaseURL:     betterAuth.URL,
+		BetterAuthSessionPath: "/get-session",
+		GatewayInternalSecret: "test-secret",
+		CacheTTL:              time.Minute,
+		SignatureVersion:      gatewayidentity.VersionDigest,
+	})
+	t.Cleanup(authenticator.Stop)
+	return authenticator
+}
+
+func TestInjectIdentityHeadersDigestsBodiesUpToSpillLimit(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	pm := NewProxyManager(nil, newDigestAuthenticator(t))
+	pm.SetDigestLimit(64)
+	pm.SetDigestSpillLimit(128)
+	identity := &gatewayauth.Identity{UserID: "user-1"}
+	route := &RouteConfig{Path: "/api/agent", AuthMode: AuthModeRequired}
+
+	inject := func(body []byte, chunked bool) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/agent?b=2&a=1", bytes.NewReader(body))
+		if chunked {
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = -1
+		}
+		if err := pm.injectIdentityHeaders(req, route, identity, "/api/agent"); err != nil {
+			t.Fatalf("injectIdentityHeaders: %v", err)
+		}
+		if data, _ := io.ReadAll(req.Body); !bytes.Equal(data, body) {
+			t.Fatalf("expected body of %d bytes to be preserved, got %d bytes", len(body), len(data))
+		}
+		req.Body.Close()
+		return req
+	}
+
+	// 内存缓冲、溢出到临时文件（包括长度未知的请求体）都计算摘要
+	for _, tc := range []struct {
+		size    int
+		chunked bool
+	}{{10, false}, {100, false}, {100, true}} {
+		body := bytes.Repeat([]byte("a"), tc.size)
+		req := inject(body, tc.chunked)
+		if req.Header.Get(gatewayidentity.HeaderContentDigest) != gatewayidentity.DigestBytes(body) || req.ContentLength != int64(tc.size) {
+			t.Fatalf("expected digest of %d bytes (chunked=%v), got %q", tc.size, tc.chunked, req.Header.Get(gatewayidentity.HeaderContentDigest))
+		}
+	}
+	if files, _ := filepath.Glob(filepath.Join(os.TempDir(), "gateway-body-*")); len(files) != 0 {
+		t.Fatalf("expected temp files to be removed after close, got %v", files)
+	}
+
+	// 超过总上限的请求体不计算摘要，签名降级为 v2 并计入指标
+	before := DigestDowngrades.Value("/api/agent", downgradeTooLarge)
+	for _, chunked := range []bool{false, true} {
+		req := inject(bytes.Repeat([]byte("a"), 200), chunked)
+		if req.Header.Get(gatewayidentity.HeaderContentDigest) != "" {
+			t.Fatalf("expected oversized body (chunked=%v) to be forwarded without a digest", chunked)
+		}
+		if req.Header.Get(gatewayidentity.HeaderSignature) == "" || req.Header.Get(gatewayidentity.HeaderKeyID) == "" {
+			t.Fatalf("expected a keyed signature, got %v", req.Header)
+		}
+	}
+	if got := DigestDowngrades.Value("/api/agent", downgradeTooLarge) - before; got != 2 {
+		t.Fatalf("expected 2 downgrades to be counted, got %d", got)
+	}
+}
+
+func TestGRPCClientStreamIsNotBufferedForDigest(t *testing.T) {
+	// 后端收到第一条消息后立即回复，此时客户端尚未结束请求流
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first := make([]byte, len(grpcFrame(0, []byte("one"))))
+		if _, err := io.ReadFull(r.Body, first); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("X-Content-Digest", r.Header.Get(gatewayidentity.HeaderContentDigest))
+		w.Header().Set("X-User-Signed", r.Header.Get("X-User-ID"))
+		w.WriteHeader(http.StatusOK)
+		w.Write(first)
+		w.(http.Flusher).Flush()
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	pm := NewProxyManager(newTestDiscovery(t, "echo-service", backend.Listener.Addr(), nil), newDigestAuthenticator(t))
+	if err := pm.LoadRoutes([]RouteConfig{{Path: "/test.Echo/", ServiceName: "echo-service", Mode: RouteModeGRPC, AuthMode: AuthModeRequired}}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+	gateway := httptest.NewUnstartedServer(pm)
+	gateway.Config.Protocols = new(http.Protocols)
+	gateway.Config.Protocols.SetUnencryptedHTTP2(true)
+	gateway.Start()
+	defer gateway.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, writer := io.Pipe()
+	defer writer.Close()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, gateway.URL+"/test.Echo/Upload", stream)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("Cookie", "telos.session_token=ok")
+	go writer.Write(grpcFrame(0, []byte("one")))
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("expected response before the client stream ends: %v", err)
+	}
+	defer resp.Body.Close()
+	first := make([]byte, len(grpcFrame(0, []byte("one"))))
+	if _, err := io.ReadFull(resp.Body, first); err != nil {
+		t.Fatalf("read first message: %v", err)
+	}
+	if resp.Header.Get("X-User-Signed") != "user-1" || resp.Header.Get("X-Content-Digest") != "" {
+		t.Fatalf("expected signed identity without a body digest, got %v", resp.Header)
+	}
+	if DigestDowngrades.Value("/test.Echo/", downgradeGRPC) == 0 {
+		t.Fatal("expected gRPC downgrade to be counted")
+	}
+}
@@ -0,0 +1,377 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RouteMode 路由的代理方式
+type RouteMode string
+
+const (
+	// RouteModeHTTP 默认，按普通 HTTP 请求转发
+	RouteModeHTTP RouteMode = "http"
+	// RouteModeGRPC 转发原生 gRPC，并把浏览器的 gRPC-Web 请求转换为 gRPC；后端必须支持 HTTP/2，明文实例使用 h2c
+	RouteModeGRPC RouteMode = "grpc"
+)
+
+// grpcProtocol 客户端使用的 gRPC 协议，由 Content-Type 判断
+type grpcProtocol int
+
+const (
+	grpcNone grpcProtocol = iota
+	grpcNative
+	grpcWeb
+	grpcWebText
+)
+
+const (
+	headerGRPCStatus  = "Grpc-Status"
+	headerGRPCMessage = "Grpc-Message"
+	headerGRPCTimeout = "Grpc-Timeout"
+)
+
+// gRPC 状态码，见 https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	grpcCodeUnknown           = 2
+	grpcCodeInvalidArgument   = 3
+	grpcCodeDeadlineExceeded  = 4
+	grpcCodePermissionDenied  = 7
+	grpcCodeResourceExhausted = 8
+	grpcCodeUnimplemented     = 12
+	grpcCodeInternal          = 13
+	grpcCodeUnavailable       = 14
+	grpcCodeUnauthenticated   = 16
+)
+
+// grpcErrorBodyLimit 转换为 grpc-message 的错误响应体上限
+const grpcErrorBodyLimit = 4 << 10
+
+// grpcWebTrailerFlag gRPC-Web 响应体中 trailer 帧的标志位
+const grpcWebTrailerFlag = 0x80
+
+func detectGRPC(r *http.Request) grpcProtocol {
+	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	switch {
+	case hasMediaPrefix(contentType, "application/grpc-web-text"):
+		return grpcWebText
+	case hasMediaPrefix(contentType, "application/grpc-web"):
+		return grpcWeb
+	case hasMediaPrefix(contentType, "application/grpc"):
+		return grpcNative
+	default:
+		return grpcNone
+	}
+}
+
+// hasMediaPrefix 匹配 application/grpc 及 application/grpc+proto 这类带编码后缀的类型
+func hasMediaPrefix(contentType, prefix string) bool {
+	return contentType == prefix || strings.HasPrefix(contentType, prefix+"+")
+}
+
+// grpcContentType 在 gRPC 与 gRPC-Web 之间转换 Content-Type，保留 +proto 等编码后缀
+func grpcContentType(contentType string, protocol grpcProtocol) string {
+	contentType, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(contentType)), ";")
+	suffix := ""
+	if i := strings.IndexByte(contentType, '+'); i >= 0 {
+		suffix = contentType[i:]
+	}
+	switch protocol {
+	case grpcWeb:
+		return "application/grpc-web" + suffix
+	case grpcWebText:
+		return "application/grpc-web-text" + suffix
+	default:
+		return "application/grpc" + suffix
+	}
+}
+
+// translateGRPCWebRequest 把 gRPC-Web 请求改写为发往后端的 gRPC 请求
+func translateGRPCWebRequest(r *http.Request, protocol grpcProtocol) {
+	r.Header.Set("Content-Type", grpcContentType(r.Header.Get("Content-Type"), grpcNative))
+	r.Header.Set("Te", "trailers")
+	r.Header.Del("X-Grpc-Web")
+	if protocol == grpcWebText && r.Body != nil && r.Body != http.NoBody {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{base64.NewDecoder(base64.StdEncoding, r.Body), r.Body}
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+	}
+}
+
+// withGRPCDeadline 按 grpc-timeout 设置转发的截止时间，路由配置了 Timeout 时不超过路由超时
+func withGRPCDeadline(r *http.Request, route *RouteConfig) (*http.Request, context.CancelFunc, error) {
+	timeout := time.Duration(0)
+	if value := r.Header.Get(headerGRPCTimeout); value != "" {
+		parsed, err := parseGRPCTimeout(value)
+		if err != nil {
+			return r, func() {}, err
+		}
+		timeout = parsed
+	}
+	if route.Timeout > 0 {
+		if limit := time.Duration(route.Timeout) * time.Second; timeout == 0 || limit < timeout {
+			timeout = limit
+		}
+	}
+	if timeout == 0 {
+		return r, func() {}, nil
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return r.WithContext(ctx), cancel, nil
+}
+
+// setGRPCTimeout 把剩余时间写回 grpc-timeout，后端看到的截止时间已扣除网关内的认证等耗时
+func setGRPCTimeout(r *http.Request) bool {
+	deadline, ok := r.Context().Deadline()
+	if !ok {
+		return true
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return false
+	}
+	r.Header.Set(headerGRPCTimeout, formatGRPCTimeout(remaining))
+	return true
+}
+
+// parseGRPCTimeout 解析 grpc-timeout，格式为至多 8 位数字加单位（H、M、S、m、u、n）
+func parseGRPCTimeout(value string) (time.Duration, error) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, fmt.Errorf("grpc-timeout 格式无效: %q", value)
+	}
+	amount, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("grpc-timeout 格式无效: %q", value)
+	}
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, fmt.Errorf("grpc-timeout 单位无效: %q", value)
+	}
+	return time.Duration(amount) * unit, nil
+}
+
+// formatGRPCTimeout 选择能用 8 位数字表示的最小单位
+func formatGRPCTimeout(d time.Duration) string {
+	const maxAmount = 99999999
+	units := []struct {
+		unit   time.Duration
+		suffix string
+	}{
+		{time.Nanosecond, "n"},
+		{time.Microsecond, "u"},
+		{time.Millisecond, "m"},
+		{time.Second, "S"},
+		{time.Minute, "M"},
+		{time.Hour, "H"},
+	}
+	for _, u := range units {
+		// 向上取整，避免把剩余时间截断为 0
+		amount := (d + u.unit - 1) / u.unit
+		if amount <= maxAmount {
+			return strconv.FormatInt(int64(amount), 10) + u.suffix
+		}
+	}
+	return strconv.Itoa(maxAmount) + "H"
+}
+
+// grpcCodeForHTTPStatus 把网关或后端返回的 HTTP 状态码映射为 gRPC 状态码
+func grpcCodeForHTTPStatus(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcCodeInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcCodeUnauthenticated
+	case http.StatusForbidden:
+		return grpcCodePermissionDenied
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return grpcCodeUnimplemented
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return grpcCodeDeadlineExceeded
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return grpcCodeResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcCodeUnavailable
+	}
+	if status >= 500 {
+		return grpcCodeInternal
+	}
+	return grpcCodeUnknown
+}
+
+// encodeGRPCMessage 按 gRPC 规范对 grpc-message 做百分号编码
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// grpcErrorMessage 从网关的 JSON 错误响应中取 message，其他响应体按文本使用
+func grpcErrorMessage(status int, body []byte) string {
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Message != "" {
+		return payload.Message
+	}
+	if text := strings.TrimSpace(string(body)); text != "" {
+		return text
+	}
+	return http.StatusText(status)
+}
+
+// grpcResponseWriter 包装 gRPC 请求的响应：
+// 网关或后端返回的非 200 HTTP 响应转换为只有头部的 gRPC 错误响应（grpc-status、grpc-message）；
+// gRPC-Web 客户端无法读取 HTTP trailer，trailer 改为追加到响应体末尾的 trailer 帧，-text 变体再做 base64 编码
+type grpcResponseWriter struct {
+	http.ResponseWriter
+	protocol grpcProtocol
+
+	// status 非 200 的 HTTP 状态码，响应体缓冲后用作 grpc-message
+	status       int
+	errorBody    bytes.Buffer
+	wroteHeader  bool
+	trailerNames []string
+}
+
+func newGRPCResponseWriter(w http.ResponseWriter, protocol grpcProtocol) *grpcResponseWriter {
+	return &grpcResponseWriter{ResponseWriter: w, protocol: protocol}
+}
+
+func (gw *grpcResponseWriter) WriteHeader(code int) {
+	if gw.wroteHeader || gw.status != 0 {
+		return
+	}
+	if code != http.StatusOK {
+		gw.status = code
+		return
+	}
+	gw.wroteHeader = true
+	if gw.protocol == grpcWeb || gw.protocol == grpcWebText {
+		header := gw.Header()
+		header.Set("Content-Type", grpcContentType(header.Get("Content-Type"), gw.protocol))
+		header.Del("Content-Length")
+		for _, value := range header.Values("Trailer") {
+			for _, name := range strings.Split(value, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					gw.trailerNames = append(gw.trailerNames, textproto.CanonicalMIMEHeaderKey(name))
+				}
+			}
+		}
+		header.Del("Trailer")
+	}
+	gw.ResponseWriter.WriteHeader(code)
+}
+
+func (gw *grpcResponseWriter) Write(p []byte) (int, error) {
+	if !gw.wroteHeader && gw.status == 0 {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.status != 0 {
+		if remaining := grpcErrorBodyLimit - gw.errorBody.Len(); remaining > 0 {
+			gw.errorBody.Write(p[:min(len(p), remaining)])
+		}
+		return len(p), nil
+	}
+	if gw.protocol == grpcWebText {
+		// 每次写入单独编码（带填充），gRPC-Web 客户端按块解码，流式响应无需等待凑齐 3 字节
+		if _, err := io.WriteString(gw.ResponseWriter, base64.StdEncoding.EncodeToString(p)); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	return gw.ResponseWriter.Write(p)
+}
+
+func (gw *grpcResponseWriter) Flush() {
+	if !gw.wroteHeader {
+		return
+	}
+	_ = http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+func (gw *grpcResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// finish 在请求处理结束后调用，写出错误状态或 gRPC-Web 的 trailer 帧
+func (gw *grpcResponseWriter) finish() {
+	if gw.status != 0 {
+		gw.writeStatus(grpcCodeForHTTPStatus(gw.status), grpcErrorMessage(gw.status, gw.errorBody.Bytes()))
+		return
+	}
+	if !gw.wroteHeader || (gw.protocol != grpcWeb && gw.protocol != grpcWebText) {
+		return
+	}
+
+	header := gw.Header()
+	trailers := make(http.Header)
+	for _, name := range gw.trailerNames {
+		if values, ok := header[name]; ok {
+			trailers[name] = values
+			delete(header, name)
+		}
+	}
+	for key, values := range header {
+		if name, ok := strings.CutPrefix(key, http.TrailerPrefix); ok {
+			trailers[textproto.CanonicalMIMEHeaderKey(name)] = values
+			delete(header, key)
+		}
+	}
+	if len(trailers) == 0 {
+		return
+	}
+
+	var block bytes.Buffer
+	for name, values := range trailers {
+		for _, value := range values {
+			block.WriteString(strings.ToLower(name) + ": " + value + "\r\n")
+		}
+	}
+	frame := make([]byte, 5, 5+block.Len())
+	frame[0] = grpcWebTrailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(block.Len()))
+	frame = append(frame, block.Bytes()...)
+	_, _ = gw.Write(frame)
+	gw.Flush()
+}
+
+// writeStatus 写出只有头部的 gRPC 响应（Trailers-Only），HTTP 状态码固定为 200
+func (gw *grpcResponseWriter) writeStatus(code int, message string) {
+	header := gw.Header()
+	header.Del("Content-Length")
+	header.Del("Content-Encoding")
+	header.Set("Content-Type", grpcContentType("", gw.protocol))
+	header.Set(headerGRPCStatus, strconv.Itoa(code))
+	header.Set(headerGRPCMessage, encodeGRPCMessage(message))
+	gw.wroteHeader = true
+	gw.ResponseWriter.WriteHeader(http.StatusOK)
+}
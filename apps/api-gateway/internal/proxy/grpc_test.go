@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newGRPCBackend 模拟 gRPC 后端：回显请求消息，并在 trailer 中返回状态和收到的截止时间
+func newGRPCBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || detectGRPC(r) != grpcNative || r.Header.Get("Te") != "trailers" {
+			http.Error(w, "not a gRPC request", http.StatusUnsupportedMediaType)
+			return
+		}
+		if r.URL.Path == "/test.Echo/Slow" {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+			return
+		}
+		message, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message, X-Timeout")
+		w.WriteHeader(http.StatusOK)
+		w.Write(message)
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "")
+		w.Header().Set("X-Timeout", r.Header.Get(headerGRPCTimeout)+" "+r.Header.Get("X-Request-Tag"))
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func newGRPCProxyManager(t *testing.T, backend *httptest.Server, authMode AuthMode) *ProxyManager {
+	t.Helper()
+	pm := NewProxyManager(newTestDiscovery(t, "echo-service", backend.Listener.Addr(), nil), nil)
+	if err := pm.LoadRoutes([]RouteConfig{{Path: "/test.Echo/", ServiceName: "echo-service", Mode: RouteModeGRPC, AuthMode: authMode}}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+	return pm
+}
+
+func grpcFrame(flag byte, payload []byte) []byte {
+	frame := []byte{flag, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	return append(frame, payload...)
+}
+
+func TestGRPCRouteProxiesNativeGRPCWithTrailers(t *testing.T) {
+	pm := newGRPCProxyManager(t, newGRPCBackend(t), AuthModePublic)
+	gateway := httptest.NewUnstartedServer(pm)
+	gateway.Config.Protocols = new(http.Protocols)
+	gateway.Config.Protocols.SetUnencryptedHTTP2(true)
+	gateway.Start()
+	defer gateway.Close()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	message := grpcFrame(0, []byte("hello"))
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/test.Echo/Say", bytes.NewReader(message))
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set(headerGRPCTimeout, "5S")
+	req.Header.Set("X-Request-Tag", "metadata")
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if !bytes.Equal(body, message) {
+		t.Fatalf("unexpected response message %q", body)
+	}
+	if resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatalf("expected grpc-status trailer, got %v", resp.Trailer)
+	}
+	timeout, tag, _ := strings.Cut(resp.Trailer.Get("X-Timeout"), " ")
+	forwarded, err := parseGRPCTimeout(timeout)
+	if err != nil || forwarded <= 0 || forwarded > 5*time.Second {
+		t.Fatalf("expected remaining deadline to be forwarded, got %q", timeout)
+	}
+	if tag != "metadata" {
+		t.Fatalf("expected metadata to be forwarded, got %q", tag)
+	}
+}
+
+func TestGRPCRouteTranslatesGRPCWebText(t *testing.T) {
+	pm := newGRPCProxyManager(t, newGRPCBackend(t), AuthModePublic)
+	gateway := httptest.NewServer(pm)
+	defer gateway.Close()
+
+	message := grpcFrame(0, []byte("hello from the browser"))
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/test.Echo/Say",
+		strings.NewReader(base64.StdEncoding.EncodeToString(message)))
+	req.Header.Set("Content-Type", "application/grpc-web-text+proto")
+	req.Header.Set("X-Grpc-Web", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/grpc-web-text+proto" {
+		t.Fatalf("unexpected Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+	encoded, _ := io.ReadAll(resp.Body)
+	// 每次写入单独编码，按 4 字符一组解码
+	var body []byte
+	for i := 0; i+4 <= len(encoded); i += 4 {
+		chunk, err := base64.StdEncoding.DecodeString(string(encoded[i : i+4]))
+		if err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		body = append(body, chunk...)
+	}
+
+	if !bytes.HasPrefix(body, message) {
+		t.Fatalf("expected echoed message frame, got %q", body)
+	}
+	trailer := body[len(message):]
+	if len(trailer) < 5 || trailer[0] != grpcWebTrailerFlag {
+		t.Fatalf("expected trailer frame, got %q", trailer)
+	}
+	if size := binary.BigEndian.Uint32(trailer[1:5]); int(size) != len(trailer)-5 {
+		t.Fatalf("trailer frame length %d does not match %d", size, len(trailer)-5)
+	}
+	if !strings.Contains(string(trailer[5:]), "grpc-status: 0\r\n") {
+		t.Fatalf("expected grpc-status in trailer frame, got %q", trailer[5:])
+	}
+}
+
+func TestGRPCRouteMapsErrorsToGRPCStatus(t *testing.T) {
+	backend := newGRPCBackend(t)
+	cases := []struct {
+		name     string
+		authMode AuthMode
+		path     string
+		timeout  string
+		code     string
+	}{
+		{name: "unauthenticated", authMode: AuthModeRequired, path: "/test.Echo/Say", code: "16"},
+		{name: "deadline", authMode: AuthModePublic, path: "/test.Echo/Slow", timeout: "50m", code: "4"},
+		{name: "malformed timeout", authMode: AuthModePublic, path: "/test.Echo/Say", timeout: "soon", code: "3"},
+		{name: "unknown service", authMode: AuthModePublic, path: "/test.Other/Say", code: "12"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			pm := newGRPCProxyManager(t, backend, tc.authMode)
+			req := httptest.NewRequest(http.MethodPost, tc.path, bytes.NewReader(grpcFrame(0, nil)))
+			req.Header.Set("Content-Type", "application/grpc")
+			req.Header.Set("Te", "trailers")
+			if tc.timeout != "" {
+				req.Header.Set(headerGRPCTimeout, tc.timeout)
+			}
+			rec := httptest.NewRecorder()
+			pm.ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/grpc" {
+				t.Fatalf("expected gRPC response, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			if got := rec.Header().Get("Grpc-Status"); got != tc.code {
+				t.Fatalf("expected grpc-status %s, got %s (%s)", tc.code, got, rec.Header().Get("Grpc-Message"))
+			}
+		})
+	}
+}
+
+func TestGRPCRouteRejectsPlainHTTP(t *testing.T) {
+	pm := newGRPCProxyManager(t, newGRPCBackend(t), AuthModePublic)
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/test.Echo/Say", strings.NewReader("{}")))
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", rec.Code)
+	}
+}
+
+func TestGRPCTimeoutFormatting(t *testing.T) {
+	for value, expected := range map[string]time.Duration{"5S": 5 * time.Second, "100m": 100 * time.Millisecond, "2H": 2 * time.Hour} {
+		parsed, err := parseGRPCTimeout(value)
+		if err != nil || parsed != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", value, expected, parsed, err)
+		}
+		if formatted, _ := parseGRPCTimeout(formatGRPCTimeout(expected)); formatted != expected {
+			t.Errorf("%s: round trip gave %s", value, formatted)
+		}
+	}
+	for _, value := range []string{"", "5", "123456789S", "5s", "-1S"} {
+		if _, err := parseGRPCTimeout(value); err == nil {
+			t.Errorf("%q: expected error", value)
+		}
+	}
+	if got := encodeGRPCMessage("无效 100%"); got != "%E6%97%A0%E6%95%88 100%25" {
+		t.Errorf("unexpected encoded message %q", got)
+	}
+}
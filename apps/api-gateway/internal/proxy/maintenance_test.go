@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/ipfilter"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/maintenance"
+)
+
+func TestMaintenanceTakesRouteOfflineWithBypass(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	betterAuth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"session":{"id":"session-1"},"user":{"id":"operator-1"}}`))
+	}))
+	defer betterAuth.Close()
+	authenticator := gatewayauth.NewAuthenticator(gatewayauth.Config{
+		BetterAuthBaseURL:     betterAuth.URL,
+		BetterAuthSessionPath: "/get-session",
+		CacheTTL:              time.Minute,
+	})
+	defer authenticator.Stop()
+
+	pm := NewProxyManager(newTestDiscovery(t, "agent-service", backend.Listener.Addr(), nil), authenticator)
+	if err := pm.LoadRoutes([]RouteConfig{
+		{Path: "/api/runs", ServiceName: "agent-service"},
+		{Path: "/api/agent", ServiceName: "agent-service"},
+	}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+	sw, err := maintenance.NewSwitch([]string{"/api/runs", "/api/agent"}, []string{"operator-1"}, []string{"10.8.0.0/16"})
+	if err != nil {
+		t.Fatalf("NewSwitch: %v", err)
+	}
+	if err := sw.Set("/api/runs", maintenance.Mode{Enabled: true, Message: "数据库迁移中", RetryAfter: 120}); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	pm.SetMaintenance(sw)
+
+	serve := func(path, remoteAddr, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr, _, _ = strings.Cut(remoteAddr, ",")
+		// "代理地址,客户端地址" 表示经过前置代理转发
+		if _, client, ok := strings.Cut(remoteAddr, ","); ok {
+			req.Header.Set("X-Forwarded-For", client)
+		}
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		rec := httptest.NewRecorder()
+		pm.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/api/runs/run-1", "203.0.113.9:1234", "")
+	var problem apierror.Problem
+	_ = json.Unmarshal(rec.Body.Bytes(), &problem)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "120" ||
+		problem.Code != apierror.CodeMaintenance || problem.Detail != "数据库迁移中" {
+		t.Fatalf("expected maintenance response, got %d %q %+v", rec.Code, rec.Header().Get("Retry-After"), problem)
+	}
+	if rec := serve("/api/agent", "203.0.113.9:1234", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected chat to stay online, got %d", rec.Code)
+	}
+	// 未配置可信代理时，位于白名单网段内的 nginx 地址不能放行所有请求
+	if rec := serve("/api/runs/run-1", "10.8.0.9:1234,203.0.113.9", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected IP bypass to require trusted proxies, got %d", rec.Code)
+	}
+	resolver, _ := ipfilter.NewResolver([]string{"10.8.0.9"})
+	pm.SetIPFilter(resolver, nil)
+	if rec := serve("/api/runs/run-1", "10.8.0.9:1234,203.0.113.9", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected forwarded client outside the allow-list to see maintenance, got %d", rec.Code)
+	}
+	if rec := serve("/api/runs/run-1", "10.8.0.9:1234,10.8.1.2", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected allow-listed IP to bypass maintenance, got %d", rec.Code)
+	}
+	if rec := serve("/api/runs/run-1", "203.0.113.9:1234", "telos.session_token=ok"); rec.Code != http.StatusOK {
+		t.Fatalf("expected allow-listed user to bypass maintenance, got %d", rec.Code)
+	}
+}
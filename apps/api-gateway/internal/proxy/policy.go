@@ -0,0 +1,161 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+)
+
+// PolicyRule 路由上的一条授权规则，在认证之后、转发之前执行
+// 同一请求匹配到的所有规则都必须通过，否则返回 403
+type PolicyRule struct {
+	// Methods 规则适用的 HTTP 方法，为空表示全部方法
+	Methods []string `json:"methods"`
+	// Path 可选的路径模式，如 /api/threads/:threadId/*，":name" 匹配一段并作为路径参数，
+	// 末尾的 "*" 匹配剩余路径；为空表示匹配整个路由
+	Path string `json:"path"`
+	// Roles 满足其一即可
+	Roles []string `json:"roles"`
+	// Scopes 必须全部具备；仅约束带 scope 的凭证（API Key），会话认证视为拥有全部 scope
+	Scopes []string `json:"scopes"`
+	// Expr 基于身份声明和路径参数的表达式，如 "params.userId == user.id || 'admin' in user.roles"
+	Expr string `json:"expr"`
+
+	segments []string
+	expr     policyExpr
+}
+
+// WriteRolesPolicy 写操作（POST/PUT/PATCH/DELETE）仅限 roles 中的角色，读操作不限制
+func WriteRolesPolicy(roles []string) []PolicyRule {
+	return []PolicyRule{{Methods: []string{"POST", "PUT", "PATCH", "DELETE"}, Roles: roles}}
+}
+
+// compilePolicy 校验并预编译路由上的授权规则
+func compilePolicy(route *RouteConfig) error {
+	if len(route.Policy) > 0 && route.AuthMode != AuthModeRequired {
+		return fmt.Errorf("授权规则只能用于 required 路由")
+	}
+	for i := range route.Policy {
+		rule := &route.Policy[i]
+		params := map[string]bool{}
+		if rule.Path != "" {
+			rule.segments = splitPath(rule.Path)
+			for j, segment := range rule.segments {
+				if segment == "*" && j != len(rule.segments)-1 {
+					return fmt.Errorf("规则 %d: \"*\" 只能出现在路径末尾", i)
+				}
+				if strings.HasPrefix(segment, ":") {
+					params[segment[1:]] = true
+				}
+			}
+		}
+		if strings.TrimSpace(rule.Expr) != "" {
+			expr, err := compilePolicyExpr(rule.Expr, params)
+			if err != nil {
+				return fmt.Errorf("规则 %d: %w", i, err)
+			}
+			rule.expr = expr
+		}
+	}
+	return nil
+}
+
+// authorize 依次评估匹配的规则，返回拒绝原因；空字符串表示允许
+func authorize(route *RouteConfig, method string, path string, identity *gatewayauth.Identity) string {
+	if len(route.Policy) == 0 {
+		return ""
+	}
+	if identity == nil {
+		return "未认证"
+	}
+	for i := range route.Policy {
+		rule := &route.Policy[i]
+		if !rule.matchesMethod(method) {
+			continue
+		}
+		params, ok := rule.matchPath(path)
+		if !ok {
+			continue
+		}
+		if reason := rule.evaluate(method, path, params, identity); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+func (rule *PolicyRule) matchesMethod(method string) bool {
+	if len(rule.Methods) == 0 {
+		return true
+	}
+	for _, m := range rule.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *PolicyRule) matchPath(path string) (map[string]string, bool) {
+	params := map[string]string{}
+	if rule.segments == nil {
+		return params, true
+	}
+	parts := splitPath(path)
+	for i, segment := range rule.segments {
+		if segment == "*" {
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		if strings.HasPrefix(segment, ":") {
+			params[segment[1:]] = parts[i]
+			continue
+		}
+		if segment != parts[i] {
+			return nil, false
+		}
+	}
+	return params, len(parts) == len(rule.segments)
+}
+
+func (rule *PolicyRule) evaluate(method string, path string, params map[string]string, identity *gatewayauth.Identity) string {
+	if len(rule.Roles) > 0 && !containsAny(identity.Roles, rule.Roles) {
+		return fmt.Sprintf("需要角色 %s", strings.Join(rule.Roles, "|"))
+	}
+	if identity.Method != gatewayauth.MethodSession {
+		for _, scope := range rule.Scopes {
+			if !containsAny(identity.Scopes, []string{scope}) {
+				return fmt.Sprintf("缺少 scope %s", scope)
+			}
+		}
+	}
+	if rule.expr != nil {
+		env := policyEnv{method: method, path: path, params: params, identity: identity}
+		if !truthy(rule.expr.eval(env)) {
+			return fmt.Sprintf("不满足条件 %s", rule.Expr)
+		}
+	}
+	return ""
+}
+
+func splitPath(path string) []string {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "" {
+		return []string{}
+	}
+	return strings.Split(trimmed, "/")
+}
+
+func containsAny(values []string, wanted []string) bool {
+	for _, value := range values {
+		for _, w := range wanted {
+			if value == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+)
+
+// 授权表达式语法：
+//
+//	expr    = or
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = primary [ ("==" | "!=" | "in") primary ]
+//	primary = "(" expr ")" | string | list | "true" | "false" | ident
+//	list    = "[" [ string { "," string } ] "]"
+//
+// 标识符：user.id、user.email、user.roles、user.scopes、user.org、user.method、
+// request.method、request.path、params.<路径参数>
+// 字符串用单引号或双引号；非布尔值按非空判断真假
+
+type policyEnv struct {
+	method   string
+	path     string
+	params   map[string]string
+	identity *gatewayauth.Identity
+}
+
+type policyExpr interface {
+	eval(env policyEnv) any
+}
+
+type (
+	literalExpr struct{ value any }
+	identExpr   struct{ name string }
+	notExpr     struct{ operand policyExpr }
+	binaryExpr  struct {
+		op          string
+		left, right policyExpr
+	}
+)
+
+func (e literalExpr) eval(policyEnv) any { return e.value }
+
+func (e notExpr) eval(env policyEnv) any { return !truthy(e.operand.eval(env)) }
+
+func (e identExpr) eval(env policyEnv) any {
+	if name, ok := strings.CutPrefix(e.name, "params."); ok {
+		return env.params[name]
+	}
+	identity := env.identity
+	switch e.name {
+	case "user.id":
+		return identity.UserID
+	case "user.email":
+		return identity.Email
+	case "user.roles":
+		return identity.Roles
+	case "user.scopes":
+		return identity.Scopes
+	case "user.org":
+		return identity.OrganizationID
+	case "user.method":
+		return string(identity.Method)
+	case "request.method":
+		return env.method
+	case "request.path":
+		return env.path
+	}
+	return nil
+}
+
+func (e binaryExpr) eval(env policyEnv) any {
+	switch e.op {
+	case "||":
+		return truthy(e.left.eval(env)) || truthy(e.right.eval(env))
+	case "&&":
+		return truthy(e.left.eval(env)) && truthy(e.right.eval(env))
+	}
+	left, right := e.left.eval(env), e.right.eval(env)
+	switch e.op {
+	case "==":
+		return valuesEqual(left, right)
+	case "!=":
+		return !valuesEqual(left, right)
+	case "in":
+		s, ok := left.(string)
+		if !ok {
+			return false
+		}
+		if list, ok := right.([]string); ok {
+			return containsAny(list, []string{s})
+		}
+		return valuesEqual(left, right)
+	}
+	return false
+}
+
+func valuesEqual(left any, right any) bool {
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		return ok && l == r
+	case bool:
+		r, ok := right.(bool)
+		return ok && l == r
+	}
+	return false
+}
+
+func truthy(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []string:
+		return len(v) > 0
+	}
+	return false
+}
+
+var policyIdents = map[string]bool{
+	"user.id": true, "user.email": true, "user.roles": true, "user.scopes": true,
+	"user.org": true, "user.method": true, "request.method": true, "request.path": true,
+}
+
+// compilePolicyExpr 解析表达式，params 为规则路径中声明的参数，引用未声明的参数视为配置错误
+func compilePolicyExpr(source string, params map[string]bool) (policyExpr, error) {
+	tokens, err := tokenizePolicy(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &policyParser{tokens: tokens, params: params}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("表达式 %q 在 %q 处有多余内容", source, p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+type policyToken struct {
+	kind string // op、string、ident
+	text string
+}
+
+var policyOperators = map[string]bool{"==": true, "!=": true, "&&": true, "||": true}
+
+func tokenizePolicy(source string) ([]policyToken, error) {
+	var tokens []policyToken
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("表达式 %q 中的字符串未闭合", source)
+			}
+			tokens = append(tokens, policyToken{kind: "string", text: string(runes[i+1 : end])})
+			i = end + 1
+		case strings.ContainsRune("()[],!", r) && !(r == '!' && i+1 < len(runes) && runes[i+1] == '='):
+			tokens = append(tokens, policyToken{kind: "op", text: string(r)})
+			i++
+		case i+1 < len(runes) && policyOperators[string(runes[i:i+2])]:
+			tokens = append(tokens, policyToken{kind: "op", text: string(runes[i : i+2])})
+			i += 2
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_' || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, policyToken{kind: "ident", text: string(runes[i:end])})
+			i = end
+		default:
+			return nil, fmt.Errorf("表达式 %q 包含无法识别的字符 %q", source, r)
+		}
+	}
+	return tokens, nil
+}
+
+type policyParser struct {
+	tokens []policyToken
+	pos    int
+	params map[string]bool
+}
+
+func (p *policyParser) peek(text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind != "string" && p.tokens[p.pos].text == text
+}
+
+func (p *policyParser) expect(text string) error {
+	if !p.peek(text) {
+		return fmt.Errorf("表达式缺少 %q", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *policyParser) parseOr() (policyExpr, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.peek("||") {
+		p.pos++
+		var right policyExpr
+		if right, err = p.parseAnd(); err == nil {
+			left = binaryExpr{op: "||", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *policyParser) parseAnd() (policyExpr, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.peek("&&") {
+		p.pos++
+		var right policyExpr
+		if right, err = p.parseUnary(); err == nil {
+			left = binaryExpr{op: "&&", left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *policyParser) parseUnary() (policyExpr, error) {
+	if p.peek("!") {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *policyParser) parseCompare() (policyExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "in"} {
+		if p.peek(op) {
+			p.pos++
+			right, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return binaryExpr{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *policyParser) parsePrimary() (policyExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("表达式意外结束")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+	switch {
+	case token.kind == "string":
+		return literalExpr{value: token.text}, nil
+	case token.kind == "op" && token.text == "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	case token.kind == "op" && token.text == "[":
+		return p.parseList()
+	case token.kind == "ident" && (token.text == "true" || token.text == "false"):
+		return literalExpr{value: token.text == "true"}, nil
+	case token.kind == "ident":
+		if name, ok := strings.CutPrefix(token.text, "params."); ok {
+			if !p.params[name] {
+				return nil, fmt.Errorf("路径参数 %q 未在规则路径中声明", name)
+			}
+		} else if !policyIdents[token.text] {
+			return nil, fmt.Errorf("未知标识符 %q", token.text)
+		}
+		return identExpr{name: token.text}, nil
+	}
+	return nil, fmt.Errorf("表达式在 %q 处无法解析", token.text)
+}
+
+func (p *policyParser) parseList() (policyExpr, error) {
+	items := []string{}
+	for !p.peek("]") {
+		if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != "string" {
+			return nil, fmt.Errorf("列表只能包含字符串")
+		}
+		items = append(items, p.tokens[p.pos].text)
+		p.pos++
+		if !p.peek(",") {
+			break
+		}
+		p.pos++
+	}
+	return literalExpr{value: items}, p.expect("]")
+}
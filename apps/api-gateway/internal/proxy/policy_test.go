@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/config"
+)
+
+func TestAuthorizeEnforcesRolesPerMethod(t *testing.T) {
+	route := newValidatedRoute(t, RouteConfig{
+		Path:     "/api/mcp-servers",
+		AuthMode: AuthModeRequired,
+		Policy: []PolicyRule{
+			{Methods: []string{"POST", "DELETE"}, Roles: []string{"admin"}},
+		},
+	})
+	member := &gatewayauth.Identity{UserID: "user-1", Method: gatewayauth.MethodSession, Roles: []string{"member"}}
+	admin := &gatewayauth.Identity{UserID: "user-2", Method: gatewayauth.MethodSession, Roles: []string{"admin"}}
+
+	if reason := authorize(route, http.MethodGet, "/api/mcp-servers", member); reason != "" {
+		t.Fatalf("expected reads to be allowed, got %q", reason)
+	}
+	if reason := authorize(route, http.MethodPost, "/api/mcp-servers", member); reason == "" {
+		t.Fatal("expected member write to be denied")
+	}
+	if reason := authorize(route, http.MethodDelete, "/api/mcp-servers/1", admin); reason != "" {
+		t.Fatalf("expected admin write to be allowed, got %q", reason)
+	}
+}
+
+func TestAuthorizeChecksAPIKeyScopes(t *testing.T) {
+	route := newValidatedRoute(t, RouteConfig{
+		Path:     "/api/agents",
+		AuthMode: AuthModeRequired,
+		Policy:   []PolicyRule{{Methods: []string{"GET"}, Scopes: []string{"agents:read"}}},
+	})
+	key := &gatewayauth.Identity{UserID: "user-1", Method: gatewayauth.MethodAPIKey, Scopes: []string{"runs:read"}}
+	session := &gatewayauth.Identity{UserID: "user-1", Method: gatewayauth.MethodSession}
+
+	if reason := authorize(route, http.MethodGet, "/api/agents", key); reason == "" {
+		t.Fatal("expected API key without scope to be denied")
+	}
+	key.Scopes = append(key.Scopes, "agents:read")
+	if reason := authorize(route, http.MethodGet, "/api/agents", key); reason != "" {
+		t.Fatalf("expected scoped API key to be allowed, got %q", reason)
+	}
+	if reason := authorize(route, http.MethodGet, "/api/agents", session); reason != "" {
+		t.Fatalf("expected session to be unaffected by scopes, got %q", reason)
+	}
+}
+
+func TestAuthorizeEvaluatesOwnershipExpression(t *testing.T) {
+	route := newValidatedRoute(t, RouteConfig{
+		Path:     "/api/users",
+		AuthMode: AuthModeRequired,
+		Policy: []PolicyRule{{
+			Path: "/api/users/:userId/*",
+			Expr: `params.userId == user.id || ('admin' in user.roles && user.org in ["org-1", "org-2"])`,
+		}},
+	})
+	owner := &gatewayauth.Identity{UserID: "user-1"}
+	other := &gatewayauth.Identity{UserID: "user-2"}
+	admin := &gatewayauth.Identity{UserID: "user-3", Roles: []string{"admin"}, OrganizationID: "org-2"}
+
+	cases := []struct {
+		path     string
+		identity *gatewayauth.Identity
+		allowed  bool
+	}{
+		{"/api/users/user-1/threads", owner, true},
+		{"/api/users/user-1/threads", other, false},
+		{"/api/users/user-1/threads", admin, true},
+		{"/api/users", other, true}, // 规则路径不匹配
+	}
+	for _, tc := range cases {
+		if allowed := authorize(route, http.MethodGet, tc.path, tc.identity) == ""; allowed != tc.allowed {
+			t.Fatalf("%s as %s: expected allowed=%v", tc.path, tc.identity.UserID, tc.allowed)
+		}
+	}
+}
+
+func TestLoadRoutesRejectsInvalidPolicy(t *testing.T) {
+	invalid := []RouteConfig{
+		{Path: "/api/a", AuthMode: AuthModePublic, Policy: []PolicyRule{{Roles: []string{"admin"}}}},
+		{Path: "/api/a", AuthMode: AuthModeRequired, Policy: []PolicyRule{{Expr: "params.id == user.id"}}},
+		{Path: "/api/a", AuthMode: AuthModeRequired, Policy: []PolicyRule{{Expr: "user.name == 'x'"}}},
+		{Path: "/api/a", AuthMode: AuthModeRequired, Policy: []PolicyRule{{Expr: "user.id == "}}},
+		{Path: "/api/a", AuthMode: AuthModeRequired, Policy: []PolicyRule{{Path: "/api/*/x"}}},
+	}
+	for i, route := range invalid {
+		if err := NewProxyManager(nil, nil).LoadRoutes([]RouteConfig{route}); err == nil {
+			t.Fatalf("case %d: expected invalid policy to be rejected", i)
+		}
+	}
+}
+
+// Better Auth get-session 的真实响应：默认没有 role 字段，启用 admin 插件后 user.role 为逗号分隔的角色
+var betterAuthSessions = map[string]string{
+	"stock": `{"session":{"id":"s1","userId":"user-1","token":"t1","expiresAt":"2099-01-01T00:00:00.000Z","ipAddress":"","userAgent":""},` +
+		`"user":{"id":"user-1","name":"Ada","email":"ada@example.com","emailVerified":true,"image":null,"createdAt":"2026-01-01T00:00:00.000Z","updatedAt":"2026-01-01T00:00:00.000Z"}}`,
+	"member": `{"session":{"id":"s2","userId":"user-2","token":"t2","expiresAt":"2099-01-01T00:00:00.000Z","impersonatedBy":null},` +
+		`"user":{"id":"user-2","name":"Bob","email":"bob@example.com","emailVerified":true,"role":"user","banned":false}}`,
+	"admin": `{"session":{"id":"s3","userId":"user-3","token":"t3","expiresAt":"2099-01-01T00:00:00.000Z","impersonatedBy":null},` +
+		`"user":{"id":"user-3","name":"Eve","email":"eve@example.com","emailVerified":true,"role":"user,admin","banned":false}}`,
+}
+
+func TestPolicyRolesFromBetterAuthSession(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	betterAuth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, _ := r.Cookie("telos.session_token")
+		w.Write([]byte(betterAuthSessions[cookie.Value]))
+	}))
+	defer betterAuth.Close()
+	authenticator := gatewayauth.NewAuthenticator(gatewayauth.Config{
+		BetterAuthBaseURL:     betterAuth.URL,
+		BetterAuthSessionPath: "/get-session",
+		GatewayInternalSecret: "test-secret",
+		CacheTTL:              time.Minute,
+	})
+	defer authenticator.Stop()
+	discovery := newTestDiscovery(t, "agent-service", backend.Listener.Addr(), nil)
+
+	serve := func(policy []PolicyRule, method, session string) int {
+		pm := NewProxyManager(discovery, authenticator)
+		if err := pm.LoadRoutes([]RouteConfig{{Path: "/api/mcp-servers", ServiceName: "agent-service", AuthMode: AuthModeRequired, Policy: policy}}); err != nil {
+			t.Fatalf("LoadRoutes: %v", err)
+		}
+		req := httptest.NewRequest(method, "/api/mcp-servers", nil)
+		req.Header.Set("Cookie", "telos.session_token="+session)
+		rec := httptest.NewRecorder()
+		pm.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// 未设置任何环境变量时使用默认的 admin 角色
+	t.Setenv("MCP_ADMIN_ROLES", "")
+	adminOnly := WriteRolesPolicy(config.LoadConfig().MCPAdminRoles)
+	for _, tc := range []struct {
+		method, session string
+		want            int
+	}{
+		{http.MethodGet, "stock", http.StatusOK},
+		{http.MethodPost, "stock", http.StatusForbidden},
+		{http.MethodPost, "member", http.StatusForbidden},
+		{http.MethodPost, "admin", http.StatusOK},
+	} {
+		if code := serve(adminOnly, tc.method, tc.session); code != tc.want {
+			t.Fatalf("%s as %s: expected %d, got %d", tc.method, tc.session, tc.want, code)
+		}
+	}
+}
@@ -1,19 +1,27 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
-	"time"
+	"sync"
 
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
 	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/capture"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/ipfilter"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/maintenance"
+	apimiddleware "github.com/indulgeback/telos/apps/api-gateway/internal/middleware"
 	"github.com/indulgeback/telos/apps/api-gateway/internal/service"
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
 	"github.com/indulgeback/telos/pkg/tlog"
 	"github.com/labstack/echo/v4"
 )
@@ -32,14 +40,54 @@ type RouteConfig struct {
 	StripPrefix bool     `json:"stripPrefix"` // 是否去掉路径前缀
 	Timeout     int      `json:"timeout"`     // 超时时间（秒）
 	AuthMode    AuthMode `json:"authMode"`    // public 或 required
+	// AuthMethods required 模式下接受的认证方式，为空时仅接受会话 Cookie
+	AuthMethods []gatewayauth.Method `json:"authMethods"`
+	// MaxConcurrency 每个用户在该路由上的最大并发请求数，0 表示只受全局的每用户上限约束；路由上的请求同时计入全局上限
+	MaxConcurrency int `json:"maxConcurrency"`
+	// ForwardAuthCookies 是否把会话 Cookie 转发给后端，默认移除（后端通过签名身份头识别用户）
+	ForwardAuthCookies bool `json:"forwardAuthCookies"`
+	// SkipCSRF 关闭该路由的 CSRF 校验，用于只供 API Key / Bearer 客户端调用、不依赖浏览器 Cookie 的路由
+	SkipCSRF bool `json:"skipCsrf"`
+	// CORS 路由级跨域策略，非空时整体替换全局策略
+	CORS *apimiddleware.CORSPolicy `json:"cors"`
+	// IPFilter 路由级 IP 访问规则，在全局规则之后检查，不满足时返回 403
+	IPFilter *ipfilter.Rules `json:"ipFilter"`
+	// Mode 代理方式，为空时按 HTTP 转发；grpc 路由只接受 gRPC/gRPC-Web 请求，错误以 gRPC 状态返回
+	Mode RouteMode `json:"mode"`
+
+	// 请求体校验，在请求转发到后端之前执行
+	MaxBodyBytes        int64           `json:"maxBodyBytes"`        // 请求体最大字节数，0 表示不限制
+	AllowedContentTypes []string        `json:"allowedContentTypes"` // 允许的请求体类型，如 application/json
+	RequestSchema       json.RawMessage `json:"requestSchema"`       // 可选的 JSON Schema，仅对 JSON 请求体生效
+
+	// Policy 授权规则，仅用于 required 路由，不满足时返回 403
+	Policy []PolicyRule `json:"policy"`
+
+	// UpstreamErrors 后端错误响应的拦截与替换，为 nil 时原样透传后端的错误响应
+	UpstreamErrors *UpstreamErrorConfig `json:"upstreamErrors"`
+
+	schema   *jsonSchema
+	ipFilter *ipfilter.Filter
 }
 
 // ProxyManager 代理管理器
 type ProxyManager struct {
-	routes        []RouteConfig
-	discovery     service.ServiceDiscovery
-	proxies       map[string]*httputil.ReverseProxy
-	authenticator *gatewayauth.Authenticator
+	routes           []RouteConfig
+	discovery        service.ServiceDiscovery
+	proxies          map[string]*httputil.ReverseProxy
+	authenticator    *gatewayauth.Authenticator
+	limiter          *apimiddleware.ConcurrencyLimiter
+	csrf             *csrfGuard
+	clientIPs        *ipfilter.Resolver
+	geo              ipfilter.GeoLookup
+	maintenance      *maintenance.Switch
+	digestLimit      int64
+	digestSpillLimit int64
+
+	upstreamTLS             map[string]*tls.Config
+	upstreamTransportConfig UpstreamTransportConfig
+	transports              map[string]http.RoundTripper
+	transportsMu            sync.Mutex
 }
 
 // NewProxyManager 创建代理管理器
@@ -48,9 +96,26 @@ func NewProxyManager(discovery service.ServiceDiscovery, authenticator *gatewaya
 		discovery:     discovery,
 		proxies:       make(map[string]*httputil.ReverseProxy),
 		authenticator: authenticator,
+		transports:    make(map[string]http.RoundTripper),
 	}
 }
 
+// SetConcurrencyLimiter 设置按用户的并发限制器，为 nil 时不限制
+func (pm *ProxyManager) SetConcurrencyLimiter(limiter *apimiddleware.ConcurrencyLimiter) {
+	pm.limiter = limiter
+}
+
+// SetIPFilter 设置路由级 IP 规则使用的客户端 IP 解析器和 GeoIP 数据库，需在 LoadRoutes 之前调用
+func (pm *ProxyManager) SetIPFilter(resolver *ipfilter.Resolver, geo ipfilter.GeoLookup) {
+	pm.clientIPs = resolver
+	pm.geo = geo
+}
+
+// SetMaintenance 设置维护开关，为 nil 时不检查维护状态
+func (pm *ProxyManager) SetMaintenance(sw *maintenance.Switch) {
+	pm.maintenance = sw
+}
+
 // EchoHandler 返回一个 Echo handler，使用原始 ResponseWriter 支持流式响应
 func (pm *ProxyManager) EchoHandler(c echo.Context) error {
 	// WebSocket 握手必须走 ReverseProxy；StreamProxy 使用 http.Client
@@ -59,6 +124,11 @@ func (pm *ProxyManager) EchoHandler(c echo.Context) error {
 		pm.ServeHTTP(c.Response().Writer, c.Request())
 		return nil
 	}
+	// gRPC 依赖 HTTP trailer，同样只能走 ReverseProxy
+	if detectGRPC(c.Request()) != grpcNone {
+		pm.ServeHTTP(c.Response().Writer, c.Request())
+		return nil
+	}
 	// 判断是否为流式请求路径
 	if isStreamPath(c.Request().URL.Path) {
 		return pm.StreamProxy(c)
@@ -93,21 +163,54 @@ func (pm *ProxyManager) StreamProxy(c echo.Context) error {
 	route := pm.findRoute(c.Request().URL.Path)
 	if route == nil {
 		tlog.Warn("未找到匹配路由", "path", c.Request().URL.Path)
-		return echo.NewHTTPError(http.StatusNotFound, "未找到匹配的服务路由")
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeRouteNotFound)
+		return nil
+	}
+
+	if !pm.checkRouteIP(c.Response().Writer, c.Request(), route) {
+		return nil
+	}
+
+	if !pm.checkMaintenance(c.Response().Writer, c.Request(), route) {
+		return nil
 	}
 
 	identity, err := pm.authenticateRequest(c.Request(), route)
 	if err != nil {
-		writeUnauthorized(c.Response().Writer)
+		writeAuthError(c.Response().Writer, c.Request(), err)
+		return nil
+	}
+
+	if !pm.authorizeRequest(c.Request(), c.Request().URL.Path, route, identity) {
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeForbidden)
 		return nil
 	}
 
+	if !pm.checkCSRF(c.Response().Writer, c.Request(), route, identity) {
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeCSRFRejected)
+		return nil
+	}
+
+	if rejection := enforceRequestBody(c.Request(), route); rejection != nil {
+		tlog.Warn("[API Gateway] 请求体校验失败", "path", c.Request().URL.Path, "code", rejection.code, "reason", rejection.Error())
+		apierror.Write(c.Response().Writer, c.Request(), rejection.code, rejection.args...)
+		return nil
+	}
+
+	release, err := pm.acquireConcurrency(c.Request(), route, identity)
+	if err != nil {
+		writeTooManyConcurrent(c.Response().Writer, c.Request())
+		return nil
+	}
+	defer release()
+
 	// 2. 服务发现
 	hashKey := extractHashKey(c.Request())
 	target, err := pm.discovery.Discover(route.ServiceName, hashKey)
 	if err != nil {
 		tlog.Error("服务发现失败", "service", route.ServiceName, "error", err)
-		return echo.NewHTTPError(http.StatusServiceUnavailable, fmt.Sprintf("服务 %s 不可用", route.ServiceName))
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeServiceUnavailable)
+		return nil
 	}
 
 	// 3. 构建目标 URL，处理 StripPrefix
@@ -122,6 +225,11 @@ func (pm *ProxyManager) StreamProxy(c echo.Context) error {
 		if !strings.HasPrefix(requestPath, "/") {
 			requestPath = "/" + requestPath
 		}
+		// 去掉前缀后可能拼出新的 ".." 段，如 /api/tools../x
+		if apimiddleware.HasPathTraversal(&url.URL{Path: requestPath}) {
+			apimiddleware.RejectRequest(c.Response().Writer, c.Request(), apimiddleware.RejectPathTraversal)
+			return nil
+		}
 	}
 	targetURL := target + requestPath
 	if c.Request().URL.RawQuery != "" {
@@ -137,10 +245,13 @@ func (pm *ProxyManager) StreamProxy(c echo.Context) error {
 	)
 
 	// 4. 创建转发请求
-	req, err := http.NewRequestWithContext(c.Request().Context(), c.Request().Method, targetURL, c.Request().Body)
+	req, err := http.NewRequestWithContext(withOriginalPath(c.Request()).Context(), c.Request().Method, targetURL, c.Request().Body)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "创建转发请求失败")
+		tlog.Error("[API Gateway] 创建转发请求失败", "target", targetURL, "error", err)
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeInternal)
+		return nil
 	}
+	req.ContentLength = c.Request().ContentLength
 
 	// 复制请求头
 	for name, values := range c.Request().Header {
@@ -149,30 +260,39 @@ func (pm *ProxyManager) StreamProxy(c echo.Context) error {
 		}
 	}
 	sanitizeIdentityHeaders(req.Header)
+	pm.stripAuthCookies(req.Header, route)
 	// 更新 Host 头
 	req.Host = ""
 	req.Header.Set("X-Forwarded-Host", c.Request().Host)
 	req.Header.Set("X-Forwarded-Proto", getScheme(c.Request()))
+	propagateRequestID(req.Header, c.Response().Header())
 	if err := pm.injectIdentityHeaders(req, route, identity, requestPath); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "注入身份信息失败")
+		tlog.Error("[API Gateway] 注入身份信息失败", "service", route.ServiceName, "error", err)
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeInternal)
+		return nil
 	}
 
 	// 5. 发起请求
 	client := &http.Client{
-		Timeout: 0, // 流式响应不设置超时
+		Transport: pm.upstreamTransport(route),
+		Timeout:   0, // 流式响应不设置超时
 	}
 	resp, err := client.Do(req)
 	if err != nil {
 		tlog.Error("[API Gateway] 流式代理请求失败", "error", err)
 		pm.discovery.InvalidateCache(route.ServiceName)
-		return echo.NewHTTPError(http.StatusBadGateway, "后端服务请求失败")
+		apierror.Write(c.Response().Writer, c.Request(), apierror.CodeUpstreamError)
+		return nil
 	}
 	defer resp.Body.Close()
+	interceptUpstreamError(resp, route)
 
 	// 6. 复制后端响应头到前端（包括关键的 AI SDK 协议头）
+	// Content-Encoding 必须保留：请求头原样转发了客户端的 Accept-Encoding，
+	// http.Client 不会自动解压，响应体仍是后端压缩后的内容；压缩中间件会跳过这类响应
 	for name, values := range resp.Header {
-		// 跳过 Content-Encoding 和 Transfer-Encoding，让 Go 自动处理
-		if strings.EqualFold(name, "Content-Encoding") || strings.EqualFold(name, "Transfer-Encoding") {
+		// 跳过 Transfer-Encoding，让 Go 自动处理
+		if strings.EqualFold(name, "Transfer-Encoding") {
 			continue
 		}
 		for _, value := range values {
@@ -195,7 +315,9 @@ func (pm *ProxyManager) StreamProxy(c echo.Context) error {
 	written := int64(0)
 	flusher, ok := c.Response().Writer.(http.Flusher)
 	if !ok {
-		return echo.NewHTTPError(http.StatusInternalServerError, "不支持流式响应")
+		// 状态码已经写出，只能记录日志并结束响应
+		tlog.Error("[API Gateway] ResponseWriter 不支持流式响应", "path", c.Request().URL.Path)
+		return nil
 	}
 
 	for {
@@ -221,30 +343,116 @@ func (pm *ProxyManager) StreamProxy(c echo.Context) error {
 	return nil
 }
 
-// LoadRoutes 加载路由配置
-func (pm *ProxyManager) LoadRoutes(routes []RouteConfig) {
+// LoadRoutes 加载路由配置，并预编译路由上的请求体 Schema 与授权规则
+func (pm *ProxyManager) LoadRoutes(routes []RouteConfig) error {
+	for i := range routes {
+		switch routes[i].Mode {
+		case "", RouteModeHTTP, RouteModeGRPC:
+		default:
+			return fmt.Errorf("路由 %s 的代理方式 %q 无效", routes[i].Path, routes[i].Mode)
+		}
+		if err := compilePolicy(&routes[i]); err != nil {
+			return fmt.Errorf("路由 %s 的授权规则无效: %w", routes[i].Path, err)
+		}
+		if routes[i].IPFilter != nil {
+			filter, err := ipfilter.Compile(*routes[i].IPFilter)
+			if err != nil {
+				return fmt.Errorf("路由 %s 的 IP 规则无效: %w", routes[i].Path, err)
+			}
+			if filter.NeedsGeo() && pm.geo == nil {
+				return fmt.Errorf("路由 %s 的 IP 规则包含国家/ASN 条件，但未配置 GeoIP 数据库", routes[i].Path)
+			}
+			routes[i].ipFilter = filter
+		}
+		if routes[i].CORS != nil {
+			if err := routes[i].CORS.Compile(); err != nil {
+				return fmt.Errorf("路由 %s 的跨域策略无效: %w", routes[i].Path, err)
+			}
+		}
+		if routes[i].UpstreamErrors != nil {
+			if err := routes[i].UpstreamErrors.compile(); err != nil {
+				return fmt.Errorf("路由 %s 的上游错误配置无效: %w", routes[i].Path, err)
+			}
+		}
+		if len(routes[i].RequestSchema) == 0 {
+			continue
+		}
+		schema, err := compileJSONSchema(routes[i].RequestSchema)
+		if err != nil {
+			return fmt.Errorf("路由 %s 的请求体 Schema 无效: %w", routes[i].Path, err)
+		}
+		routes[i].schema = schema
+	}
 	pm.routes = routes
 	tlog.Info("路由配置加载完成", "count", len(routes))
+	return nil
+}
+
+// CORSPolicy 返回路径所属路由的跨域策略，供 CORS 中间件使用；未配置时返回 nil
+func (pm *ProxyManager) CORSPolicy(path string) *apimiddleware.CORSPolicy {
+	if route := pm.findRoute(path); route != nil {
+		return route.CORS
+	}
+	return nil
 }
 
 // ServeHTTP 实现 http.Handler 接口
 func (pm *ProxyManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// gRPC 客户端只认 grpc-status，网关和后端的 HTTP 错误都要转换
+	protocol := detectGRPC(r)
+	if protocol != grpcNone {
+		grpcWriter := newGRPCResponseWriter(w, protocol)
+		defer grpcWriter.finish()
+		w = grpcWriter
+	}
+
 	// 查找匹配的路由
 	route := pm.findRoute(r.URL.Path)
 	if route == nil {
 		tlog.Warn("未找到匹配路由", "path", r.URL.Path, "method", r.Method)
-		writeErrorResponse(w, "未找到匹配的服务路由", http.StatusNotFound)
+		apierror.Write(w, r, apierror.CodeRouteNotFound)
+		return
+	}
+
+	if !pm.checkRouteIP(w, r, route) {
+		return
+	}
+
+	if !pm.checkMaintenance(w, r, route) {
+		return
+	}
+
+	if route.Mode == RouteModeGRPC {
+		if protocol == grpcNone {
+			apierror.Write(w, r, apierror.CodeGRPCRequired)
+			return
+		}
+		if protocol != grpcNative {
+			translateGRPCWebRequest(r, protocol)
+		}
+		deadlineRequest, cancel, err := withGRPCDeadline(r, route)
+		if err != nil {
+			tlog.Warn("[API Gateway] grpc-timeout 无效", "path", r.URL.Path, "error", err)
+			apierror.Write(w, r, apierror.CodeInvalidGRPCTimeout)
+			return
+		}
+		defer cancel()
+		r = deadlineRequest
+	} else if protocol != grpcNone {
+		apierror.Write(w, r, apierror.CodeGRPCNotSupported)
 		return
 	}
 
 	tlog.Debug("路由匹配成功", "path", r.URL.Path, "service", route.ServiceName, "strip_prefix", route.StripPrefix)
+	// 授权规则按客户端请求的原始路径匹配
+	requestPath := r.URL.Path
 
 	// 发现服务实例
 	hashKey := extractHashKey(r)
 	target, err := pm.discovery.Discover(route.ServiceName, hashKey)
 	if err != nil {
 		tlog.Error("服务发现失败", "service", route.ServiceName, "error", err)
-		writeErrorResponse(w, fmt.Sprintf("服务 %s 不可用: %v", route.ServiceName, err), http.StatusServiceUnavailable)
+		apierror.Write(w, r, apierror.CodeServiceUnavailable)
 		return
 	}
 
@@ -253,31 +461,70 @@ func (pm *ProxyManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// 获取或创建代理
 	proxy, err := pm.getProxy(target, route)
 	if err != nil {
-		writeErrorResponse(w, fmt.Sprintf("创建代理失败: %v", err), http.StatusInternalServerError)
+		tlog.Error("创建代理失败", "service", route.ServiceName, "target", target, "error", err)
+		apierror.Write(w, r, apierror.CodeInternal)
 		return
 	}
 
 	// 处理路径前缀
 	if route.StripPrefix {
+		r = withOriginalPath(r)
 		r.URL.Path = strings.TrimPrefix(r.URL.Path, route.Path)
 		if !strings.HasPrefix(r.URL.Path, "/") {
 			r.URL.Path = "/" + r.URL.Path
 		}
+		// 去掉前缀后可能拼出新的 ".." 段，如 /api/tools../x
+		if apimiddleware.HasPathTraversal(&url.URL{Path: r.URL.Path}) {
+			apimiddleware.RejectRequest(w, r, apimiddleware.RejectPathTraversal)
+			return
+		}
 	}
 
 	identity, err := pm.authenticateRequest(r, route)
 	if err != nil {
-		writeUnauthorized(w)
+		writeAuthError(w, r, err)
+		return
+	}
+
+	if !pm.authorizeRequest(r, requestPath, route, identity) {
+		apierror.Write(w, r, apierror.CodeForbidden)
 		return
 	}
 
+	if !pm.checkCSRF(w, r, route, identity) {
+		apierror.Write(w, r, apierror.CodeCSRFRejected)
+		return
+	}
+
+	if rejection := enforceRequestBody(r, route); rejection != nil {
+		tlog.Warn("[API Gateway] 请求体校验失败", "path", r.URL.Path, "code", rejection.code, "reason", rejection.Error())
+		apierror.Write(w, r, rejection.code, rejection.args...)
+		return
+	}
+
+	release, err := pm.acquireConcurrency(r, route, identity)
+	if err != nil {
+		writeTooManyConcurrent(w, r)
+		return
+	}
+	defer release()
+
 	sanitizeIdentityHeaders(r.Header)
+	pm.stripAuthCookies(r.Header, route)
 
 	// 设置请求头
 	r.Header.Set("X-Forwarded-Host", r.Host)
 	r.Header.Set("X-Forwarded-Proto", getScheme(r))
+	propagateRequestID(r.Header, w.Header())
 	if err := pm.injectIdentityHeaders(r, route, identity, r.URL.Path); err != nil {
-		writeErrorResponse(w, "注入身份信息失败", http.StatusInternalServerError)
+		tlog.Error("[API Gateway] 注入身份信息失败", "service", route.ServiceName, "error", err)
+		apierror.Write(w, r, apierror.CodeInternal)
+		return
+	}
+
+	// 认证等环节已消耗部分时间，后端按剩余时间设置截止时间
+	if route.Mode == RouteModeGRPC && !setGRPCTimeout(r) {
+		apierror.Write(w, r, apierror.CodeDeadlineExceeded)
 		return
 	}
 
@@ -306,7 +553,7 @@ func (pm *ProxyManager) authenticateRequest(r *http.Request, route *RouteConfig)
 	if pm.authenticator == nil {
 		return nil, gatewayauth.ErrUnauthorized
 	}
-	identity, err := pm.authenticator.Authenticate(r.Context(), r.Header.Get("Cookie"))
+	identity, err := pm.authenticator.AuthenticateRequest(r.Context(), r, route.AuthMethods)
 	if err != nil {
 		if errors.Is(err, gatewayauth.ErrUnauthorized) {
 			tlog.Warn("[API Gateway] 认证失败", "path", r.URL.Path)
@@ -315,30 +562,181 @@ func (pm *ProxyManager) authenticateRequest(r *http.Request, route *RouteConfig)
 		tlog.Error("[API Gateway] 认证服务异常", "path", r.URL.Path, "error", err)
 		return nil, err
 	}
+	// 流量捕获按用户过滤时，用户匹配后才开始记录请求体与响应，需在转发之前调用
+	capture.SetUser(r.Context(), identity.UserID)
+	// API Key 与 JWT 只用于网关认证，不转发给后端服务
+	if identity.Method == gatewayauth.MethodAPIKey || identity.Method == gatewayauth.MethodJWT {
+		r.Header.Del("Authorization")
+	}
 	return identity, nil
 }
 
+// checkRouteIP 检查路由级 IP 规则，拒绝时已写出 403 响应
+func (pm *ProxyManager) checkRouteIP(w http.ResponseWriter, r *http.Request, route *RouteConfig) bool {
+	if route.ipFilter == nil {
+		return true
+	}
+	resolver := pm.clientIPs
+	if resolver == nil {
+		resolver = &ipfilter.Resolver{}
+	}
+	addr, _ := resolver.ClientIP(r)
+	if reason := route.ipFilter.Check(addr, pm.geo); reason != "" {
+		apimiddleware.RejectIP(w, r, addr, reason)
+		return false
+	}
+	return true
+}
+
+// rejectMaintenance 维护期间被拒绝的请求在 gateway_requests_rejected_total 中的 reason 标签
+const rejectMaintenance = "maintenance"
+
+// checkMaintenance 路由或整个网关处于维护状态时返回 503，白名单中的 IP（需要配置可信代理）和用户可以继续访问
+// 只有配置了用户白名单时才会在维护期间认证请求，认证失败同样返回维护响应
+func (pm *ProxyManager) checkMaintenance(w http.ResponseWriter, r *http.Request, route *RouteConfig) bool {
+	if pm.maintenance == nil {
+		return true
+	}
+	mode, active := pm.maintenance.Active(route.Path)
+	if !active {
+		return true
+	}
+	// IP 白名单只在配置了可信代理时生效，否则前置代理（如 nginx）的地址会匹配内网网段而放行所有请求
+	if pm.clientIPs.HasTrustedProxies() {
+		if addr, _ := pm.clientIPs.ClientIP(r); pm.maintenance.BypassIP(addr) {
+			return true
+		}
+	}
+	if pm.maintenance.HasUserBypass() && pm.authenticator != nil {
+		identity, err := pm.authenticator.AuthenticateRequest(r.Context(), r, route.AuthMethods)
+		if err == nil && pm.maintenance.BypassUser(identity.UserID) {
+			return true
+		}
+	}
+
+	apimiddleware.RejectedRequests.Inc(rejectMaintenance)
+	tlog.Debug("[API Gateway] 路由维护中", "path", r.URL.Path, "route", route.Path)
+	w.Header().Set("Retry-After", strconv.Itoa(mode.RetryAfter))
+	problem := apierror.New(r, apierror.CodeMaintenance)
+	if mode.Message != "" {
+		problem.Detail = mode.Message
+		problem.Message = mode.Message
+	}
+	apierror.WriteProblem(w, r, problem)
+	return false
+}
+
+// authorizeRequest 评估路由授权规则，拒绝时记录原因
+func (pm *ProxyManager) authorizeRequest(r *http.Request, path string, route *RouteConfig, identity *gatewayauth.Identity) bool {
+	reason := authorize(route, r.Method, path, identity)
+	if reason == "" {
+		return true
+	}
+	userID := ""
+	if identity != nil {
+		userID = identity.UserID
+	}
+	tlog.Warn("[API Gateway] 授权规则拒绝请求",
+		"method", r.Method,
+		"path", path,
+		"user_id", userID,
+		"reason", reason,
+	)
+	return false
+}
+
+// acquireConcurrency 为已认证用户占用一个并发槽位
+// 所有请求都计入用户的全局上限；路由配置了 MaxConcurrency 时还需获得 用户+路由 的槽位
+func (pm *ProxyManager) acquireConcurrency(r *http.Request, route *RouteConfig, identity *gatewayauth.Identity) (func(), error) {
+	if pm.limiter == nil || identity == nil {
+		return func() {}, nil
+	}
+	key := "user:" + identity.UserID
+	release, err := pm.limiter.Acquire(r.Context(), key, 0)
+	if err != nil {
+		pm.logConcurrencyLimited(r, identity, key, err)
+		return nil, err
+	}
+	if route.MaxConcurrency <= 0 {
+		return release, nil
+	}
+
+	routeKey := "route:" + route.Path + ":" + key
+	releaseRoute, err := pm.limiter.Acquire(r.Context(), routeKey, route.MaxConcurrency)
+	if err != nil {
+		release()
+		pm.logConcurrencyLimited(r, identity, routeKey, err)
+		return nil, err
+	}
+	// 按获取的相反顺序释放
+	return func() {
+		releaseRoute()
+		release()
+	}, nil
+}
+
+func (pm *ProxyManager) logConcurrencyLimited(r *http.Request, identity *gatewayauth.Identity, key string, err error) {
+	tlog.Warn("[API Gateway] 并发请求超限",
+		"path", r.URL.Path,
+		"user_id", identity.UserID,
+		"key", key,
+		"in_flight", pm.limiter.InFlight(key),
+		"error", err,
+	)
+}
+
+// injectIdentityHeaders 注入签名的身份头；v3 签名会先缓冲请求体（超出内存上限的部分写入临时文件）计算摘要，
+// gRPC 和超过摘要总上限的请求体直接流式转发，签名降级为 v2 并记录指标
 func (pm *ProxyManager) injectIdentityHeaders(r *http.Request, route *RouteConfig, identity *gatewayauth.Identity, path string) error {
 	if route.AuthMode != AuthModeRequired || identity == nil {
 		return nil
 	}
-	timestamp, nonce, signature, err := pm.authenticator.Sign(r.Method, path, identity.UserID)
+	payload := gatewayidentity.Payload{
+		Method:  r.Method,
+		Path:    path,
+		UserID:  identity.UserID,
+		Headers: pm.authenticator.ClaimHeaders(identity),
+	}
+	sign := pm.authenticator.SignWithoutDigest
+	if pm.authenticator.SignsContentDigest() {
+		digest, reason, err := pm.digestRequestBody(r, route)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			logDigestDowngrade(r, route, identity, reason)
+		} else {
+			payload.Query = r.URL.RawQuery
+			payload.ContentDigest = digest
+			sign = pm.authenticator.Sign
+		}
+	}
+	signature, err := sign(payload)
 	if err != nil {
 		return err
 	}
 	r.Header.Set("X-User-ID", identity.UserID)
-	r.Header.Set("X-Gateway-Timestamp", timestamp)
-	r.Header.Set("X-Gateway-Nonce", nonce)
-	r.Header.Set("X-Gateway-Signature", signature)
+	signature.Apply(r.Header)
 	return nil
 }
 
+// stripAuthCookies 移除会话 Cookie，避免后端拿到可冒充用户的凭证
+func (pm *ProxyManager) stripAuthCookies(header http.Header, route *RouteConfig) {
+	if route.ForwardAuthCookies || pm.authenticator == nil {
+		return
+	}
+	pm.authenticator.StripSessionCookies(header)
+}
+
 func sanitizeIdentityHeaders(header http.Header) {
 	header.Del("X-User-ID")
 	header.Del("X-User-Id")
 	header.Del("X-User-Email")
 	header.Del("X-Owner-ID")
 	header.Del("X-Owner-Id")
+	for _, name := range gatewayauth.ClaimHeaderNames() {
+		header.Del(name)
+	}
 	for name := range header {
 		if strings.HasPrefix(strings.ToLower(name), "x-gateway-") {
 			header.Del(name)
@@ -346,13 +744,19 @@ func sanitizeIdentityHeaders(header http.Header) {
 	}
 }
 
-func writeUnauthorized(w http.ResponseWriter) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusUnauthorized)
-	_ = json.NewEncoder(w).Encode(map[string]any{
-		"code":    http.StatusUnauthorized,
-		"message": "unauthorized",
-	})
+// writeAuthError 认证服务熔断时返回 503，其余认证失败返回 401
+func writeAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, gatewayauth.ErrAuthUnavailable) {
+		w.Header().Set("Retry-After", "10")
+		apierror.Write(w, r, apierror.CodeAuthUnavailable)
+		return
+	}
+	apierror.Write(w, r, apierror.CodeUnauthorized)
+}
+
+func writeTooManyConcurrent(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	apierror.Write(w, r, apierror.CodeTooManyConcurrent)
 }
 
 // findRoute 查找匹配的路由
@@ -374,6 +778,9 @@ func (pm *ProxyManager) findRoute(path string) *RouteConfig {
 // getProxy 获取或创建代理
 func (pm *ProxyManager) getProxy(target string, route *RouteConfig) (*httputil.ReverseProxy, error) {
 	key := fmt.Sprintf("%s:%s", target, route.ServiceName)
+	if route.Mode == RouteModeGRPC {
+		key += ":grpc"
+	}
 
 	if proxy, exists := pm.proxies[key]; exists {
 		return proxy, nil
@@ -398,49 +805,38 @@ func (pm *ProxyManager) getProxy(target string, route *RouteConfig) (*httputil.R
 			"status", resp.Status,
 			"content_type", resp.Header.Get("Content-Type"),
 		)
+		interceptUpstreamError(resp, route)
 		return nil
 	}
 
-	// 设置超时（流式响应需要完整的超时配置，而不是仅 ResponseHeaderTimeout）
-	if route.Timeout > 0 {
-		proxy.Transport = &http.Transport{
-			// 对于流式响应，不使用 ResponseHeaderTimeout，因为它可能会中断正在进行的流
-			// 使用 DialContext 超时来控制连接建立
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second, // 连接超时
-				KeepAlive: 30 * time.Second,
-			}).DialContext,
-			// 设置较大的空闲超时以支持长连接
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			// 不设置 ResponseHeaderTimeout，允许流式响应持续进行
-		}
-	}
+	// 同一服务的所有实例共享连接池，TLS 与 HTTP/2 按服务配置
+	proxy.Transport = pm.upstreamTransport(route)
 
 	// 设置错误处理
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// gRPC 调用超过客户端的截止时间不代表实例不可用
+		if route.Mode == RouteModeGRPC && errors.Is(err, context.DeadlineExceeded) {
+			tlog.Warn("代理请求超时", "target", target, "path", r.URL.Path)
+			apierror.Write(w, r, apierror.CodeUpstreamTimeout)
+			return
+		}
 		tlog.Error("代理请求失败", "target", target, "path", r.URL.Path, "error", err)
 		pm.discovery.InvalidateCache(route.ServiceName)
-		writeErrorResponse(w, "后端服务错误", http.StatusBadGateway)
+		apierror.Write(w, r, apierror.CodeUpstreamError)
 	}
 
 	pm.proxies[key] = proxy
 	return proxy, nil
 }
 
-// writeErrorResponse 写入错误响应
-func writeErrorResponse(w http.ResponseWriter, message string, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-
-	errorResp := map[string]any{
-		"error":   http.StatusText(code),
-		"message": message,
-		"code":    code,
+// propagateRequestID 把请求 ID 中间件生成的 ID 传给后端，客户端自带的 ID 已在请求头中
+func propagateRequestID(upstream, response http.Header) {
+	if upstream.Get("X-Request-ID") != "" {
+		return
+	}
+	if requestID := response.Get("X-Request-ID"); requestID != "" {
+		upstream.Set("X-Request-ID", requestID)
 	}
-
-	json.NewEncoder(w).Encode(errorResp)
 }
 
 // getScheme 获取请求协议
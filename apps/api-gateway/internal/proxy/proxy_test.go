@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
+	gatewayauth "github.com/indulgeback/telos/apps/api-gateway/internal/auth"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/ipfilter"
+	apimiddleware "github.com/indulgeback/telos/apps/api-gateway/internal/middleware"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/service"
+	"github.com/indulgeback/telos/apps/api-gateway/internal/tlsconfig"
+)
+
+func TestRouteIPFilterRejectsOutsideAllowList(t *testing.T) {
+	resolver, err := ipfilter.NewResolver([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("NewResolver: %v", err)
+	}
+	pm := NewProxyManager(nil, nil)
+	pm.SetIPFilter(resolver, nil)
+	if err := pm.LoadRoutes([]RouteConfig{{
+		Path:     "/api/mcp-servers",
+		IPFilter: &ipfilter.Rules{Allow: []string{"192.168.10.0/24"}},
+	}}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+	route := pm.findRoute("/api/mcp-servers")
+
+	office := httptest.NewRequest(http.MethodGet, "/api/mcp-servers", nil)
+	office.RemoteAddr = "10.0.0.1:443"
+	office.Header.Set("X-Forwarded-For", "192.168.10.20")
+	if !pm.checkRouteIP(httptest.NewRecorder(), office, route) {
+		t.Fatal("expected office address behind trusted proxy to be allowed")
+	}
+
+	// 非可信代理转发的 X-Forwarded-For 不生效
+	spoofed := httptest.NewRequest(http.MethodGet, "/api/mcp-servers", nil)
+	spoofed.RemoteAddr = "203.0.113.9:443"
+	spoofed.Header.Set("X-Forwarded-For", "192.168.10.20")
+	rec := httptest.NewRecorder()
+	if pm.checkRouteIP(rec, spoofed, route) {
+		t.Fatal("expected spoofed forwarded address to be rejected")
+	}
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+}
+
+func TestLoadRoutesRequiresGeoDBForCountryRules(t *testing.T) {
+	pm := NewProxyManager(nil, nil)
+	err := pm.LoadRoutes([]RouteConfig{{
+		Path:     "/api/agents",
+		IPFilter: &ipfilter.Rules{DenyCountries: []string{"XX"}},
+	}})
+	if err == nil {
+		t.Fatal("expected country rules without a GeoIP database to be rejected")
+	}
+}
+
+func TestServiceUnavailableDoesNotLeakDiscoveryError(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "consul: no cluster leader", http.StatusInternalServerError)
+	}))
+	defer registry.Close()
+	discovery := service.NewRegistryServiceDiscovery(registry.URL, service.NewRoundRobinLoadBalancer())
+	pm := NewProxyManager(discovery, nil)
+	if err := pm.LoadRoutes([]RouteConfig{{Path: "/api/agent", ServiceName: "agent-service"}}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agent", nil)
+	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-ID", "req-1")
+	pm.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != apierror.ContentType {
+		t.Fatalf("expected problem+json 503, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); strings.Contains(body, "agent-service") || strings.Contains(body, "consul") {
+		t.Fatalf("error response leaks service name: %s", rec.Body.String())
+	}
+	var problem apierror.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	if problem.Code != apierror.CodeServiceUnavailable || problem.Detail != "Service temporarily unavailable" ||
+		problem.RequestID != "req-1" || problem.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("unexpected problem %+v", problem)
+	}
+}
+
+func TestProxyUsesMutualTLSForHTTPSInstances(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", ca.cert.Raw)
+	serverCert := ca.issue(t, "agent.internal", x509.ExtKeyUsageServerAuth)
+	clientCertFile, clientKeyFile := ca.issueFiles(t, dir, "api-gateway", x509.ExtKeyUsageClientAuth)
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName + " " + r.TLS.ServerName))
+	}))
+	backend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	}
+	backend.StartTLS()
+	defer backend.Close()
+
+	upstreamTLS, err := tlsconfig.NewUpstreamConfigs(map[string]tlsconfig.UpstreamOptions{
+		"agent-service": {CAFile: caFile, CertFile: clientCertFile, KeyFile: clientKeyFile, ServerName: "agent.internal"},
+	})
+	if err != nil {
+		t.Fatalf("NewUpstreamConfigs: %v", err)
+	}
+	pm := NewProxyManager(newTestDiscovery(t, "agent-service", backend.Listener.Addr(), map[string]string{"scheme": "https"}), nil)
+	pm.SetUpstreamTLS(upstreamTLS)
+	if err := pm.LoadRoutes([]RouteConfig{{Path: "/api/tools", ServiceName: "agent-service", AuthMode: AuthModePublic}}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tools", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if string(body) != "api-gateway agent.internal" {
+		t.Fatalf("expected client certificate and SNI override, got %q", body)
+	}
+}
+
+// newTestDiscovery 通过模拟的注册中心发现单个实例
+func newTestDiscovery(t *testing.T, serviceName string, addr net.Addr, meta map[string]string) service.ServiceDiscovery {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(addr.String())
+	portNumber, _ := strconv.Atoi(port)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"services": []map[string]any{{
+			"name":    serviceName,
+			"address": host,
+			"port":    portNumber,
+			"status":  "passing",
+			"meta":    meta,
+		}}})
+	}))
+	t.Cleanup(registry.Close)
+	return service.NewRegistryServiceDiscovery(registry.URL, service.NewRoundRobinLoadBalancer())
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Telos Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+func (ca *testCA) issueDER(t *testing.T, name string, usage x509.ExtKeyUsage) ([]byte, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der, key
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	der, key := ca.issueDER(t, name, usage)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca *testCA) issueFiles(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	der, key := ca.issueDER(t, name, usage)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrencyCountsRouteRequestsTowardUserLimit(t *testing.T) {
+	pm := NewProxyManager(nil, nil)
+	pm.SetConcurrencyLimiter(apimiddleware.NewConcurrencyLimiter(2, 0))
+	agent := &RouteConfig{Path: "/api/agent", MaxConcurrency: 1}
+	other := &RouteConfig{Path: "/api/user"}
+	identity := &gatewayauth.Identity{UserID: "u_1"}
+	acquire := func(route *RouteConfig) (func(), error) {
+		return pm.acquireConcurrency(httptest.NewRequest(http.MethodGet, route.Path, nil), route, identity)
+	}
+
+	releaseAgent, err := acquire(agent)
+	if err != nil {
+		t.Fatalf("expected first agent request to be admitted: %v", err)
+	}
+	// 路由上限已满，且不能占用全局槽位
+	if _, err := acquire(agent); err == nil {
+		t.Fatal("expected second agent request to hit the route limit")
+	}
+	releaseOther, err := acquire(other)
+	if err != nil {
+		t.Fatalf("expected normal request to be admitted: %v", err)
+	}
+	// 路由请求计入用户全局上限
+	if _, err := acquire(other); err == nil {
+		t.Fatal("expected agent request to count toward the global per-user limit")
+	}
+
+	releaseAgent()
+	releaseOther()
+	if inFlight := pm.limiter.InFlight("user:u_1") + pm.limiter.InFlight("route:/api/agent:user:u_1"); inFlight != 0 {
+		t.Fatalf("expected all slots to be released, got %d", inFlight)
+	}
+}
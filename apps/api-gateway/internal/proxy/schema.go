@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// jsonSchema 是 JSON Schema 的一个精简子集，覆盖网关校验请求体所需的关键字：
+// type、properties、required、additionalProperties、items、enum、
+// minLength/maxLength、minimum/maximum、minItems/maxItems、pattern
+type jsonSchema struct {
+	types                []string
+	properties           map[string]*jsonSchema
+	required             []string
+	additionalProperties *jsonSchema
+	denyAdditional       bool
+	items                *jsonSchema
+	enum                 []any
+	minLength            *int
+	maxLength            *int
+	minimum              *float64
+	maximum              *float64
+	minItems             *int
+	maxItems             *int
+	pattern              *regexp.Regexp
+}
+
+type rawJSONSchema struct {
+	Type                 json.RawMessage            `json:"type"`
+	Properties           map[string]json.RawMessage `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties json.RawMessage            `json:"additionalProperties"`
+	Items                json.RawMessage            `json:"items"`
+	Enum                 []any                      `json:"enum"`
+	MinLength            *int                       `json:"minLength"`
+	MaxLength            *int                       `json:"maxLength"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	MinItems             *int                       `json:"minItems"`
+	MaxItems             *int                       `json:"maxItems"`
+	Pattern              string                     `json:"pattern"`
+}
+
+// compileJSONSchema 解析路由配置中的 JSON Schema
+func compileJSONSchema(data json.RawMessage) (*jsonSchema, error) {
+	var raw rawJSONSchema
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("解析 JSON Schema 失败: %w", err)
+	}
+
+	schema := &jsonSchema{
+		required:  raw.Required,
+		enum:      raw.Enum,
+		minLength: raw.MinLength,
+		maxLength: raw.MaxLength,
+		minimum:   raw.Minimum,
+		maximum:   raw.Maximum,
+		minItems:  raw.MinItems,
+		maxItems:  raw.MaxItems,
+	}
+
+	if len(raw.Type) > 0 {
+		var single string
+		if err := json.Unmarshal(raw.Type, &single); err == nil {
+			schema.types = []string{single}
+		} else if err := json.Unmarshal(raw.Type, &schema.types); err != nil {
+			return nil, fmt.Errorf("type 必须是字符串或字符串数组")
+		}
+	}
+
+	if len(raw.Properties) > 0 {
+		schema.properties = make(map[string]*jsonSchema, len(raw.Properties))
+		for name, child := range raw.Properties {
+			compiled, err := compileJSONSchema(child)
+			if err != nil {
+				return nil, fmt.Errorf("properties.%s: %w", name, err)
+			}
+			schema.properties[name] = compiled
+		}
+	}
+
+	if len(raw.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(raw.AdditionalProperties, &allowed); err == nil {
+			schema.denyAdditional = !allowed
+		} else {
+			compiled, err := compileJSONSchema(raw.AdditionalProperties)
+			if err != nil {
+				return nil, fmt.Errorf("additionalProperties: %w", err)
+			}
+			schema.additionalProperties = compiled
+		}
+	}
+
+	if len(raw.Items) > 0 {
+		compiled, err := compileJSONSchema(raw.Items)
+		if err != nil {
+			return nil, fmt.Errorf("items: %w", err)
+		}
+		schema.items = compiled
+	}
+
+	if raw.Pattern != "" {
+		pattern, err := regexp.Compile(raw.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("pattern: %w", err)
+		}
+		schema.pattern = pattern
+	}
+
+	return schema, nil
+}
+
+// validate 校验 value（由 encoding/json 解码得到）是否符合 schema，path 用于错误定位
+func (s *jsonSchema) validate(value any, path string) error {
+	if len(s.types) > 0 && !s.matchesType(value) {
+		return fmt.Errorf("%s 类型应为 %v", path, s.types)
+	}
+
+	if len(s.enum) > 0 && !containsJSONValue(s.enum, value) {
+		return fmt.Errorf("%s 不在允许的取值范围内", path)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s.%s 为必填字段", path, name)
+			}
+		}
+		for name, child := range v {
+			childPath := path + "." + name
+			if propSchema, ok := s.properties[name]; ok {
+				if err := propSchema.validate(child, childPath); err != nil {
+					return err
+				}
+				continue
+			}
+			if s.denyAdditional {
+				return fmt.Errorf("%s 不是允许的字段", childPath)
+			}
+			if s.additionalProperties != nil {
+				if err := s.additionalProperties.validate(child, childPath); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			return fmt.Errorf("%s 至少需要 %d 个元素", path, *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			return fmt.Errorf("%s 最多允许 %d 个元素", path, *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				if err := s.items.validate(item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := len([]rune(v))
+		if s.minLength != nil && length < *s.minLength {
+			return fmt.Errorf("%s 长度不能小于 %d", path, *s.minLength)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return fmt.Errorf("%s 长度不能超过 %d", path, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			return fmt.Errorf("%s 格式不正确", path)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			return fmt.Errorf("%s 不能小于 %v", path, *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			return fmt.Errorf("%s 不能大于 %v", path, *s.maximum)
+		}
+	}
+
+	return nil
+}
+
+func (s *jsonSchema) matchesType(value any) bool {
+	for _, t := range s.types {
+		switch t {
+		case "object":
+			if _, ok := value.(map[string]any); ok {
+				return true
+			}
+		case "array":
+			if _, ok := value.([]any); ok {
+				return true
+			}
+		case "string":
+			if _, ok := value.(string); ok {
+				return true
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		case "integer":
+			if v, ok := value.(float64); ok && v == math.Trunc(v) {
+				return true
+			}
+		case "boolean":
+			if _, ok := value.(bool); ok {
+				return true
+			}
+		case "null":
+			if value == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsJSONValue(values []any, value any) bool {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, candidate := range values {
+		if other, err := json.Marshal(candidate); err == nil && string(other) == string(encoded) {
+			return true
+		}
+	}
+	return false
+}
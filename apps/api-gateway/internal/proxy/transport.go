@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/tlsconfig"
+)
+
+// UpstreamProtocol 网关连接后端使用的 HTTP 协议
+type UpstreamProtocol string
+
+const (
+	// UpstreamHTTP1 默认协议，每个进行中的请求（包括 SSE 流）独占一条 TCP 连接
+	UpstreamHTTP1 UpstreamProtocol = "http1"
+	// UpstreamHTTP2 https 实例通过 ALPN 协商 HTTP/2，后端不支持时回退到 HTTP/1.1
+	UpstreamHTTP2 UpstreamProtocol = "h2"
+	// UpstreamH2C 明文实例直接使用 HTTP/2（prior knowledge），后端必须支持 h2c；WebSocket 握手仍走 HTTP/1.1
+	UpstreamH2C UpstreamProtocol = "h2c"
+)
+
+// ParseUpstreamProtocol 解析协议名，空字符串表示 http1
+func ParseUpstreamProtocol(value string) (UpstreamProtocol, error) {
+	switch protocol := UpstreamProtocol(strings.ToLower(strings.TrimSpace(value))); protocol {
+	case "", UpstreamHTTP1:
+		return UpstreamHTTP1, nil
+	case UpstreamHTTP2, UpstreamH2C:
+		return protocol, nil
+	default:
+		return "", fmt.Errorf("不支持的上游协议 %q（可选 http1、h2、h2c）", value)
+	}
+}
+
+// UpstreamTransportConfig 上游连接配置
+type UpstreamTransportConfig struct {
+	// Protocols 按服务名配置的协议，未配置的服务使用 http1
+	Protocols map[string]UpstreamProtocol
+	// MaxConnsPerHost 每个实例的最大连接数，0 表示不限制；HTTP/2 下多个流复用同一连接
+	MaxConnsPerHost int
+	// MaxIdleConnsPerHost 每个实例保留的空闲连接数，0 表示使用 net/http 默认值
+	MaxIdleConnsPerHost int
+}
+
+// upstreamHTTP2Config 长连接上的流可能长时间没有数据，定期 ping 以尽早发现失效的连接
+var upstreamHTTP2Config = &http.HTTP2Config{
+	SendPingTimeout: 30 * time.Second,
+	PingTimeout:     15 * time.Second,
+}
+
+// SetUpstreamTLS 设置按服务名的上游 TLS 配置，键 "*" 适用于未单独配置的服务
+// 需在处理请求之前调用；配置只对 https:// 实例生效
+func (pm *ProxyManager) SetUpstreamTLS(configs map[string]*tls.Config) {
+	pm.upstreamTLS = configs
+}
+
+// SetUpstreamTransport 设置上游协议与连接池限制，需在处理请求之前调用
+func (pm *ProxyManager) SetUpstreamTransport(config UpstreamTransportConfig) {
+	pm.upstreamTransportConfig = config
+}
+
+func (pm *ProxyManager) upstreamTLSConfig(serviceName string) *tls.Config {
+	if config, ok := pm.upstreamTLS[serviceName]; ok {
+		return config
+	}
+	return pm.upstreamTLS[tlsconfig.DefaultUpstream]
+}
+
+// upstreamTransport 返回服务共享的连接池，ReverseProxy 与流式代理共用，HTTP/2 下所有流复用少量连接
+// gRPC 路由使用单独的纯 HTTP/2 连接池
+func (pm *ProxyManager) upstreamTransport(route *RouteConfig) http.RoundTripper {
+	key := route.ServiceName
+	if route.Mode == RouteModeGRPC {
+		key = "grpc:" + key
+	}
+	pm.transportsMu.Lock()
+	defer pm.transportsMu.Unlock()
+	transport, ok := pm.transports[key]
+	if !ok {
+		transport = pm.newServiceTransport(route.ServiceName, route.Mode)
+		pm.transports[key] = transport
+	}
+	return transport
+}
+
+func (pm *ProxyManager) newServiceTransport(serviceName string, mode RouteMode) http.RoundTripper {
+	config := pm.upstreamTransportConfig
+	tlsConfig := pm.upstreamTLSConfig(serviceName)
+	http1 := newUpstreamTransport(tlsConfig, config)
+
+	protocol := config.Protocols[serviceName]
+	if mode == RouteModeGRPC {
+		// gRPC 只能跑在 HTTP/2 上：https 实例通过 ALPN 协商，明文实例使用 h2c
+		protocol = UpstreamH2C
+	}
+	switch protocol {
+	case UpstreamHTTP2:
+		http1.Protocols = new(http.Protocols)
+		http1.Protocols.SetHTTP1(true)
+		http1.Protocols.SetHTTP2(true)
+		http1.HTTP2 = upstreamHTTP2Config
+		return http1
+	case UpstreamH2C:
+		http2 := newUpstreamTransport(tlsConfig, config)
+		http2.Protocols = new(http.Protocols)
+		http2.Protocols.SetUnencryptedHTTP2(true)
+		http2.Protocols.SetHTTP2(true)
+		http2.HTTP2 = upstreamHTTP2Config
+		return &h2cTransport{http2: http2, http1: http1}
+	default:
+		return http1
+	}
+}
+
+// h2cTransport HTTP/2 没有 Upgrade 机制，带 Upgrade 头的请求（WebSocket 握手）改用 HTTP/1.1 连接
+type h2cTransport struct {
+	http2 *http.Transport
+	http1 *http.Transport
+}
+
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Upgrade") != "" {
+		return t.http1.RoundTrip(req)
+	}
+	return t.http2.RoundTrip(req)
+}
+
+// newUpstreamTransport 创建连接后端的 Transport，tlsConfig 为 nil 时使用默认的证书校验
+func newUpstreamTransport(tlsConfig *tls.Config, config UpstreamTransportConfig) *http.Transport {
+	return &http.Transport{
+		// 对于流式响应，不使用 ResponseHeaderTimeout，因为它可能会中断正在进行的流
+		// 使用 DialContext 超时来控制连接建立
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second, // 连接超时
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		// 设置较大的空闲超时以支持长连接
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       tlsConfig,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		// 不设置 ResponseHeaderTimeout，允许流式响应持续进行
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestH2CUpstreamMultiplexesAndFlushesSSEStreams(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	conns := make(map[string]bool)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			http.Error(w, "expected HTTP/2, got "+r.Proto, http.StatusHTTPVersionNotSupported)
+			return
+		}
+		mu.Lock()
+		conns[r.RemoteAddr] = true
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Write([]byte("data: second\n\n"))
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetHTTP1(true)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	pm := NewProxyManager(newTestDiscovery(t, "agent-service", backend.Listener.Addr(), nil), nil)
+	pm.SetUpstreamTransport(UpstreamTransportConfig{
+		Protocols: map[string]UpstreamProtocol{"agent-service": UpstreamH2C},
+	})
+	if err := pm.LoadRoutes([]RouteConfig{{Path: "/api/agent", ServiceName: "agent-service", StripPrefix: true, AuthMode: AuthModePublic}}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+	e := echo.New()
+	e.Any("/*", pm.EchoHandler)
+	gateway := httptest.NewServer(e)
+	defer gateway.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	var streams []*bufio.Reader
+	for range 3 {
+		resp, err := client.Get(gateway.URL + "/api/agent/chat")
+		if err != nil {
+			t.Fatalf("GET: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+		stream := bufio.NewReader(resp.Body)
+		// 后端还在等待时第一个事件就必须到达客户端
+		expectEvent(t, stream, "data: first")
+		streams = append(streams, stream)
+	}
+
+	close(release)
+	for _, stream := range streams {
+		expectEvent(t, stream, "data: second")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(conns) != 1 {
+		t.Fatalf("expected streams to share one upstream connection, got %d", len(conns))
+	}
+}
+
+func TestH2CUpstreamSendsUpgradeRequestsOverHTTP1(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	backend.Config.Protocols = new(http.Protocols)
+	backend.Config.Protocols.SetHTTP1(true)
+	backend.Config.Protocols.SetUnencryptedHTTP2(true)
+	backend.Start()
+	defer backend.Close()
+
+	pm := NewProxyManager(newTestDiscovery(t, "agent-service", backend.Listener.Addr(), nil), nil)
+	pm.SetUpstreamTransport(UpstreamTransportConfig{
+		Protocols: map[string]UpstreamProtocol{"agent-service": UpstreamH2C},
+	})
+	if err := pm.LoadRoutes([]RouteConfig{{Path: "/api/tools", ServiceName: "agent-service", AuthMode: AuthModePublic}}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	pm.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tools", nil))
+	if body := rec.Body.String(); body != "HTTP/2.0" {
+		t.Fatalf("expected HTTP/2 upstream request, got %q", body)
+	}
+
+	upgrade := httptest.NewRequest(http.MethodGet, "/api/tools", nil)
+	upgrade.Header.Set("Connection", "Upgrade")
+	upgrade.Header.Set("Upgrade", "websocket")
+	rec = httptest.NewRecorder()
+	pm.ServeHTTP(rec, upgrade)
+	if body := rec.Body.String(); body != "HTTP/1.1" {
+		t.Fatalf("expected upgrade request over HTTP/1.1, got %q", body)
+	}
+}
+
+func TestParseUpstreamProtocol(t *testing.T) {
+	for value, expected := range map[string]UpstreamProtocol{"": UpstreamHTTP1, "H2C": UpstreamH2C, " h2 ": UpstreamHTTP2} {
+		if protocol, err := ParseUpstreamProtocol(value); err != nil || protocol != expected {
+			t.Errorf("%q: expected %s, got %s (%v)", value, expected, protocol, err)
+		}
+	}
+	if _, err := ParseUpstreamProtocol("http3"); err == nil {
+		t.Error("expected unsupported protocol to be rejected")
+	}
+}
+
+func expectEvent(t *testing.T, stream *bufio.Reader, expected string) {
+	t.Helper()
+	line, err := stream.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read event: %v", err)
+	}
+	if strings.TrimSpace(line) != expected {
+		t.Fatalf("expected %q, got %q", expected, line)
+	}
+	// 跳过事件之间的空行
+	if _, err := stream.ReadString('\n'); err != nil {
+		t.Fatalf("read event separator: %v", err)
+	}
+}
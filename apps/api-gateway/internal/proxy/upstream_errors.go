@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// DefaultUpstreamErrorLogBytes 日志中默认记录的后端错误响应体长度
+const DefaultUpstreamErrorLogBytes = 1024
+
+// upstreamErrorInspectLimit 判断后端错误响应是否已是统一格式时最多读取的字节数，超过时按非统一格式处理
+const upstreamErrorInspectLimit = 64 << 10
+
+// UpstreamErrorConfig 路由级的上游错误拦截：后端返回的 HTML 错误页、空响应等替换为网关统一的错误格式，
+// 后端已按统一格式返回的 JSON 错误原样保留
+type UpstreamErrorConfig struct {
+	// Statuses 拦截的状态码，为空时拦截所有 5xx；配置了自定义页面的状态码总会被拦截
+	Statuses []int `json:"statuses"`
+	// Pages 按状态码替换响应体的文件，如 {503: "/etc/telos/maintenance.html"}，Content-Type 按扩展名确定
+	Pages map[int]string `json:"pages"`
+	// LogBodyBytes 日志中记录的后端响应体长度，0 表示使用默认值 1024，负数表示不记录响应体
+	LogBodyBytes int `json:"logBodyBytes"`
+
+	pages map[int]errorPage
+}
+
+// errorPage 启动时读入内存的自定义错误页
+type errorPage struct {
+	body        []byte
+	contentType string
+}
+
+// compile 读取自定义错误页，文件不存在时在加载路由阶段报错
+func (c *UpstreamErrorConfig) compile() error {
+	for _, status := range c.Statuses {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("拦截的状态码 %d 无效", status)
+		}
+	}
+	pages := make(map[int]errorPage, len(c.Pages))
+	for status, path := range c.Pages {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("错误页的状态码 %d 无效", status)
+		}
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("读取 %d 错误页失败: %w", status, err)
+		}
+		contentType := mime.TypeByExtension(filepath.Ext(path))
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		pages[status] = errorPage{body: body, contentType: contentType}
+	}
+	c.pages = pages
+	return nil
+}
+
+func (c *UpstreamErrorConfig) intercepts(status int) bool {
+	if _, ok := c.pages[status]; ok {
+		return true
+	}
+	if len(c.Statuses) == 0 {
+		return status >= 500
+	}
+	for _, candidate := range c.Statuses {
+		if candidate == status {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *UpstreamErrorConfig) logBodyBytes() int {
+	if c.LogBodyBytes == 0 {
+		return DefaultUpstreamErrorLogBytes
+	}
+	return c.LogBodyBytes
+}
+
+// originalPathKey 在请求上下文中保存去掉路由前缀之前的路径，错误响应的 instance 使用客户端看到的路径
+type originalPathKey struct{}
+
+func withOriginalPath(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), originalPathKey{}, r.URL.Path))
+}
+
+func originalPath(r *http.Request) string {
+	if path, ok := r.Context().Value(originalPathKey{}).(string); ok {
+		return path
+	}
+	return r.URL.Path
+}
+
+// interceptUpstreamError 在后端的错误响应返回客户端之前按路由配置替换响应体，resp.Request 为转发给后端的请求
+// 优先使用自定义错误页，其次保留已是统一格式的 JSON 错误，其余转换为网关错误格式并保留后端的状态码
+func interceptUpstreamError(resp *http.Response, route *RouteConfig) {
+	config := route.UpstreamErrors
+	if config == nil || route.Mode == RouteModeGRPC || resp.Request.Method == http.MethodHead || !config.intercepts(resp.StatusCode) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, upstreamErrorInspectLimit+1))
+	_ = resp.Body.Close()
+	path := originalPath(resp.Request)
+	logUpstreamError(resp, route, path, body, config.logBodyBytes(), err)
+
+	if page, ok := config.pages[resp.StatusCode]; ok {
+		replaceResponseBody(resp, page.contentType, page.body)
+		return
+	}
+	if err == nil && len(body) <= upstreamErrorInspectLimit && isErrorEnvelope(resp.Header, body) {
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return
+	}
+
+	problem := apierror.New(resp.Request, upstreamErrorCode(resp.StatusCode)).WithStatus(resp.StatusCode)
+	problem.Instance = path
+	encoded, _ := json.Marshal(problem)
+	replaceResponseBody(resp, apierror.ContentType, append(encoded, '\n'))
+	resp.Header.Set("Content-Language", string(apierror.Negotiate(resp.Request.Header.Get("Accept-Language"))))
+}
+
+// upstreamErrorCode 后端状态码对应的错误码，5xx 统一视为后端错误
+func upstreamErrorCode(status int) apierror.Code {
+	switch {
+	case status == http.StatusServiceUnavailable:
+		return apierror.CodeServiceUnavailable
+	case status == http.StatusGatewayTimeout:
+		return apierror.CodeUpstreamTimeout
+	case status >= 500:
+		return apierror.CodeUpstreamError
+	default:
+		return apierror.CodeForStatus(status)
+	}
+}
+
+// isErrorEnvelope 判断后端的错误响应是否已是统一格式：problem+json，或带 code 与 message/detail 的 JSON 对象
+func isErrorEnvelope(header http.Header, body []byte) bool {
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !isJSONMediaType(mediaType) {
+		return false
+	}
+	var payload struct {
+		Code    json.RawMessage `json:"code"`
+		Message string          `json:"message"`
+		Detail  string          `json:"detail"`
+	}
+	if json.Unmarshal(body, &payload) != nil {
+		return false
+	}
+	if mediaType == apierror.ContentType {
+		return true
+	}
+	return len(payload.Code) > 0 && string(payload.Code) != "null" && (payload.Message != "" || payload.Detail != "")
+}
+
+func replaceResponseBody(resp *http.Response, contentType string, body []byte) {
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Range")
+	resp.Header.Del("ETag")
+	resp.Header.Del("Last-Modified")
+}
+
+// logUpstreamError 记录后端错误响应，响应体截断到 limit 字节
+func logUpstreamError(resp *http.Response, route *RouteConfig, path string, body []byte, limit int, readErr error) {
+	args := []any{
+		"service", route.ServiceName,
+		"method", resp.Request.Method,
+		"path", path,
+		"status", resp.StatusCode,
+		"content_type", resp.Header.Get("Content-Type"),
+	}
+	if limit > 0 {
+		if len(body) > limit {
+			body = body[:limit]
+		}
+		args = append(args, "body", strings.ToValidUTF8(string(body), ""))
+	}
+	if readErr != nil {
+		args = append(args, "error", readErr)
+	}
+	tlog.Warn("[API Gateway] 后端返回错误响应", args...)
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
+)
+
+func TestUpstreamErrorInterception(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/agent/html":
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("<html><body>Traceback: KeyError 'model'</body></html>"))
+		case "/api/agent/json":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"code":"quota_exceeded","message":"模型额度已用完"}`))
+		case "/api/agent/empty":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<html>not found</html>"))
+		}
+	}))
+	defer backend.Close()
+
+	page := filepath.Join(t.TempDir(), "502.html")
+	if err := os.WriteFile(page, []byte("<h1>稍后再试</h1>"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pm := NewProxyManager(newTestDiscovery(t, "agent-service", backend.Listener.Addr(), nil), nil)
+	if err := pm.LoadRoutes([]RouteConfig{{
+		Path:           "/api/agent",
+		ServiceName:    "agent-service",
+		UpstreamErrors: &UpstreamErrorConfig{Pages: map[int]string{http.StatusBadGateway: page}},
+	}}); err != nil {
+		t.Fatalf("LoadRoutes: %v", err)
+	}
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Language", "en")
+		rec := httptest.NewRecorder()
+		rec.Header().Set("X-Request-ID", "req-42")
+		pm.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// HTML 错误页转换为统一格式，保留后端状态码，不泄露后端内容
+	rec := serve("/api/agent/html")
+	var problem apierror.Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("decode problem: %v (%s)", err, rec.Body.String())
+	}
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != apierror.ContentType ||
+		problem.Code != apierror.CodeUpstreamError || problem.Status != http.StatusInternalServerError ||
+		problem.RequestID != "req-42" || problem.Instance != "/api/agent/html" || problem.Detail != "Upstream service error" {
+		t.Fatalf("unexpected converted error %d %+v", rec.Code, problem)
+	}
+	if strings.Contains(rec.Body.String(), "Traceback") {
+		t.Fatalf("backend error body leaked: %s", rec.Body.String())
+	}
+
+	// 已是统一格式的 JSON 错误原样保留
+	rec = serve("/api/agent/json")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "quota_exceeded") {
+		t.Fatalf("expected backend JSON error to be preserved, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 配置了自定义页面的状态码使用页面内容
+	rec = serve("/api/agent/empty")
+	if rec.Code != http.StatusBadGateway || rec.Body.String() != "<h1>稍后再试</h1>" ||
+		!strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("expected custom error page, got %d %q %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	// 默认只拦截 5xx
+	rec = serve("/api/agent/missing")
+	if rec.Code != http.StatusNotFound || rec.Body.String() != "<html>not found</html>" {
+		t.Fatalf("expected 404 to pass through, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUpstreamErrorConfigRejectsMissingPage(t *testing.T) {
+	pm := NewProxyManager(nil, nil)
+	err := pm.LoadRoutes([]RouteConfig{{
+		Path:           "/api/agent",
+		UpstreamErrors: &UpstreamErrorConfig{Pages: map[int]string{http.StatusServiceUnavailable: filepath.Join(t.TempDir(), "missing.html")}},
+	}})
+	if err == nil {
+		t.Fatal("expected missing error page to be rejected")
+	}
+}
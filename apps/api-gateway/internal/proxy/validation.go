@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/indulgeback/telos/apps/api-gateway/internal/apierror"
+)
+
+// requestRejection 描述请求在到达后端之前被网关拒绝的原因
+type requestRejection struct {
+	code apierror.Code
+	args []any
+}
+
+func (e *requestRejection) Error() string {
+	return apierror.Message(e.code, apierror.DefaultLanguage, e.args...)
+}
+
+// enforceRequestBody 按路由配置校验请求体的大小、Content-Type 与 JSON 结构
+// 需要读取请求体时（配置了 Schema 或长度未知）会将其缓冲并替换 r.Body，
+// 保证转发给后端的内容与校验过的内容一致
+func enforceRequestBody(r *http.Request, route *RouteConfig) *requestRejection {
+	if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+		return nil
+	}
+
+	mediaType := ""
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return &requestRejection{code: apierror.CodeInvalidContentType}
+		}
+		mediaType = parsed
+	}
+	if len(route.AllowedContentTypes) > 0 && !mediaTypeAllowed(mediaType, route.AllowedContentTypes) {
+		return &requestRejection{code: apierror.CodeUnsupportedMediaType, args: []any{mediaType}}
+	}
+
+	if route.MaxBodyBytes > 0 && r.ContentLength > route.MaxBodyBytes {
+		return tooLarge(route.MaxBodyBytes)
+	}
+
+	// 长度已知且无需解析内容时直接流式转发，避免缓冲大请求体
+	needsBuffer := route.schema != nil || (route.MaxBodyBytes > 0 && r.ContentLength < 0)
+	if !needsBuffer {
+		return nil
+	}
+
+	reader := io.Reader(r.Body)
+	if route.MaxBodyBytes > 0 {
+		reader = io.LimitReader(r.Body, route.MaxBodyBytes+1)
+	}
+	data, err := io.ReadAll(reader)
+	_ = r.Body.Close()
+	if err != nil {
+		return &requestRejection{code: apierror.CodeBodyReadFailed}
+	}
+	if route.MaxBodyBytes > 0 && int64(len(data)) > route.MaxBodyBytes {
+		return tooLarge(route.MaxBodyBytes)
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	r.TransferEncoding = nil
+	r.Header.Set("Content-Length", strconv.Itoa(len(data)))
+
+	if route.schema != nil && isJSONMediaType(mediaType) {
+		var payload any
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return &requestRejection{code: apierror.CodeInvalidJSON}
+		}
+		if err := route.schema.validate(payload, "$"); err != nil {
+			return &requestRejection{code: apierror.CodeBodyValidationFailed, args: []any{err.Error()}}
+		}
+	}
+
+	return nil
+}
+
+func tooLarge(limit int64) *requestRejection {
+	return &requestRejection{code: apierror.CodeBodyTooLarge, args: []any{limit}}
+}
+
+func mediaTypeAllowed(mediaType string, allowed []string) bool {
+	for _, candidate := range allowed {
+		candidate = strings.ToLower(strings.TrimSpace(candidate))
+		if candidate == mediaType {
+			return true
+		}
+		// 支持 "text/*" 形式的通配
+		if strings.HasSuffix(candidate, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(candidate, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func isJSONMediaType(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newValidatedRoute(t *testing.T, route RouteConfig) *RouteConfig {
+	t.Helper()
+	pm := NewProxyManager(nil, nil)
+	if err := pm.LoadRoutes([]RouteConfig{route}); err != nil {
+		t.Fatalf("expected routes to load: %v", err)
+	}
+	return &pm.routes[0]
+}
+
+func TestEnforceRequestBodyRejectsOversizedBody(t *testing.T) {
+	route := newValidatedRoute(t, RouteConfig{Path: "/api/agent", MaxBodyBytes: 8})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/agent", strings.NewReader(`{"message":"too long"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if rejection := enforceRequestBody(req, route); rejection == nil || rejection.code.Status() != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %+v", rejection)
+	}
+
+	// 长度未知的请求体同样需要受限
+	req = httptest.NewRequest(http.MethodPost, "/api/agent", strings.NewReader(`{"message":"too long"}`))
+	req.ContentLength = -1
+	if rejection := enforceRequestBody(req, route); rejection == nil || rejection.code.Status() != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for chunked body, got %+v", rejection)
+	}
+}
+
+func TestEnforceRequestBodyRejectsUnsupportedContentType(t *testing.T) {
+	route := newValidatedRoute(t, RouteConfig{Path: "/api/agents", AllowedContentTypes: []string{"application/json"}})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/agents", strings.NewReader("name=x"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rejection := enforceRequestBody(req, route); rejection == nil || rejection.code.Status() != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %+v", rejection)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+	if rejection := enforceRequestBody(req, route); rejection != nil {
+		t.Fatalf("expected bodyless request to pass, got %+v", rejection)
+	}
+}
+
+func TestEnforceRequestBodyValidatesSchemaAndPreservesBody(t *testing.T) {
+	route := newValidatedRoute(t, RouteConfig{
+		Path:         "/api/agent",
+		MaxBodyBytes: 1024,
+		RequestSchema: json.RawMessage(`{
+			"type": "object",
+			"required": ["message"],
+			"properties": {
+				"message": {"type": "string", "minLength": 1, "maxLength": 16},
+				"images": {"type": "array", "maxItems": 1, "items": {"type": "string"}}
+			}
+		}`),
+	})
+
+	cases := map[string]int{
+		`{"message":"hello"}`:                 0,
+		`{"images":[]}`:                       http.StatusBadRequest,
+		`{"message":""}`:                      http.StatusBadRequest,
+		`{"message":"hi","images":["a","b"]}`: http.StatusBadRequest,
+		`[1,2,3]`:                             http.StatusBadRequest,
+		`{"message":`:                         http.StatusBadRequest,
+	}
+	for body, expected := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/agent", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		rejection := enforceRequestBody(req, route)
+		if expected == 0 {
+			if rejection != nil {
+				t.Fatalf("expected %s to pass, got %+v", body, rejection)
+			}
+			forwarded, _ := io.ReadAll(req.Body)
+			if string(forwarded) != body {
+				t.Fatalf("expected body to be preserved, got %q", forwarded)
+			}
+			continue
+		}
+		if rejection == nil || rejection.code.Status() != expected {
+			t.Fatalf("expected %d for %s, got %+v", expected, body, rejection)
+		}
+	}
+}
+
+func TestLoadRoutesRejectsInvalidSchema(t *testing.T) {
+	pm := NewProxyManager(nil, nil)
+	err := pm.LoadRoutes([]RouteConfig{{Path: "/api/agent", RequestSchema: json.RawMessage(`{"type":1}`)}})
+	if err == nil {
+		t.Fatal("expected invalid schema to be rejected")
+	}
+}
@@ -1,11 +1,14 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/indulgeback/telos/pkg/tlog"
@@ -25,7 +28,7 @@ type ServiceDiscovery interface {
 }
 
 // RegistryServiceDiscovery 通过 registry 服务发现
-// 生产环境推荐使用
+// 生产环境推荐使用；每个服务通过 registry 的长轮询接口监听实例变化，定时轮询作为兜底
 
 type RegistryServiceDiscovery struct {
 	RegistryAddr string // registry服务地址，如 http://localhost:8080
@@ -35,21 +38,92 @@ type RegistryServiceDiscovery struct {
 	cacheLock    sync.RWMutex
 	refreshIntvl time.Duration
 	stopCh       chan struct{}
+	closeOnce    sync.Once
+
+	watchWait     time.Duration
+	watchClient   *http.Client
+	watchers      map[string]*watcher // 服务名 -> 长轮询监听
+	watchLock     sync.Mutex
+	watchDisabled atomic.Bool
+	// watchPausedUntil registry 不支持长轮询时暂停到该时间（UnixNano），之后重新探测
+	watchPausedUntil atomic.Int64
+	watchProbeAfter  time.Duration
+}
+
+// RegistryConfig 服务发现配置，数值为 0 时使用默认值
+type RegistryConfig struct {
+	// RefreshInterval 轮询所有服务的间隔，默认 10 秒；长轮询正常的服务不再轮询实例
+	RefreshInterval time.Duration
+	// WatchWait 每次长轮询的最长等待时间，默认 30 秒，负数表示不使用长轮询
+	WatchWait time.Duration
+	// WatchProbeAfter registry 不支持长轮询时暂停长轮询的时间，之后重新探测，默认 5 分钟
+	WatchProbeAfter time.Duration
 }
 
+// watcher 单个服务的长轮询，healthy 表示最近一次长轮询成功
+type watcher struct {
+	cancel  context.CancelFunc
+	healthy atomic.Bool
+}
+
+// 长轮询的默认等待时间与失败后的重试间隔
+const (
+	DefaultWatchWait       = 30 * time.Second
+	DefaultWatchProbeAfter = 5 * time.Minute
+	watchRetryMin          = time.Second
+	watchRetryMax          = 30 * time.Second
+)
+
+// errWatchUnsupported registry 没有提供长轮询接口（旧版本，或滚动发布、入口错误页等临时情况），暂时只使用轮询
+var errWatchUnsupported = errors.New("registry 不支持长轮询")
+
 func NewRegistryServiceDiscovery(registryAddr string, lb LoadBalancer) *RegistryServiceDiscovery {
+	return NewRegistryServiceDiscoveryWithConfig(registryAddr, lb, RegistryConfig{})
+}
+
+func NewRegistryServiceDiscoveryWithConfig(registryAddr string, lb LoadBalancer, cfg RegistryConfig) *RegistryServiceDiscovery {
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = 10 * time.Second // 默认10秒刷新一次
+	}
+	if cfg.WatchWait == 0 {
+		cfg.WatchWait = DefaultWatchWait
+	}
+	if cfg.WatchProbeAfter <= 0 {
+		cfg.WatchProbeAfter = DefaultWatchProbeAfter
+	}
 	rsd := &RegistryServiceDiscovery{
 		RegistryAddr: registryAddr,
 		LB:           lb,
 		cache:        make(map[string][]string),
-		refreshIntvl: 10 * time.Second, // 默认10秒刷新一次
+		refreshIntvl: cfg.RefreshInterval,
 		stopCh:       make(chan struct{}),
+		watchWait:    cfg.WatchWait,
+		// 超时需大于长轮询的等待时间，registry 会在等待时间上增加少量随机抖动
+		watchClient:     &http.Client{Timeout: cfg.WatchWait + 15*time.Second},
+		watchers:        make(map[string]*watcher),
+		watchProbeAfter: cfg.WatchProbeAfter,
+	}
+	if cfg.WatchWait < 0 {
+		rsd.watchDisabled.Store(true)
 	}
 	rsd.refreshAllServices()
 	go rsd.startAutoRefresh()
 	return rsd
 }
 
+// Close 停止轮询与所有长轮询
+func (r *RegistryServiceDiscovery) Close() {
+	r.closeOnce.Do(func() {
+		close(r.stopCh)
+		r.watchLock.Lock()
+		defer r.watchLock.Unlock()
+		for name, w := range r.watchers {
+			w.cancel()
+			delete(r.watchers, name)
+		}
+	})
+}
+
 func (r *RegistryServiceDiscovery) startAutoRefresh() {
 	ticker := time.NewTicker(r.refreshIntvl)
 	defer ticker.Stop()
@@ -82,27 +156,51 @@ func (r *RegistryServiceDiscovery) FetchAllServiceNames() []string {
 	return result.Services
 }
 
+// refreshAllServices 轮询服务列表，并发拉取没有正常长轮询的服务实例；网络请求期间不持有缓存锁
 func (r *RegistryServiceDiscovery) refreshAllServices() {
 	serviceNames := r.FetchAllServiceNames()
 	if len(serviceNames) == 0 {
 		serviceNames = []string{"agent-service"}
 	}
-	r.cacheLock.Lock()
-	defer r.cacheLock.Unlock()
 
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	fetched := make(map[string][]string, len(serviceNames))
 	activeServices := make(map[string]bool)
 	for _, name := range serviceNames {
 		activeServices[name] = true
-		instances := r.FetchInstances(name)
+		if r.watchHealthy(name) {
+			continue
+		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			instances := r.FetchInstances(name)
+			mu.Lock()
+			fetched[name] = instances
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	r.cacheLock.Lock()
+	for name, instances := range fetched {
+		// 轮询期间长轮询恢复正常时，缓存中已经是更新的结果；长轮询先标记正常再写缓存，
+		// 在缓存锁内检查可以保证不会用旧结果覆盖
+		if r.watchHealthy(name) {
+			continue
+		}
 		r.cache[name] = instances
 		tlog.Info("服务发现刷新", "service", name, "instances", instances, "count", len(instances))
 	}
-
 	for k := range r.cache {
 		if !activeServices[k] {
 			delete(r.cache, k)
 		}
 	}
+	r.cacheLock.Unlock()
+
+	r.syncWatchers(activeServices)
 }
 
 func (r *RegistryServiceDiscovery) InvalidateCache(serviceName string) {
@@ -113,6 +211,35 @@ func (r *RegistryServiceDiscovery) InvalidateCache(serviceName string) {
 	r.cacheLock.Unlock()
 }
 
+// registryInstances registry 返回的服务实例列表
+type registryInstances struct {
+	Services []struct {
+		ID      string            `json:"id"`
+		Name    string            `json:"name"`
+		Address string            `json:"address"`
+		Port    int               `json:"port"`
+		Tags    []string          `json:"tags"`
+		Meta    map[string]string `json:"meta"`
+		Status  string            `json:"status"`
+	} `json:"services"`
+}
+
+// addrs 健康实例的地址
+func (result *registryInstances) addrs() []string {
+	var addrs []string
+	for _, s := range result.Services {
+		if s.Status == "passing" || s.Status == "" {
+			addr := fmt.Sprintf("%s:%d", s.Address, s.Port)
+			// 元数据 scheme=https 的实例通过 TLS 连接，地址带上协议供代理识别
+			if strings.EqualFold(s.Meta["scheme"], "https") {
+				addr = "https://" + addr
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
 func (r *RegistryServiceDiscovery) FetchInstances(serviceName string) []string {
 	url := fmt.Sprintf("%s/api/service?name=%s", r.RegistryAddr, serviceName)
 	resp, err := http.Get(url)
@@ -120,27 +247,11 @@ func (r *RegistryServiceDiscovery) FetchInstances(serviceName string) []string {
 		return nil
 	}
 	defer resp.Body.Close()
-	var result struct {
-		Services []struct {
-			ID      string            `json:"id"`
-			Name    string            `json:"name"`
-			Address string            `json:"address"`
-			Port    int               `json:"port"`
-			Tags    []string          `json:"tags"`
-			Meta    map[string]string `json:"meta"`
-			Status  string            `json:"status"`
-		} `json:"services"`
-	}
+	var result registryInstances
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil
 	}
-	var addrs []string
-	for _, s := range result.Services {
-		if s.Status == "passing" || s.Status == "" {
-			addrs = append(addrs, fmt.Sprintf("%s:%d", s.Address, s.Port))
-		}
-	}
-	return addrs
+	return result.addrs()
 }
 
 func (r *RegistryServiceDiscovery) ListInstances(serviceName string) []string {
@@ -150,11 +261,12 @@ func (r *RegistryServiceDiscovery) ListInstances(serviceName string) []string {
 	if ok && len(instances) > 0 {
 		return instances
 	}
-	// 缓存没有则立即拉取一次
+	// 缓存没有则立即拉取一次，并开始监听该服务
 	instances = r.FetchInstances(serviceName)
 	r.cacheLock.Lock()
 	r.cache[serviceName] = instances
 	r.cacheLock.Unlock()
+	r.startWatch(serviceName)
 	return instances
 }
 
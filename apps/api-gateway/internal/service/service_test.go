@@ -0,0 +1,151 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRegistry 模拟 registry，watch 为 false 时不提供长轮询接口（旧版本）
+type fakeRegistry struct {
+	watch atomic.Bool
+
+	mu      sync.Mutex
+	index   uint64
+	port    int
+	changed chan struct{}
+}
+
+func (f *fakeRegistry) setPort(port int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.index++
+	f.port = port
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/services":
+		json.NewEncoder(w).Encode(map[string]any{"services": []string{"agent-service"}})
+		return
+	case "/api/service":
+	case "/api/service/watch":
+		if !f.watch.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		index, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		f.mu.Lock()
+		current, changed := f.index, f.changed
+		f.mu.Unlock()
+		if index == current {
+			wait, _ := time.ParseDuration(r.URL.Query().Get("wait"))
+			select {
+			case <-changed:
+			case <-time.After(wait):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set(headerRegistryIndex, strconv.FormatUint(f.index, 10))
+	json.NewEncoder(w).Encode(map[string]any{"services": []map[string]any{{
+		"name":    "agent-service",
+		"address": "10.0.0.1",
+		"port":    f.port,
+		"status":  "passing",
+	}}})
+}
+
+func waitForInstances(t *testing.T, discovery *RegistryServiceDiscovery, expected []string, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for time.Now().Before(deadline) {
+		if slices.Equal(discovery.ListInstances("agent-service"), expected) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected instances %v within %v, got %v", expected, within, discovery.ListInstances("agent-service"))
+}
+
+func TestRegistryWatchPropagatesInstanceChanges(t *testing.T) {
+	registry := &fakeRegistry{index: 1, port: 8001, changed: make(chan struct{})}
+	registry.watch.Store(true)
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	// 轮询间隔足够长，实例变化只能通过长轮询传播
+	discovery := NewRegistryServiceDiscoveryWithConfig(server.URL, NewRoundRobinLoadBalancer(), RegistryConfig{
+		RefreshInterval: time.Hour,
+		WatchWait:       5 * time.Second,
+	})
+	defer discovery.Close()
+	waitForInstances(t, discovery, []string{"10.0.0.1:8001"}, time.Second)
+
+	registry.setPort(8002)
+	waitForInstances(t, discovery, []string{"10.0.0.1:8002"}, time.Second)
+	if !discovery.watchHealthy("agent-service") {
+		t.Fatal("expected watch to be healthy")
+	}
+}
+
+func TestRegistryWatchFallsBackToPolling(t *testing.T) {
+	registry := &fakeRegistry{index: 1, port: 8001, changed: make(chan struct{})}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	discovery := NewRegistryServiceDiscoveryWithConfig(server.URL, NewRoundRobinLoadBalancer(), RegistryConfig{
+		RefreshInterval: 50 * time.Millisecond,
+	})
+	defer discovery.Close()
+
+	registry.setPort(8002)
+	waitForInstances(t, discovery, []string{"10.0.0.1:8002"}, 2*time.Second)
+	if discovery.watchPausedUntil.Load() <= time.Now().UnixNano() {
+		t.Fatal("expected watch to be paused for a registry without the watch endpoint")
+	}
+}
+
+func TestRegistryWatchIsProbedAgainAfterPause(t *testing.T) {
+	// 滚动发布期间 registry 暂时返回 404，之后恢复长轮询接口
+	registry := &fakeRegistry{index: 1, port: 8001, changed: make(chan struct{})}
+	server := httptest.NewServer(registry)
+	defer server.Close()
+
+	discovery := NewRegistryServiceDiscoveryWithConfig(server.URL, NewRoundRobinLoadBalancer(), RegistryConfig{
+		RefreshInterval: 50 * time.Millisecond,
+		WatchWait:       5 * time.Second,
+		WatchProbeAfter: 200 * time.Millisecond,
+	})
+	defer discovery.Close()
+	deadline := time.Now().Add(time.Second)
+	for discovery.watchPausedUntil.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if discovery.watchPausedUntil.Load() == 0 {
+		t.Fatal("expected watch to be paused after a 404")
+	}
+
+	registry.watch.Store(true)
+	deadline = time.Now().Add(2 * time.Second)
+	for !discovery.watchHealthy("agent-service") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected watch to be probed again and become healthy")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// headerRegistryIndex registry 长轮询响应中的索引，下一次请求通过 index 参数带回
+const headerRegistryIndex = "X-Registry-Index"
+
+// watchHealthy 服务的长轮询是否正常，正常时轮询不再拉取该服务的实例
+func (r *RegistryServiceDiscovery) watchHealthy(serviceName string) bool {
+	r.watchLock.Lock()
+	defer r.watchLock.Unlock()
+	w, ok := r.watchers[serviceName]
+	return ok && w.healthy.Load()
+}
+
+// syncWatchers 为 registry 中的服务启动长轮询，停止已下线服务的长轮询
+func (r *RegistryServiceDiscovery) syncWatchers(activeServices map[string]bool) {
+	for name := range activeServices {
+		r.startWatch(name)
+	}
+	r.watchLock.Lock()
+	defer r.watchLock.Unlock()
+	for name, w := range r.watchers {
+		if !activeServices[name] {
+			w.cancel()
+			delete(r.watchers, name)
+		}
+	}
+}
+
+func (r *RegistryServiceDiscovery) startWatch(serviceName string) {
+	if r.watchDisabled.Load() || time.Now().UnixNano() < r.watchPausedUntil.Load() {
+		return
+	}
+	r.watchLock.Lock()
+	defer r.watchLock.Unlock()
+	if _, ok := r.watchers[serviceName]; ok {
+		return
+	}
+	select {
+	case <-r.stopCh:
+		return
+	default:
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &watcher{cancel: cancel}
+	r.watchers[serviceName] = w
+	go r.watchService(ctx, serviceName, w)
+}
+
+// watchService 持续长轮询单个服务，实例变化时立即更新缓存；失败时按指数退避重试，期间由轮询兜底
+func (r *RegistryServiceDiscovery) watchService(ctx context.Context, serviceName string, w *watcher) {
+	var index uint64
+	retry := watchRetryMin
+	for ctx.Err() == nil {
+		started := time.Now()
+		instances, next, err := r.watchInstances(ctx, serviceName, index)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, errWatchUnsupported):
+			// 旧版 registry 或临时的 404：暂停所有长轮询，期间只使用轮询，之后由轮询重新探测
+			tlog.Warn("registry 不支持长轮询，暂时使用定时轮询发现服务",
+				"service", serviceName, "error", err, "probe_after", r.watchProbeAfter)
+			r.pauseWatch()
+			return
+		case err != nil:
+			w.healthy.Store(false)
+			tlog.Warn("监听服务实例失败，稍后重试", "service", serviceName, "error", err, "retry", retry)
+			index = 0
+			if !sleepContext(ctx, retry) {
+				return
+			}
+			retry = min(retry*2, watchRetryMax)
+			continue
+		}
+
+		retry = watchRetryMin
+		w.healthy.Store(true)
+		// index 回退（如 Consul 重建）时从头开始监听
+		if next < index {
+			next = 0
+		}
+		unchanged := next == index
+		index = next
+		if !r.updateInstances(serviceName, instances) && unchanged && time.Since(started) < watchRetryMin {
+			// registry 提前返回且没有变化，避免紧密循环
+			if !sleepContext(ctx, watchRetryMin) {
+				return
+			}
+		}
+	}
+}
+
+// watchInstances 请求 registry 的长轮询接口，返回实例地址与新的 index
+func (r *RegistryServiceDiscovery) watchInstances(ctx context.Context, serviceName string, index uint64) ([]string, uint64, error) {
+	query := url.Values{}
+	query.Set("name", serviceName)
+	query.Set("index", strconv.FormatUint(index, 10))
+	query.Set("wait", r.watchWait.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.RegistryAddr+"/api/service/watch?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := r.watchClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, 0, fmt.Errorf("%w: status %d", errWatchUnsupported, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	header := resp.Header.Get(headerRegistryIndex)
+	if header == "" {
+		return nil, 0, fmt.Errorf("%w: 缺少 %s", errWatchUnsupported, headerRegistryIndex)
+	}
+	next, err := strconv.ParseUint(header, 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("无效的 %s: %q", headerRegistryIndex, header)
+	}
+	var result registryInstances
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, 0, err
+	}
+	return result.addrs(), next, nil
+}
+
+// updateInstances 更新缓存，返回实例是否发生变化
+func (r *RegistryServiceDiscovery) updateInstances(serviceName string, instances []string) bool {
+	r.cacheLock.Lock()
+	previous, ok := r.cache[serviceName]
+	r.cache[serviceName] = instances
+	r.cacheLock.Unlock()
+	if ok && slices.Equal(previous, instances) {
+		return false
+	}
+	tlog.Info("服务实例变化", "service", serviceName, "instances", instances, "count", len(instances))
+	return true
+}
+
+// pauseWatch 在 watchProbeAfter 内停止所有长轮询，期间由轮询拉取实例
+func (r *RegistryServiceDiscovery) pauseWatch() {
+	r.watchPausedUntil.Store(time.Now().Add(r.watchProbeAfter).UnixNano())
+	r.watchLock.Lock()
+	defer r.watchLock.Unlock()
+	for name, w := range r.watchers {
+		w.cancel()
+		delete(r.watchers, name)
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,127 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/indulgeback/telos/pkg/tlog"
+)
+
+// certFileCheckInterval 证书文件的检查间隔，文件替换后在下一次握手时重新加载
+const certFileCheckInterval = 10 * time.Second
+
+// CertReloader 从 PEM 文件加载证书和私钥，证书续期（cert-manager、certbot 等替换文件）后无需重启
+// 同时可作为服务端证书（GetCertificate）和 mTLS 客户端证书（GetClientCertificate）使用
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	cert        *tls.Certificate
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+// NewCertReloader 加载证书和私钥，文件不存在或不匹配时返回错误
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("证书文件和私钥文件必须同时配置")
+	}
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate 用作 tls.Config.GetCertificate
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current(), nil
+}
+
+// GetClientCertificate 用作 tls.Config.GetClientCertificate
+func (c *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.current(), nil
+}
+
+func (c *CertReloader) current() *tls.Certificate {
+	c.reloadIfChanged()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert
+}
+
+func (c *CertReloader) reloadIfChanged() {
+	c.mu.RLock()
+	due := time.Since(c.lastChecked) >= certFileCheckInterval
+	c.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	modTime, err := c.latestModTime()
+	c.mu.Lock()
+	c.lastChecked = time.Now()
+	unchanged := err != nil || modTime.Equal(c.modTime)
+	c.mu.Unlock()
+	if unchanged {
+		return
+	}
+	// 证书和私钥通常不是原子替换的，加载失败时保留旧证书，下一次检查时重试
+	if err := c.reload(); err != nil {
+		tlog.Error("重新加载 TLS 证书失败", "cert", c.certFile, "error", err)
+		return
+	}
+	tlog.Info("TLS 证书已重新加载", "cert", c.certFile)
+}
+
+func (c *CertReloader) reload() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return fmt.Errorf("读取 TLS 证书失败: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载 TLS 证书失败: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = modTime
+	c.lastChecked = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// latestModTime 证书和私钥中较新的修改时间，任一文件变化都触发重新加载
+func (c *CertReloader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+// LoadCertPool 从 PEM 格式的 CA 证书包创建证书池
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA 证书文件 %s 中没有有效的 PEM 证书", path)
+	}
+	return pool, nil
+}
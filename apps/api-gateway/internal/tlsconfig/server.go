@@ -0,0 +1,86 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ServerOptions 网关对客户端提供 HTTPS 的配置
+// 证书文件与 ACME 可同时配置：ACME 域名使用自动签发的证书，其余 SNI 使用证书文件
+type ServerOptions struct {
+	CertFile string
+	KeyFile  string
+
+	// ACMEDomains 通过 ACME 自动签发证书的域名，使用 TLS-ALPN-01 验证，网关需直接暴露在 443 端口
+	ACMEDomains []string
+	// ACMEDirectoryURL ACME 目录地址，为空时使用 Let's Encrypt，也可指向 step-ca 等内部 CA
+	ACMEDirectoryURL string
+	ACMEEmail        string
+	// ACMECacheDir 签发的证书与账户密钥的保存目录，重启后复用，避免触发 CA 的频率限制
+	ACMECacheDir string
+}
+
+// Enabled 是否配置了 HTTPS
+func (o ServerOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != "" || len(o.ACMEDomains) > 0
+}
+
+// NewServerConfig 创建服务端 TLS 配置，协商 HTTP/2 并回退到 HTTP/1.1
+func NewServerConfig(opts ServerOptions) (*tls.Config, error) {
+	var static *CertReloader
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		reloader, err := NewCertReloader(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		static = reloader
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	var domains []string
+	for _, domain := range opts.ACMEDomains {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		if static == nil {
+			return nil, fmt.Errorf("未配置 TLS 证书")
+		}
+		config.GetCertificate = static.GetCertificate
+		return config, nil
+	}
+
+	if opts.ACMECacheDir == "" {
+		return nil, fmt.Errorf("启用 ACME 时必须配置证书缓存目录")
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(opts.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      opts.ACMEEmail,
+	}
+	if opts.ACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: opts.ACMEDirectoryURL}
+	}
+	config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		// TLS-ALPN-01 验证握手和 ACME 域名交给 autocert，其余使用证书文件
+		if static == nil ||
+			slices.Contains(hello.SupportedProtos, acme.ALPNProto) ||
+			slices.Contains(domains, strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))) {
+			return manager.GetCertificate(hello)
+		}
+		return static.GetCertificate(hello)
+	}
+	return config, nil
+}
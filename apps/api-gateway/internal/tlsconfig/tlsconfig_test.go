@@ -0,0 +1,162 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReloaderPicksUpRenewedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeSelfSigned(t, certFile, keyFile, "first.example.com")
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader: %v", err)
+	}
+	if name := leafName(t, reloader); name != "first.example.com" {
+		t.Fatalf("unexpected initial certificate %q", name)
+	}
+
+	writeSelfSigned(t, certFile, keyFile, "renewed.example.com")
+	future := time.Now().Add(time.Minute)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reloader.mu.Lock()
+	reloader.lastChecked = time.Time{}
+	reloader.mu.Unlock()
+	if name := leafName(t, reloader); name != "renewed.example.com" {
+		t.Fatalf("expected renewed certificate, got %q", name)
+	}
+
+	// 只替换了证书、私钥还没写入时，继续使用旧证书
+	writeSelfSigned(t, certFile, filepath.Join(dir, "other.key"), "half-written.example.com")
+	later := future.Add(time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloader.mu.Lock()
+	reloader.lastChecked = time.Time{}
+	reloader.mu.Unlock()
+	if name := leafName(t, reloader); name != "renewed.example.com" {
+		t.Fatalf("expected previous certificate to be kept, got %q", name)
+	}
+}
+
+func TestServerConfigNegotiatesHTTP2(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeSelfSigned(t, certFile, keyFile, "localhost")
+
+	config, err := NewServerConfig(ServerOptions{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("NewServerConfig: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		TLSConfig: config,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+	}
+	go server.Serve(tls.NewListener(listener, config))
+	defer server.Close()
+
+	pool, err := LoadCertPool(certFile)
+	if err != nil {
+		t.Fatalf("LoadCertPool: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool, ServerName: "localhost"},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + listener.Addr().String() + "/")
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("expected HTTP/2, got %s", resp.Proto)
+	}
+}
+
+func TestNewServerConfigRequiresCacheDirForACME(t *testing.T) {
+	if _, err := NewServerConfig(ServerOptions{ACMEDomains: []string{"gateway.example.com"}}); err == nil {
+		t.Fatal("expected missing ACME cache directory to be rejected")
+	}
+	config, err := NewServerConfig(ServerOptions{
+		ACMEDomains:  []string{"gateway.example.com"},
+		ACMECacheDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("NewServerConfig: %v", err)
+	}
+	if config.NextProtos[0] != "h2" || config.NextProtos[len(config.NextProtos)-1] != "acme-tls/1" {
+		t.Fatalf("unexpected NextProtos %v", config.NextProtos)
+	}
+}
+
+func leafName(t *testing.T, reloader *CertReloader) string {
+	t.Helper()
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+// writeSelfSigned 生成自签名证书，可同时作为 CA 证书包使用
+func writeSelfSigned(t *testing.T, certFile, keyFile, name string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// DefaultUpstream 上游 TLS 配置文件中适用于所有服务的键，服务自己的配置优先
+const DefaultUpstream = "*"
+
+// UpstreamOptions 网关连接单个后端服务时使用的 TLS 配置
+// 只对注册中心元数据 scheme=https 的实例生效，明文实例不受影响
+type UpstreamOptions struct {
+	// CAFile 校验后端证书的 CA 证书包，为空时使用系统根证书
+	CAFile string `json:"caFile"`
+	// CertFile、KeyFile 网关的客户端证书，后端要求 mTLS 时配置，文件替换后自动重新加载
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// ServerName SNI 与证书校验使用的主机名，为空时使用实例地址；实例按 IP 注册时通常需要配置
+	ServerName string `json:"serverName"`
+	// InsecureSkipVerify 跳过后端证书校验，仅用于本地调试
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// LoadUpstreamFile 读取按服务名组织的上游 TLS 配置，如 {"*": {...}, "agent-service": {...}}
+func LoadUpstreamFile(path string) (map[string]UpstreamOptions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取上游 TLS 配置失败: %w", err)
+	}
+	var options map[string]UpstreamOptions
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, fmt.Errorf("解析上游 TLS 配置失败: %w", err)
+	}
+	return options, nil
+}
+
+// NewUpstreamConfig 创建连接后端使用的客户端 TLS 配置
+func NewUpstreamConfig(opts UpstreamOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		pool, err := LoadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		reloader, err := NewCertReloader(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return config, nil
+}
+
+// NewUpstreamConfigs 为每个服务创建客户端 TLS 配置
+func NewUpstreamConfigs(options map[string]UpstreamOptions) (map[string]*tls.Config, error) {
+	configs := make(map[string]*tls.Config, len(options))
+	for service, opts := range options {
+		config, err := NewUpstreamConfig(opts)
+		if err != nil {
+			return nil, fmt.Errorf("服务 %s 的上游 TLS 配置无效: %w", service, err)
+		}
+		configs[service] = config
+	}
+	return configs, nil
+}
@@ -25,6 +25,7 @@ func main() {
 	apiGroup.DELETE("/unregister/:id", h.UnregisterService)
 	apiGroup.GET("/services", h.ListServiceNames)
 	apiGroup.GET("/service", h.ListServiceInstances)
+	apiGroup.GET("/service/watch", h.WatchServiceInstances)
 	apiGroup.GET("/health", h.HealthCheck)
 	apiGroup.GET("/stats", h.GetServiceStats)
 
@@ -1,14 +1,27 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/indulgeback/telos/apps/registry/internal/service"
 
 	"github.com/labstack/echo/v4"
 )
 
+// 长轮询的默认与最大等待时间
+const (
+	defaultWatchWait = 30 * time.Second
+	maxWatchWait     = 5 * time.Minute
+)
+
+// HeaderRegistryIndex 长轮询响应中的索引，下一次请求通过 index 参数带回
+const HeaderRegistryIndex = "X-Registry-Index"
+
 // RegistryHandler 注册中心 HTTP 处理器
 type RegistryHandler struct {
 	discovery service.ServiceDiscoveryInterface
@@ -164,3 +177,52 @@ func (h *RegistryHandler) ListServiceInstances(c echo.Context) error {
 		"count":    len(instances),
 	})
 }
+
+// WatchServiceInstances 长轮询服务实例：带上次返回的 index 请求，实例变化或等待 wait（默认 30s，最长 5m）超时后返回，
+// 不带 index 时立即返回当前实例
+func (h *RegistryHandler) WatchServiceInstances(c echo.Context) error {
+	serviceName := c.QueryParam("name")
+	if serviceName == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "服务名称不能为空",
+		})
+	}
+	var index uint64
+	if value := c.QueryParam("index"); value != "" {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "index 参数无效",
+			})
+		}
+		index = parsed
+	}
+	wait := defaultWatchWait
+	if value := c.QueryParam("wait"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "wait 参数无效",
+			})
+		}
+		wait = min(parsed, maxWatchWait)
+	}
+
+	ctx := c.Request().Context()
+	instances, next, err := h.discovery.WatchInstances(ctx, serviceName, index, wait)
+	if err != nil {
+		// 客户端已断开，无需响应
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	c.Response().Header().Set(HeaderRegistryIndex, strconv.FormatUint(next, 10))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"services": instances,
+		"count":    len(instances),
+		"index":    next,
+	})
+}
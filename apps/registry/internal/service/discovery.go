@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/indulgeback/telos/apps/registry/internal/config"
 
@@ -95,7 +97,21 @@ func (c *ConsulServiceDiscovery) ListInstances(serviceName string) ([]*ServiceIn
 	if err != nil {
 		return nil, fmt.Errorf("获取服务实例失败: %v", err)
 	}
+	return toServiceInfos(services), nil
+}
+
+// WatchInstances 基于 Consul 阻塞查询监听服务实例，index 为 0 时立即返回当前实例
+func (c *ConsulServiceDiscovery) WatchInstances(ctx context.Context, serviceName string, index uint64, wait time.Duration) ([]*ServiceInfo, uint64, error) {
+	options := (&consulapi.QueryOptions{WaitIndex: index, WaitTime: wait}).WithContext(ctx)
+	services, meta, err := c.client.Health().Service(serviceName, "", true, options)
+	if err != nil {
+		return nil, 0, fmt.Errorf("监听服务实例失败: %v", err)
+	}
+	// Consul 建议 index 不小于 1，否则下一次查询会立即返回
+	return toServiceInfos(services), max(meta.LastIndex, 1), nil
+}
 
+func toServiceInfos(services []*consulapi.ServiceEntry) []*ServiceInfo {
 	var instances []*ServiceInfo
 	for _, service := range services {
 		instance := &ServiceInfo{
@@ -109,8 +125,7 @@ func (c *ConsulServiceDiscovery) ListInstances(serviceName string) ([]*ServiceIn
 		}
 		instances = append(instances, instance)
 	}
-
-	return instances, nil
+	return instances
 }
 
 // ListServiceNames 获取所有服务名
@@ -1,9 +1,16 @@
 package service
 
+import (
+	"context"
+	"time"
+)
+
 // ServiceDiscoveryInterface 服务发现接口
 type ServiceDiscoveryInterface interface {
 	Register(service *ServiceInfo) error
 	Unregister(serviceID string) error
 	ListInstances(serviceName string) ([]*ServiceInfo, error)
 	ListServiceNames() ([]string, error)
+	// WatchInstances 阻塞直到服务的健康实例相对 index 发生变化或等待 wait 超时，返回最新实例与新的 index
+	WatchInstances(ctx context.Context, serviceName string, index uint64, wait time.Duration) ([]*ServiceInfo, uint64, error)
 }
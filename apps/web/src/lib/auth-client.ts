@@ -1,5 +1,6 @@
 import { createAuthClient } from 'better-auth/react'
 import {
+  adminClient,
   inferAdditionalFields,
   magicLinkClient,
 } from 'better-auth/client/plugins'
@@ -7,5 +8,9 @@ import type { auth } from './auth'
 
 export const authClient = createAuthClient({
   baseURL: process.env.NEXT_PUBLIC_BETTER_AUTH_URL || 'http://localhost:8800',
-  plugins: [inferAdditionalFields<typeof auth>(), magicLinkClient()],
+  plugins: [
+    inferAdditionalFields<typeof auth>(),
+    adminClient(),
+    magicLinkClient(),
+  ],
 })
@@ -1,5 +1,6 @@
 import { betterAuth } from 'better-auth'
 import { prismaAdapter } from 'better-auth/adapters/prisma'
+import { admin } from 'better-auth/plugins/admin'
 import { magicLink } from 'better-auth/plugins/magic-link'
 import { prisma } from './db'
 
@@ -52,6 +53,31 @@ if (process.env.SMTP_HOST && process.env.SMTP_USER && process.env.SMTP_PASS) {
     })
 }
 
+// 3. 会话删除（登出/过期清理）后通知 API 网关吊销缓存，使登出立即在所有网关副本生效
+async function revokeGatewaySession(session: { id: string; userId: string }) {
+  const gatewayURL = process.env.GATEWAY_ADMIN_URL
+  const adminToken = process.env.GATEWAY_ADMIN_TOKEN
+  if (!gatewayURL || !adminToken) return
+
+  try {
+    const res = await fetch(`${gatewayURL.replace(/\/$/, '')}/_gateway/sessions/revoke`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+        'X-Gateway-Admin-Token': adminToken,
+      },
+      body: JSON.stringify({ sessionId: session.id }),
+      signal: AbortSignal.timeout(3000),
+    })
+    if (!res.ok) {
+      console.error('[Gateway] 吊销会话缓存失败:', res.status)
+    }
+  } catch (err) {
+    // 网关不可用时依赖认证缓存 TTL 兜底，不阻塞登出
+    console.error('[Gateway] 吊销会话缓存失败:', err)
+  }
+}
+
 export const auth = betterAuth({
   baseURL: process.env.BETTER_AUTH_URL || 'http://localhost:8800',
   basePath: '/api/auth',
@@ -74,6 +100,11 @@ export const auth = betterAuth({
   },
   socialProviders,
   plugins: [
+    // 用户角色（user.role）随会话返回，API 网关据此限制管理类写操作（如 /api/mcp-servers）
+    admin({
+      defaultRole: 'user',
+      adminRoles: ['admin'],
+    }),
     magicLink({
       sendMagicLink: async ({ email, url }) => {
         const hasEmailProvider = Boolean(
@@ -143,6 +174,15 @@ export const auth = betterAuth({
       },
     }),
   ],
+  databaseHooks: {
+    session: {
+      delete: {
+        after: async session => {
+          await revokeGatewaySession(session)
+        },
+      },
+    },
+  },
   session: {
     expiresIn: 60 * 60 * 24 * 7, // 7天
     updateAge: 60 * 60 * 24,
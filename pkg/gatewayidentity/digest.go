@@ -0,0 +1,77 @@
+package gatewayidentity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var ErrBodyTooLarge = errors.New("gatewayidentity: request body too large to verify")
+
+// ContentDigest 按 RFC 9530 格式化 SHA-256 摘要
+func ContentDigest(sum []byte) string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum) + ":"
+}
+
+// DigestHash 返回用于计算 Content-Digest 的哈希，可配合 io.TeeReader 边读边算
+func DigestHash() hash.Hash {
+	return sha256.New()
+}
+
+// DigestBytes 计算一段数据的 Content-Digest
+func DigestBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return ContentDigest(sum[:])
+}
+
+// CanonicalQuery 规范化查询串：按键、值排序，并用 url.QueryEscape 重新编码
+// 这样后端框架对查询串的重新编码不会影响签名
+func CanonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	values, _ := url.ParseQuery(rawQuery)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(values))
+	for _, key := range keys {
+		vals := append([]string(nil), values[key]...)
+		sort.Strings(vals)
+		for _, val := range vals {
+			parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(val))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// digestAndRestoreBody 读取请求体计算摘要，并把请求体还原给后续处理器
+func digestAndRestoreBody(r *http.Request, limit int64) (string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return DigestBytes(nil), nil
+	}
+	reader := io.Reader(r.Body)
+	if limit > 0 {
+		reader = io.LimitReader(r.Body, limit+1)
+	}
+	data, err := io.ReadAll(reader)
+	_ = r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return "", ErrBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	return DigestBytes(data), nil
+}
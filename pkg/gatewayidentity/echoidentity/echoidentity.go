@@ -0,0 +1,22 @@
+// Package echoidentity 提供 gatewayidentity 的 Echo 中间件，单独成包以免其他使用者引入 Echo 依赖
+package echoidentity
+
+import (
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware 返回 Echo 中间件，用户 ID 同时写入 c.Get(gatewayidentity.ContextKeyUserID) 和请求 context
+func Middleware(v *gatewayidentity.Verifier) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, err := v.VerifyRequest(c.Request())
+			if err != nil {
+				return c.JSON(gatewayidentity.ErrorResponse(err))
+			}
+			c.Set(gatewayidentity.ContextKeyUserID, userID)
+			c.SetRequest(c.Request().WithContext(gatewayidentity.WithUserID(c.Request().Context(), userID)))
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package echoidentity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
+	"github.com/labstack/echo/v4"
+)
+
+func signedRequest(nonce string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/api/agents", nil)
+	req.Header.Set(gatewayidentity.HeaderUserID, "user-1")
+	req.Header.Set(gatewayidentity.HeaderTimestamp, timestamp)
+	req.Header.Set(gatewayidentity.HeaderNonce, nonce)
+	req.Header.Set(gatewayidentity.HeaderSignature, gatewayidentity.Sign("secret", http.MethodPost, "/api/agents", "user-1", timestamp, nonce))
+	return req
+}
+
+func TestMiddlewareExposesVerifiedUserAndRejectsReplay(t *testing.T) {
+	e := echo.New()
+	e.Use(Middleware(gatewayidentity.NewVerifier("secret", time.Minute)))
+	e.POST("/api/agents", func(c echo.Context) error {
+		userID, _ := gatewayidentity.UserIDFromContext(c.Request().Context())
+		return c.String(http.StatusOK, c.Get(gatewayidentity.ContextKeyUserID).(string)+" "+userID)
+	})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, signedRequest("nonce-1"))
+	if rec.Code != http.StatusOK || rec.Body.String() != "user-1 user-1" {
+		t.Fatalf("expected verified user-1, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, signedRequest("nonce-1"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed nonce to be rejected, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/agents", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unsigned request to be rejected, got %d", rec.Code)
+	}
+}
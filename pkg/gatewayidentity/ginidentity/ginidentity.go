@@ -0,0 +1,21 @@
+// Package ginidentity 提供 gatewayidentity 的 Gin 中间件，单独成包以免其他使用者引入 Gin 依赖
+package ginidentity
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
+)
+
+// Middleware 返回 Gin 中间件，用户 ID 同时写入 c.Get(gatewayidentity.ContextKeyUserID) 和请求 context
+func Middleware(v *gatewayidentity.Verifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := v.VerifyRequest(c.Request)
+		if err != nil {
+			c.AbortWithStatusJSON(gatewayidentity.ErrorResponse(err))
+			return
+		}
+		c.Set(gatewayidentity.ContextKeyUserID, userID)
+		c.Request = c.Request.WithContext(gatewayidentity.WithUserID(c.Request.Context(), userID))
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package ginidentity
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/indulgeback/telos/pkg/gatewayidentity"
+)
+
+func signedRequest(nonce string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/api/agents", nil)
+	req.Header.Set(gatewayidentity.HeaderUserID, "user-1")
+	req.Header.Set(gatewayidentity.HeaderTimestamp, timestamp)
+	req.Header.Set(gatewayidentity.HeaderNonce, nonce)
+	req.Header.Set(gatewayidentity.HeaderSignature, gatewayidentity.Sign("secret", http.MethodPost, "/api/agents", "user-1", timestamp, nonce))
+	return req
+}
+
+func TestMiddlewareExposesVerifiedUserAndRejectsReplay(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	g := gin.New()
+	g.Use(Middleware(gatewayidentity.NewVerifier("secret", time.Minute)))
+	g.POST("/api/agents", func(c *gin.Context) {
+		userID, _ := gatewayidentity.UserIDFromContext(c.Request.Context())
+		c.String(http.StatusOK, c.GetString(gatewayidentity.ContextKeyUserID)+" "+userID)
+	})
+
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, signedRequest("nonce-1"))
+	if rec.Code != http.StatusOK || rec.Body.String() != "user-1 user-1" {
+		t.Fatalf("expected verified user-1, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, signedRequest("nonce-1"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed nonce to be rejected, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/agents", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unsigned request to be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/indulgeback/telos/pkg/gatewayidentity
+
+go 1.24.4
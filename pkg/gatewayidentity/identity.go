@@ -0,0 +1,455 @@
+// Package gatewayidentity 提供 API 网关身份头的签名与校验
+//
+// 网关认证用户后会向后端注入以下请求头：
+//
+//	X-User-ID            用户 ID
+//	X-Gateway-Timestamp  签名时间（Unix 秒）
+//	X-Gateway-Nonce      随机数，用于防重放
+//	X-Gateway-Key-ID     签名使用的密钥 ID（v2 起）
+//	X-Gateway-Signature  签名，v2 起带版本前缀，如 "v2=<hex>"
+//	Content-Digest       请求体的 SHA-256 摘要（v3），格式 "sha-256=:<base64>:"
+//	X-Gateway-Signed-Headers  额外参与签名的身份声明头（v2 起），如 "x-user-email;x-user-roles"
+//
+// 签名版本：
+//
+//	v1  HMAC-SHA256(secret, method\npath\nuserID\ntimestamp\nnonce)，不带前缀
+//	v2  HMAC-SHA256(secret, "v2"\nkeyID\nmethod\npath\nuserID\ntimestamp\nnonce)
+//	v3  HMAC-SHA256(secret, "v3"\nkeyID\nmethod\npath\nquery\nuserID\ntimestamp\nnonce\ndigest)
+//	    query 为 CanonicalQuery 规范化后的查询串，digest 为 Content-Digest 的值
+//
+// v2、v3 存在签名头时，payload 末尾按头名排序追加 "name:value" 行（头名小写）
+//
+// 后端服务使用 Verifier 或对应框架的中间件校验这些头，而不必各自重新实现
+package gatewayidentity
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderUserID    = "X-User-ID"
+	HeaderTimestamp = "X-Gateway-Timestamp"
+	HeaderNonce     = "X-Gateway-Nonce"
+	HeaderSignature = "X-Gateway-Signature"
+	HeaderKeyID     = "X-Gateway-Key-ID"
+	// HeaderSignedHeaders 列出额外参与签名的请求头，以 ";" 分隔
+	HeaderSignedHeaders = "X-Gateway-Signed-Headers"
+	// HeaderContentDigest 遵循 RFC 9530
+	HeaderContentDigest = "Content-Digest"
+)
+
+// 签名版本
+const (
+	VersionLegacy = "v1"
+	VersionKeyed  = "v2"
+	VersionDigest = "v3"
+)
+
+// DefaultClockSkew 与网关 AUTH_CLOCK_SKEW_SECONDS 的默认值保持一致
+const DefaultClockSkew = 300 * time.Second
+
+var (
+	ErrMissingHeaders   = errors.New("gatewayidentity: missing identity headers")
+	ErrInvalidTimestamp = errors.New("gatewayidentity: invalid timestamp")
+	ErrExpired          = errors.New("gatewayidentity: timestamp outside allowed window")
+	ErrInvalidSignature = errors.New("gatewayidentity: invalid signature")
+	ErrReplayedNonce    = errors.New("gatewayidentity: nonce already used")
+	ErrDigestMismatch   = errors.New("gatewayidentity: content digest mismatch")
+	ErrVersionTooOld    = errors.New("gatewayidentity: signature version not accepted")
+)
+
+// Payload 参与签名的请求信息，Query 与 ContentDigest 仅在 v3 中参与签名
+// Headers 为额外签名的身份声明头（如邮箱、角色），v1 不支持
+type Payload struct {
+	Method        string
+	Path          string
+	Query         string
+	UserID        string
+	Timestamp     string
+	Nonce         string
+	ContentDigest string
+	Headers       map[string]string
+}
+
+func (p Payload) canonical(version string, keyID string) string {
+	var fields []string
+	switch version {
+	case VersionKeyed:
+		fields = []string{VersionKeyed, keyID, p.Method, p.Path, p.UserID, p.Timestamp, p.Nonce}
+	case VersionDigest:
+		fields = []string{VersionDigest, keyID, p.Method, p.Path, p.Query, p.UserID, p.Timestamp, p.Nonce, p.ContentDigest}
+	default:
+		return strings.Join([]string{p.Method, p.Path, p.UserID, p.Timestamp, p.Nonce}, "\n")
+	}
+	for _, name := range signedHeaderNames(p.Headers) {
+		fields = append(fields, name+":"+strings.TrimSpace(p.Headers[name]))
+	}
+	return strings.Join(fields, "\n")
+}
+
+// signedHeaderNames 返回排序后的小写头名
+func signedHeaderNames(headers map[string]string) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func normalizeHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	normalized := make(map[string]string, len(headers))
+	for name, value := range headers {
+		normalized[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return normalized
+}
+
+// Sign 计算 v1 签名，保留给尚未支持密钥 ID 的调用方
+func Sign(secret string, method string, path string, userID string, timestamp string, nonce string) string {
+	payload := Payload{Method: method, Path: path, UserID: userID, Timestamp: timestamp, Nonce: nonce}
+	return computeMAC(secret, payload.canonical(VersionLegacy, ""))
+}
+
+// SignPayload 使用指定版本和密钥签名，v1 返回不带前缀的十六进制，其余版本带 "<version>=" 前缀
+func SignPayload(version string, key Key, payload Payload) string {
+	mac := computeMAC(key.Secret, payload.canonical(version, key.ID))
+	if version == VersionLegacy || version == "" {
+		return mac
+	}
+	return version + "=" + mac
+}
+
+// Verify 校验 v1 签名与时间窗口，不做 nonce 防重放检查
+// skew <= 0 时使用 DefaultClockSkew
+func Verify(secret string, method string, path string, userID string, timestamp string, nonce string, signature string, now time.Time, skew time.Duration) error {
+	keys, err := NewKeyring(DefaultKeyID, Key{ID: DefaultKeyID, Secret: secret})
+	if err != nil {
+		return err
+	}
+	payload := Payload{Method: method, Path: path, UserID: userID, Timestamp: timestamp, Nonce: nonce}
+	return VerifyPayload(keys, "", payload, signature, now, skew)
+}
+
+// VerifyPayload 校验任意版本的签名与时间窗口
+// v2 签名按 keyID 查找密钥；不带前缀的 v1 签名没有密钥 ID，会依次尝试所有有效密钥
+func VerifyPayload(keys *Keyring, keyID string, payload Payload, signature string, now time.Time, skew time.Duration) error {
+	if payload.UserID == "" || payload.Timestamp == "" || payload.Nonce == "" || signature == "" {
+		return ErrMissingHeaders
+	}
+	if err := checkTimestamp(payload.Timestamp, now, skew); err != nil {
+		return err
+	}
+
+	version, mac := parseSignature(signature)
+	switch version {
+	case VersionLegacy:
+		if len(payload.Headers) > 0 {
+			return ErrInvalidSignature
+		}
+		for _, key := range keys.Usable(now) {
+			if macEqual(computeMAC(key.Secret, payload.canonical(VersionLegacy, "")), mac) {
+				return nil
+			}
+		}
+		return ErrInvalidSignature
+	case VersionKeyed, VersionDigest:
+		key, ok := keys.Lookup(keyID, now)
+		if !ok {
+			return ErrUnknownKey
+		}
+		if !macEqual(computeMAC(key.Secret, payload.canonical(version, key.ID)), mac) {
+			return ErrInvalidSignature
+		}
+		return nil
+	default:
+		return ErrInvalidSignature
+	}
+}
+
+// Signature 网关注入到请求上的签名信息
+type Signature struct {
+	KeyID         string
+	Timestamp     string
+	Nonce         string
+	Value         string
+	ContentDigest string
+	Headers       map[string]string
+}
+
+// Apply 将签名写入请求头
+func (s Signature) Apply(header http.Header) {
+	header.Set(HeaderTimestamp, s.Timestamp)
+	header.Set(HeaderNonce, s.Nonce)
+	header.Set(HeaderSignature, s.Value)
+	if s.KeyID != "" {
+		header.Set(HeaderKeyID, s.KeyID)
+	}
+	if s.ContentDigest != "" {
+		header.Set(HeaderContentDigest, s.ContentDigest)
+	}
+	if len(s.Headers) > 0 {
+		names := signedHeaderNames(s.Headers)
+		for _, name := range names {
+			header.Set(name, s.Headers[name])
+		}
+		header.Set(HeaderSignedHeaders, strings.Join(names, ";"))
+	}
+}
+
+// Signer 使用密钥环中的活动密钥签名
+type Signer struct {
+	Keys    *Keyring
+	Version string // 为空时使用 v2
+}
+
+// SignsContentDigest 当前版本是否需要调用方提供 Query 与 ContentDigest
+func (s *Signer) SignsContentDigest() bool {
+	return s != nil && s.Version == VersionDigest
+}
+
+// Sign 为一次请求生成时间戳、nonce 与签名，payload 中的 Timestamp 与 Nonce 会被覆盖
+// v3 需要调用方填好 Query（原始查询串即可，会被规范化）与 ContentDigest；
+// v1 不支持签名头，payload.Headers 会被忽略
+func (s *Signer) Sign(payload Payload, now time.Time) (Signature, error) {
+	if s == nil {
+		return Signature{}, ErrUnknownKey
+	}
+	return s.sign(s.Version, payload, now)
+}
+
+// SignWithoutDigest 为无法缓冲请求体的请求（流式上传、超过摘要上限的请求体）签名：
+// v3 降级为 v2，不覆盖查询串与请求体；其他版本与 Sign 相同
+func (s *Signer) SignWithoutDigest(payload Payload, now time.Time) (Signature, error) {
+	if s == nil {
+		return Signature{}, ErrUnknownKey
+	}
+	version := s.Version
+	if version == VersionDigest {
+		version = VersionKeyed
+	}
+	return s.sign(version, payload, now)
+}
+
+func (s *Signer) sign(version string, payload Payload, now time.Time) (Signature, error) {
+	if s.Keys == nil {
+		return Signature{}, ErrUnknownKey
+	}
+	nonce, err := randomNonce()
+	if err != nil {
+		return Signature{}, err
+	}
+	if version == "" {
+		version = VersionKeyed
+	}
+	key := s.Keys.Active()
+	payload.Timestamp = strconv.FormatInt(now.Unix(), 10)
+	payload.Nonce = nonce
+	payload.Query = CanonicalQuery(payload.Query)
+	payload.Headers = normalizeHeaders(payload.Headers)
+	if version == VersionLegacy {
+		payload.Headers = nil
+	}
+	signature := Signature{
+		Timestamp: payload.Timestamp,
+		Nonce:     payload.Nonce,
+		Value:     SignPayload(version, key, payload),
+		Headers:   payload.Headers,
+	}
+	if version != VersionLegacy {
+		signature.KeyID = key.ID
+	}
+	if version == VersionDigest {
+		signature.ContentDigest = payload.ContentDigest
+	}
+	return signature, nil
+}
+
+// Verifier 校验请求上的网关身份头，并通过 NonceCache 拒绝重放的请求
+type Verifier struct {
+	Keys      *Keyring
+	ClockSkew time.Duration
+	Nonces    *NonceCache
+
+	// RequireDigest 为 true 时只接受覆盖查询串和请求体的 v3 签名
+	RequireDigest bool
+	// MaxBodyBytes 校验 v3 摘要时最多读取的请求体字节数，0 表示不限制
+	MaxBodyBytes int64
+
+	// Now 仅用于测试替换时钟
+	Now func() time.Time
+}
+
+// VerifierOption 创建校验器时的可选配置
+type VerifierOption func(*Verifier)
+
+// WithNonceCapacity 设置 nonce 缓存容量，应不小于 峰值请求速率（每秒）× 2 × 时间窗口（秒）
+func WithNonceCapacity(capacity int) VerifierOption {
+	return func(v *Verifier) {
+		v.Nonces = NewNonceCache(capacity, 2*v.ClockSkew)
+	}
+}
+
+// NewVerifier 使用单个密钥（对应 GATEWAY_INTERNAL_SECRET）创建校验器
+func NewVerifier(secret string, clockSkew time.Duration, opts ...VerifierOption) *Verifier {
+	keys, err := NewKeyring(DefaultKeyID, Key{ID: DefaultKeyID, Secret: secret})
+	if err != nil {
+		// 空密钥无法通过任何校验，保持空密钥环即可
+		keys = &Keyring{keys: map[string]Key{}}
+	}
+	return NewKeyringVerifier(keys, clockSkew, opts...)
+}
+
+// NewKeyringVerifier 使用密钥环创建校验器，nonce 缓存的保留时间覆盖整个时间窗口
+func NewKeyringVerifier(keys *Keyring, clockSkew time.Duration, opts ...VerifierOption) *Verifier {
+	if clockSkew <= 0 {
+		clockSkew = DefaultClockSkew
+	}
+	v := &Verifier{
+		Keys:      keys,
+		ClockSkew: clockSkew,
+		Nonces:    NewNonceCache(DefaultNonceCapacity, 2*clockSkew),
+	}
+	for _, opt := range opts {
+		opt(v)
+	}
+	return v
+}
+
+// VerifyRequest 校验请求并返回网关认证过的用户 ID
+// v3 签名会读取并还原请求体以核对 Content-Digest
+func (v *Verifier) VerifyRequest(r *http.Request) (string, error) {
+	payload := Payload{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		UserID:    strings.TrimSpace(r.Header.Get(HeaderUserID)),
+		Timestamp: strings.TrimSpace(r.Header.Get(HeaderTimestamp)),
+		Nonce:     strings.TrimSpace(r.Header.Get(HeaderNonce)),
+	}
+	keyID := strings.TrimSpace(r.Header.Get(HeaderKeyID))
+	signature := strings.TrimSpace(r.Header.Get(HeaderSignature))
+	for _, name := range SignedHeaders(r) {
+		if payload.Headers == nil {
+			payload.Headers = make(map[string]string)
+		}
+		payload.Headers[name] = strings.TrimSpace(r.Header.Get(name))
+	}
+
+	version, _ := parseSignature(signature)
+	if v.RequireDigest && version != VersionDigest {
+		return "", ErrVersionTooOld
+	}
+	if version == VersionDigest {
+		claimed := strings.TrimSpace(r.Header.Get(HeaderContentDigest))
+		actual, err := digestAndRestoreBody(r, v.MaxBodyBytes)
+		if err != nil {
+			return "", err
+		}
+		if claimed != actual {
+			return "", ErrDigestMismatch
+		}
+		payload.Query = CanonicalQuery(r.URL.RawQuery)
+		payload.ContentDigest = actual
+	}
+
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if err := VerifyPayload(v.Keys, keyID, payload, signature, now, v.ClockSkew); err != nil {
+		return "", err
+	}
+	// 签名通过后才记录 nonce，避免伪造请求占满缓存；缓存已满时返回 ErrNonceCacheFull
+	if v.Nonces != nil {
+		if err := v.Nonces.Add(payload.Nonce, now); err != nil {
+			if errors.Is(err, ErrNonceCacheFull) {
+				slog.Warn("gatewayidentity: nonce 缓存已满，拒绝签名有效的请求，请调大 WithNonceCapacity",
+					"capacity", v.Nonces.Capacity(),
+					"rejected", v.Nonces.Rejected(),
+				)
+			}
+			return "", err
+		}
+	}
+	return payload.UserID, nil
+}
+
+// SignedHeaders 返回请求声明为已签名的头名（小写）
+// 只有在 VerifyRequest 通过后，这些头的值才可信
+func SignedHeaders(r *http.Request) []string {
+	var names []string
+	for _, name := range strings.Split(r.Header.Get(HeaderSignedHeaders), ";") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SignedHeader 读取经过签名的身份声明头，未在签名列表中的头视为不存在
+func SignedHeader(r *http.Request, name string) (string, bool) {
+	name = strings.ToLower(name)
+	for _, signed := range SignedHeaders(r) {
+		if signed == name {
+			return strings.TrimSpace(r.Header.Get(name)), true
+		}
+	}
+	return "", false
+}
+
+func checkTimestamp(timestamp string, now time.Time, skew time.Duration) error {
+	if skew <= 0 {
+		skew = DefaultClockSkew
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	diff := now.Sub(time.Unix(seconds, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > skew {
+		return ErrExpired
+	}
+	return nil
+}
+
+// parseSignature 拆分 "v2=<hex>" 形式的版本前缀，不带前缀的视为 v1
+func parseSignature(signature string) (string, string) {
+	if version, mac, ok := strings.Cut(signature, "="); ok {
+		return strings.ToLower(version), strings.ToLower(mac)
+	}
+	return VersionLegacy, strings.ToLower(signature)
+}
+
+func computeMAC(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func macEqual(expected string, actual string) bool {
+	return hmac.Equal([]byte(expected), []byte(actual))
+}
+
+func randomNonce() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
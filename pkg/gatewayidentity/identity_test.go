@@ -0,0 +1,373 @@
+package gatewayidentity
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 与 apps/api-gateway TestSignMatchesGatewayPayloadContract 相同的测试向量
+const (
+	vectorSecret    = "secret"
+	vectorMethod    = "GET"
+	vectorPath      = "/api/agent/threads"
+	vectorUserID    = "user-1"
+	vectorTimestamp = "100"
+	vectorNonce     = "nonce"
+	vectorSignature = "87ad54ef7e07b56d76492ec82de23260ce3f30999a6cd69d63bbe28bf07d2be5"
+)
+
+func TestSignMatchesGatewayPayloadContract(t *testing.T) {
+	if got := Sign(vectorSecret, vectorMethod, vectorPath, vectorUserID, vectorTimestamp, vectorNonce); got != vectorSignature {
+		t.Fatalf("expected %s, got %s", vectorSignature, got)
+	}
+}
+
+// v2 测试向量，agent-service 的 TS 实现必须得到相同结果
+func TestSignPayloadV2MatchesContract(t *testing.T) {
+	payload := Payload{Method: vectorMethod, Path: vectorPath, UserID: vectorUserID, Timestamp: vectorTimestamp, Nonce: vectorNonce}
+	expected := "v2=21a7577feaea86f1231e141095719cd4d79b28c5b4339005a39cba170703c370"
+	if got := SignPayload(VersionKeyed, Key{ID: "k1", Secret: vectorSecret}, payload); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestVerifyAcceptsVectorWithinClockSkew(t *testing.T) {
+	now := time.Unix(100, 0).Add(4 * time.Minute)
+	err := Verify(vectorSecret, vectorMethod, vectorPath, vectorUserID, vectorTimestamp, vectorNonce, vectorSignature, now, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("expected vector to verify: %v", err)
+	}
+}
+
+func TestVerifyRejectsTamperedOrExpiredVector(t *testing.T) {
+	inWindow := time.Unix(100, 0)
+	cases := []struct {
+		name      string
+		path      string
+		userID    string
+		timestamp string
+		now       time.Time
+		expected  error
+	}{
+		{"tampered user", vectorPath, "user-2", vectorTimestamp, inWindow, ErrInvalidSignature},
+		{"tampered path", "/api/agents", vectorUserID, vectorTimestamp, inWindow, ErrInvalidSignature},
+		{"expired", vectorPath, vectorUserID, vectorTimestamp, inWindow.Add(6 * time.Minute), ErrExpired},
+		{"future", vectorPath, vectorUserID, vectorTimestamp, inWindow.Add(-6 * time.Minute), ErrExpired},
+		{"bad timestamp", vectorPath, vectorUserID, "abc", inWindow, ErrInvalidTimestamp},
+		{"missing user", vectorPath, "", vectorTimestamp, inWindow, ErrMissingHeaders},
+	}
+	for _, tc := range cases {
+		err := Verify(vectorSecret, vectorMethod, tc.path, tc.userID, tc.timestamp, vectorNonce, vectorSignature, tc.now, 5*time.Minute)
+		if !errors.Is(err, tc.expected) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.expected, err)
+		}
+	}
+}
+
+func TestNonceCacheRejectsReplayAndStaysBounded(t *testing.T) {
+	cache := NewNonceCache(2, time.Minute)
+	now := time.Unix(1000, 0)
+
+	if err := cache.Add("a", now); err != nil {
+		t.Fatalf("expected first use of nonce a to be accepted: %v", err)
+	}
+	if err := cache.Add("a", now); !errors.Is(err, ErrReplayedNonce) {
+		t.Fatalf("expected second use of nonce a to be rejected, got %v", err)
+	}
+	// 过期后 nonce 会被清理
+	if err := cache.Add("a", now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("expected expired nonce to be accepted again: %v", err)
+	}
+}
+
+func TestNonceCacheFailsClosedWhenFull(t *testing.T) {
+	cache := NewNonceCache(2, time.Minute)
+	now := time.Unix(1000, 0)
+	cache.Add("a", now)
+	cache.Add("b", now)
+
+	// 缓存被未过期的 nonce 占满：拒绝新请求，不淘汰 a，a 仍不能重放
+	if err := cache.Add("c", now); !errors.Is(err, ErrNonceCacheFull) {
+		t.Fatalf("expected full cache to reject new nonce, got %v", err)
+	}
+	if err := cache.Add("a", now.Add(time.Second)); !errors.Is(err, ErrReplayedNonce) {
+		t.Fatalf("expected nonce a to still be remembered, got %v", err)
+	}
+	if cache.Len() != 2 || cache.Rejected() != 1 {
+		t.Fatalf("expected 2 entries and 1 rejection, got %d and %d", cache.Len(), cache.Rejected())
+	}
+	// 条目过期后恢复接受新请求
+	if err := cache.Add("c", now.Add(2*time.Minute)); err != nil {
+		t.Fatalf("expected new nonce after expiry to be accepted: %v", err)
+	}
+}
+
+func signedRequest(nonce string) *http.Request {
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/api/agents", nil)
+	req.Header.Set(HeaderUserID, "user-1")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Sign("secret", http.MethodPost, "/api/agents", "user-1", timestamp, nonce))
+	return req
+}
+
+func TestMiddlewareExposesVerifiedUserAndRejectsReplay(t *testing.T) {
+	handler := Middleware(NewVerifier("secret", time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := UserIDFromContext(r.Context())
+		_, _ = w.Write([]byte(userID))
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedRequest("nonce-1"))
+	if rec.Code != http.StatusOK || rec.Body.String() != "user-1" {
+		t.Fatalf("expected verified user-1, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedRequest("nonce-1"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed nonce to be rejected, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/agents", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unsigned request to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestMiddlewareReturns503WhenNonceCacheIsFull(t *testing.T) {
+	verifier := NewVerifier("secret", time.Minute, WithNonceCapacity(1))
+	if verifier.Nonces.Capacity() != 1 || verifier.Nonces.ttl != 2*time.Minute {
+		t.Fatalf("expected nonce cache sized by option, got %d %v", verifier.Nonces.Capacity(), verifier.Nonces.ttl)
+	}
+	handler := Middleware(verifier)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedRequest("nonce-1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected first request to pass, got %d", rec.Code)
+	}
+	// 缓存已满是过载，返回 503 而不是签名错误的 401
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedRequest("nonce-2"))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "nonce cache full") {
+		t.Fatalf("expected 503 for a full nonce cache, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestKeyedSignatureSupportsRotationWithGracePeriod(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+	keys, err := NewKeyring("k2",
+		Key{ID: "k2", Secret: "new-secret"},
+		Key{ID: "k1", Secret: "old-secret", NotAfter: now.Add(time.Hour)},
+	)
+	if err != nil {
+		t.Fatalf("expected keyring to build: %v", err)
+	}
+	payload := Payload{Method: "GET", Path: "/api/agents", UserID: "user-1", Timestamp: strconv.FormatInt(now.Unix(), 10), Nonce: "n"}
+
+	// 旧网关实例仍使用 k1 签名，宽限期内可以通过
+	old := SignPayload(VersionKeyed, Key{ID: "k1", Secret: "old-secret"}, payload)
+	if err := VerifyPayload(keys, "k1", payload, old, now, time.Minute); err != nil {
+		t.Fatalf("expected k1 signature to verify during grace period: %v", err)
+	}
+	// 宽限期结束后拒绝
+	later := now.Add(2 * time.Hour)
+	payload.Timestamp = strconv.FormatInt(later.Unix(), 10)
+	old = SignPayload(VersionKeyed, Key{ID: "k1", Secret: "old-secret"}, payload)
+	if err := VerifyPayload(keys, "k1", payload, old, later, time.Minute); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected retired key to be rejected, got %v", err)
+	}
+	// 密钥 ID 与签名密钥不匹配
+	if err := VerifyPayload(keys, "k2", payload, old, later, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected mismatched key id to be rejected, got %v", err)
+	}
+	// 不带前缀的 v1 签名仍然可用
+	legacy := Sign("new-secret", payload.Method, payload.Path, payload.UserID, payload.Timestamp, payload.Nonce)
+	if err := VerifyPayload(keys, "", payload, legacy, later, time.Minute); err != nil {
+		t.Fatalf("expected legacy signature to verify: %v", err)
+	}
+}
+
+func TestSignerUsesActiveKeyAndVerifierAcceptsIt(t *testing.T) {
+	keys, _ := NewKeyring("k2", Key{ID: "k2", Secret: "new-secret"}, Key{ID: "k1", Secret: "old-secret"})
+	signer := &Signer{Keys: keys}
+
+	signature, err := signer.Sign(Payload{Method: http.MethodPost, Path: "/api/agents", UserID: "user-1"}, time.Now())
+	if err != nil {
+		t.Fatalf("expected signing to succeed: %v", err)
+	}
+	if signature.KeyID != "k2" || signature.Value[:3] != "v2=" {
+		t.Fatalf("expected v2 signature with k2, got %+v", signature)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/agents", nil)
+	req.Header.Set(HeaderUserID, "user-1")
+	signature.Apply(req.Header)
+	if userID, err := NewKeyringVerifier(keys, time.Minute).VerifyRequest(req); err != nil || userID != "user-1" {
+		t.Fatalf("expected signed request to verify, got %q %v", userID, err)
+	}
+}
+
+func TestLoadKeyringFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"active":"k2","keys":[{"id":"k2","secret":"a"},{"id":"k1","secret":"b","notAfter":"2000-01-01T00:00:00Z"}]}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	keys, err := LoadKeyringFile(path)
+	if err != nil {
+		t.Fatalf("expected key file to load: %v", err)
+	}
+	if keys.Active().ID != "k2" {
+		t.Fatalf("expected k2 to be active, got %q", keys.Active().ID)
+	}
+	if _, ok := keys.Lookup("k1", time.Now()); ok {
+		t.Fatal("expected retired k1 to be unavailable")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"active":"missing","keys":[]}`), 0o600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+	if _, err := LoadKeyringFile(path); err == nil {
+		t.Fatal("expected key file without active key to be rejected")
+	}
+}
+
+func TestCanonicalQuerySortsKeysAndValues(t *testing.T) {
+	got := CanonicalQuery("b=2&a=z%20y&b=1&c")
+	if expected := "a=z+y&b=1&b=2&c="; got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+// v3 测试向量，agent-service 的 TS 实现必须得到相同结果
+func TestSignPayloadV3MatchesContract(t *testing.T) {
+	payload := Payload{
+		Method: "POST", Path: vectorPath, Query: CanonicalQuery("limit=10&cursor=a b"), UserID: vectorUserID,
+		Timestamp: vectorTimestamp, Nonce: vectorNonce, ContentDigest: DigestBytes([]byte(`{"title":"hi"}`)),
+	}
+	if payload.ContentDigest != "sha-256=:FZaM6hzgpRMdysYuTNVbGTQFBLu2YGDxKLeT/N4syLg=:" {
+		t.Fatalf("unexpected content digest %s", payload.ContentDigest)
+	}
+	expected := "v3=d8e22b2f1b054eef5e27ecaa3337f93ba4521bec6969318941e9c21e5f4465f3"
+	if got := SignPayload(VersionDigest, Key{ID: "k1", Secret: vectorSecret}, payload); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestVerifierChecksQueryAndBodyDigest(t *testing.T) {
+	keys, _ := NewKeyring("k1", Key{ID: "k1", Secret: "secret"})
+	signer := &Signer{Keys: keys, Version: VersionDigest}
+	body := `{"title":"hi"}`
+
+	newRequest := func(target string, sentBody string) *http.Request {
+		signature, err := signer.Sign(Payload{
+			Method: http.MethodPost, Path: "/api/agents", Query: "b=2&a=1", UserID: "user-1",
+			ContentDigest: DigestBytes([]byte(body)),
+		}, time.Now())
+		if err != nil {
+			t.Fatalf("expected signing to succeed: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(sentBody))
+		req.Header.Set(HeaderUserID, "user-1")
+		signature.Apply(req.Header)
+		return req
+	}
+
+	verifier := NewKeyringVerifier(keys, time.Minute)
+	req := newRequest("/api/agents?a=1&b=2", body)
+	if _, err := verifier.VerifyRequest(req); err != nil {
+		t.Fatalf("expected v3 request to verify: %v", err)
+	}
+	// 请求体被还原，后续处理器仍能读取
+	if data, _ := io.ReadAll(req.Body); string(data) != body {
+		t.Fatalf("expected body to be restored, got %q", data)
+	}
+	if _, err := verifier.VerifyRequest(newRequest("/api/agents?a=1&b=3", body)); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected tampered query to be rejected, got %v", err)
+	}
+	if _, err := verifier.VerifyRequest(newRequest("/api/agents?a=1&b=2", `{"title":"no"}`)); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected tampered body to be rejected, got %v", err)
+	}
+
+	strict := NewKeyringVerifier(keys, time.Minute)
+	strict.RequireDigest = true
+	if _, err := strict.VerifyRequest(signedRequest("nonce-strict")); !errors.Is(err, ErrVersionTooOld) {
+		t.Fatalf("expected v1 signature to be rejected in strict mode, got %v", err)
+	}
+
+	// 流式请求不计算摘要，降级为 v2
+	signature, err := signer.SignWithoutDigest(Payload{Method: http.MethodPost, Path: "/api/agents", UserID: "user-1"}, time.Now())
+	if err != nil || signature.ContentDigest != "" {
+		t.Fatalf("expected a v2 signature without digest, got %+v, %v", signature, err)
+	}
+	streamed := httptest.NewRequest(http.MethodPost, "/api/agents?a=1", strings.NewReader(body))
+	streamed.Header.Set(HeaderUserID, "user-1")
+	signature.Apply(streamed.Header)
+	if _, err := verifier.VerifyRequest(streamed); err != nil {
+		t.Fatalf("expected downgraded signature to verify: %v", err)
+	}
+	if _, err := strict.VerifyRequest(streamed); !errors.Is(err, ErrVersionTooOld) {
+		t.Fatalf("expected downgraded signature to be rejected in strict mode, got %v", err)
+	}
+}
+
+// 签名头测试向量，agent-service 的 TS 实现必须得到相同结果
+func TestSignPayloadWithSignedHeadersMatchesContract(t *testing.T) {
+	payload := Payload{
+		Method: vectorMethod, Path: vectorPath, UserID: vectorUserID, Timestamp: vectorTimestamp, Nonce: vectorNonce,
+		Headers: map[string]string{"x-user-roles": "admin,member", "x-user-email": "a@example.com"},
+	}
+	expected := "v2=23a39c62178349302338f8396093350b727d5363d35b26fb3bca4c4347726ddd"
+	if got := SignPayload(VersionKeyed, Key{ID: "k1", Secret: vectorSecret}, payload); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestVerifierCoversSignedHeaders(t *testing.T) {
+	keys, _ := NewKeyring("k1", Key{ID: "k1", Secret: "secret"})
+	signer := &Signer{Keys: keys}
+	sign := func() *http.Request {
+		signature, err := signer.Sign(Payload{
+			Method: http.MethodGet, Path: "/api/agents", UserID: "user-1",
+			Headers: map[string]string{"X-User-Email": "a@example.com"},
+		}, time.Now())
+		if err != nil {
+			t.Fatalf("expected signing to succeed: %v", err)
+		}
+		req := httptest.NewRequest(http.MethodGet, "/api/agents", nil)
+		req.Header.Set(HeaderUserID, "user-1")
+		signature.Apply(req.Header)
+		return req
+	}
+	verifier := NewKeyringVerifier(keys, time.Minute)
+
+	req := sign()
+	if _, err := verifier.VerifyRequest(req); err != nil {
+		t.Fatalf("expected signed headers to verify: %v", err)
+	}
+	if email, ok := SignedHeader(req, "X-User-Email"); !ok || email != "a@example.com" {
+		t.Fatalf("expected signed email, got %q %v", email, ok)
+	}
+	if _, ok := SignedHeader(req, "X-User-Roles"); ok {
+		t.Fatal("expected unsigned header to be ignored")
+	}
+
+	req = sign()
+	req.Header.Set("X-User-Email", "b@example.com")
+	if _, err := verifier.VerifyRequest(req); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected tampered claim to be rejected, got %v", err)
+	}
+}
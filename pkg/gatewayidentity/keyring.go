@@ -0,0 +1,163 @@
+package gatewayidentity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultKeyID 未显式配置密钥 ID 时 GATEWAY_INTERNAL_SECRET 对应的 ID
+const DefaultKeyID = "default"
+
+var ErrUnknownKey = errors.New("gatewayidentity: unknown or retired signing key")
+
+// Key 一个签名密钥。NotAfter 非零时表示该密钥在此之后不再被接受，
+// 用于轮换时给旧密钥留出宽限期
+type Key struct {
+	ID       string    `json:"id"`
+	Secret   string    `json:"secret"`
+	NotAfter time.Time `json:"notAfter,omitempty"`
+}
+
+func (k Key) usable(now time.Time) bool {
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter)
+}
+
+// Keyring 保存一组签名密钥，其中 active 指向网关当前用于签名的密钥
+// 轮换流程：先把新密钥加入所有服务的密钥文件，再把网关的 active 切换为新密钥，
+// 最后为旧密钥设置 notAfter 或将其删除
+type Keyring struct {
+	mu     sync.RWMutex
+	active string
+	keys   map[string]Key
+
+	// 从文件加载时用于自动重新加载
+	path        string
+	modTime     time.Time
+	lastChecked time.Time
+}
+
+const keyringFileCheckInterval = 5 * time.Second
+
+// NewKeyring 使用给定密钥创建密钥环，active 必须是其中之一
+func NewKeyring(active string, keys ...Key) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.set(active, keys); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// LoadKeyringFile 从 JSON 文件加载密钥环，文件修改后会自动重新加载
+// 文件格式：{"active":"k2","keys":[{"id":"k2","secret":"..."},{"id":"k1","secret":"...","notAfter":"2026-11-01T00:00:00Z"}]}
+func LoadKeyringFile(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Active 返回当前用于签名的密钥
+func (k *Keyring) Active() Key {
+	k.reloadIfChanged()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[k.active]
+}
+
+// Lookup 按 ID 查找仍在有效期内的密钥
+func (k *Keyring) Lookup(id string, now time.Time) (Key, bool) {
+	k.reloadIfChanged()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[id]
+	if !ok || !key.usable(now) {
+		return Key{}, false
+	}
+	return key, true
+}
+
+// Usable 返回所有仍在有效期内的密钥，用于校验不带密钥 ID 的旧版签名
+func (k *Keyring) Usable(now time.Time) []Key {
+	k.reloadIfChanged()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]Key, 0, len(k.keys))
+	for _, key := range k.keys {
+		if key.usable(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (k *Keyring) set(active string, keys []Key) error {
+	byID := make(map[string]Key, len(keys))
+	for _, key := range keys {
+		if key.ID == "" || key.Secret == "" {
+			return fmt.Errorf("gatewayidentity: key %q must have id and secret", key.ID)
+		}
+		byID[key.ID] = key
+	}
+	if _, ok := byID[active]; !ok {
+		return fmt.Errorf("gatewayidentity: active key %q not found", active)
+	}
+	k.mu.Lock()
+	k.active = active
+	k.keys = byID
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *Keyring) reloadIfChanged() {
+	if k.path == "" {
+		return
+	}
+	k.mu.RLock()
+	due := time.Since(k.lastChecked) >= keyringFileCheckInterval
+	k.mu.RUnlock()
+	if !due {
+		return
+	}
+
+	info, err := os.Stat(k.path)
+	k.mu.Lock()
+	k.lastChecked = time.Now()
+	unchanged := err != nil || info.ModTime().Equal(k.modTime)
+	k.mu.Unlock()
+	if unchanged {
+		return
+	}
+	// 文件写坏时继续使用旧密钥，避免全部请求失败
+	_ = k.reload()
+}
+
+func (k *Keyring) reload() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return fmt.Errorf("gatewayidentity: read key file: %w", err)
+	}
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return fmt.Errorf("gatewayidentity: read key file: %w", err)
+	}
+	var file struct {
+		Active string `json:"active"`
+		Keys   []Key  `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("gatewayidentity: parse key file: %w", err)
+	}
+	if err := k.set(file.Active, file.Keys); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.modTime = info.ModTime()
+	k.lastChecked = time.Now()
+	k.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,61 @@
+package gatewayidentity
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ContextKeyUserID Echo/Gin 上下文中保存用户 ID 的键，见 echoidentity、ginidentity
+const ContextKeyUserID = "gatewayUserID"
+
+type contextKey struct{}
+
+// WithUserID 将网关认证过的用户 ID 写入 context
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, userID)
+}
+
+// UserIDFromContext 读取网关认证过的用户 ID
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(contextKey{}).(string)
+	return userID, ok && userID != ""
+}
+
+// UnauthorizedBody 校验失败时的响应体，与 agent-service 的未认证响应保持一致
+var UnauthorizedBody = map[string]any{
+	"code":    http.StatusUnauthorized,
+	"message": "unauthorized",
+}
+
+// OverloadedBody nonce 缓存已满时的响应体，与签名错误区分，便于区分过载与伪造
+var OverloadedBody = map[string]any{
+	"code":    http.StatusServiceUnavailable,
+	"message": "nonce cache full",
+}
+
+// ErrorResponse 校验失败时的状态码与响应体：nonce 缓存已满返回 503，其余返回 401
+func ErrorResponse(err error) (int, map[string]any) {
+	if errors.Is(err, ErrNonceCacheFull) {
+		return http.StatusServiceUnavailable, OverloadedBody
+	}
+	return http.StatusUnauthorized, UnauthorizedBody
+}
+
+// Middleware 返回 net/http 中间件，校验失败时按 ErrorResponse 返回 401 或 503
+func Middleware(v *Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, err := v.VerifyRequest(r)
+			if err != nil {
+				status, body := ErrorResponse(err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				_ = json.NewEncoder(w).Encode(body)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), userID)))
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package gatewayidentity
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultNonceCapacity nonce 缓存默认容量，时间窗口为 300 秒时可承受约 166 req/s 的持续请求
+const DefaultNonceCapacity = 100000
+
+// ErrNonceCacheFull nonce 缓存已被未过期的条目占满，无法确认请求不是重放，拒绝请求
+// 这是过载而不是伪造，中间件返回 503
+var ErrNonceCacheFull = errors.New("gatewayidentity: nonce cache full")
+
+// NonceCache 记录时间窗口内见过的 nonce，容量有界
+// 过期的 nonce 按写入顺序删除；容量被未过期的条目占满时拒绝新请求（fail closed），
+// 而不是淘汰仍在有效期内的 nonce，否则突发的请求可以把 nonce 挤出缓存后重放
+type NonceCache struct {
+	capacity int
+	ttl      time.Duration
+
+	mu       sync.Mutex
+	seen     map[string]time.Time
+	order    []string
+	head     int
+	entries  int
+	rejected uint64
+}
+
+// NewNonceCache 创建 nonce 缓存，ttl 应不小于签名允许的时间窗口
+func NewNonceCache(capacity int, ttl time.Duration) *NonceCache {
+	if capacity <= 0 {
+		capacity = DefaultNonceCapacity
+	}
+	return &NonceCache{
+		capacity: capacity,
+		ttl:      ttl,
+		seen:     make(map[string]time.Time, capacity),
+		order:    make([]string, capacity),
+	}
+}
+
+// Add 记录 nonce；nonce 已在有效期内出现过时返回 ErrReplayedNonce，缓存已满时返回 ErrNonceCacheFull
+func (c *NonceCache) Add(nonce string, now time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpired(now)
+
+	if expiresAt, ok := c.seen[nonce]; ok && now.Before(expiresAt) {
+		return ErrReplayedNonce
+	}
+	if c.entries == c.capacity {
+		c.rejected++
+		return ErrNonceCacheFull
+	}
+	tail := (c.head + c.entries) % c.capacity
+	c.order[tail] = nonce
+	c.entries++
+	c.seen[nonce] = now.Add(c.ttl)
+	return nil
+}
+
+// Rejected 返回因缓存已满被拒绝的请求数，可用于监控和告警
+func (c *NonceCache) Rejected() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rejected
+}
+
+// Capacity 返回缓存容量
+func (c *NonceCache) Capacity() int {
+	return c.capacity
+}
+
+// Len 返回当前缓存的 nonce 数量
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries
+}
+
+func (c *NonceCache) evictExpired(now time.Time) {
+	for c.entries > 0 {
+		oldest := c.order[c.head]
+		if expiresAt, ok := c.seen[oldest]; ok && now.Before(expiresAt) {
+			return
+		}
+		c.evictOldest()
+	}
+}
+
+func (c *NonceCache) evictOldest() {
+	oldest := c.order[c.head]
+	delete(c.seen, oldest)
+	c.order[c.head] = ""
+	c.head = (c.head + 1) % c.capacity
+	c.entries--
+}
@@ -86,6 +86,9 @@ export const config = {
   gatewayInternalSecret:
     process.env.GATEWAY_INTERNAL_SECRET ||
     'dev-gateway-internal-secret-change-me',
+  gatewaySigningKeyId: process.env.GATEWAY_SIGNING_KEY_ID || 'default',
+  // 多密钥文件（轮换用），格式与网关 GATEWAY_SIGNING_KEYS_FILE 相同
+  gatewaySigningKeysFile: process.env.GATEWAY_SIGNING_KEYS_FILE || '',
   authClockSkewSeconds: parseInt(
     process.env.AUTH_CLOCK_SKEW_SECONDS || '300',
     10
@@ -1,27 +1,168 @@
-import { createHmac, timingSafeEqual } from 'node:crypto'
+import { createHash, createHmac, timingSafeEqual } from 'node:crypto'
+import { readFileSync, statSync } from 'node:fs'
 import type { Context, Next } from 'hono'
 import { config } from '../config/index.js'
+import { logger } from '../config/logger.js'
 import { ANONYMOUS_OWNER_ID } from '../services/session.js'
 
 const USER_ID_KEY = 'currentUserId'
+const KEY_FILE_CHECK_INTERVAL_MS = 5000
 
-function signIdentity(options: {
+// 与 pkg/gatewayidentity 的密钥文件格式一致；notAfter 之后旧密钥不再被接受
+interface SigningKey {
+  id: string
+  secret: string
+  notAfter?: string
+}
+
+let keyFileCache: {
+  mtimeMs: number
+  checkedAt: number
+  keys: SigningKey[]
+} | null = null
+
+function loadSigningKeys(): SigningKey[] {
+  const path = config.gatewaySigningKeysFile
+  if (!path) {
+    return [
+      { id: config.gatewaySigningKeyId, secret: config.gatewayInternalSecret },
+    ]
+  }
+
+  const now = Date.now()
+  if (keyFileCache && now - keyFileCache.checkedAt < KEY_FILE_CHECK_INTERVAL_MS) {
+    return keyFileCache.keys
+  }
+  try {
+    const { mtimeMs } = statSync(path)
+    if (!keyFileCache || keyFileCache.mtimeMs !== mtimeMs) {
+      const parsed = JSON.parse(readFileSync(path, 'utf8')) as {
+        keys?: SigningKey[]
+      }
+      const keys = (parsed.keys ?? []).filter(key => key?.id && key?.secret)
+      keyFileCache = { mtimeMs, checkedAt: now, keys }
+    } else {
+      keyFileCache.checkedAt = now
+    }
+  } catch (error) {
+    // 文件写坏时继续使用上一次加载成功的密钥
+    logger.warn({ error, path }, 'Failed to load gateway signing keys')
+    if (!keyFileCache) return []
+    keyFileCache.checkedAt = now
+  }
+  return keyFileCache.keys
+}
+
+function usableSigningKeys(nowMs: number) {
+  return loadSigningKeys().filter(
+    key => !key.notAfter || Date.parse(key.notAfter) > nowMs
+  )
+}
+
+function computeMac(secret: string, fields: string[]) {
+  return createHmac('sha256', secret).update(fields.join('\n')).digest('hex')
+}
+
+// 与 Go url.QueryEscape 一致：空格编码为 +，!'()* 也需要转义
+function queryEscape(value: string) {
+  return encodeURIComponent(value)
+    .replace(/[!'()*]/g, char => `%${char.charCodeAt(0).toString(16).toUpperCase()}`)
+    .replace(/%20/g, '+')
+}
+
+// 与 gatewayidentity.CanonicalQuery 一致：按键、值排序后重新编码
+function canonicalQuery(rawQuery: string) {
+  if (!rawQuery) return ''
+  const values = new Map<string, string[]>()
+  for (const [key, value] of new URLSearchParams(rawQuery)) {
+    values.set(key, [...(values.get(key) ?? []), value])
+  }
+  return [...values.keys()]
+    .sort()
+    .flatMap(key =>
+      values
+        .get(key)!
+        .sort()
+        .map(value => `${queryEscape(key)}=${queryEscape(value)}`)
+    )
+    .join('&')
+}
+
+function contentDigest(body: ArrayBuffer) {
+  const sum = createHash('sha256').update(Buffer.from(body)).digest('base64')
+  return `sha-256=:${sum}:`
+}
+
+function signatureVersion(signature: string) {
+  const separator = signature.indexOf('=')
+  return separator === -1 ? 'v1' : signature.slice(0, separator).toLowerCase()
+}
+
+// 签名格式见 pkg/gatewayidentity：
+// v1 为不带前缀的十六进制；v2 为 "v2=<hex>"，payload 以版本号和密钥 ID 开头；
+// v3 在 v2 基础上增加规范化查询串和请求体摘要；
+// v2、v3 存在签名头时，末尾按头名排序追加 "name:value" 行
+function verifySignature(options: {
+  signature: string
+  keyId: string | undefined
   method: string
   path: string
   userId: string
   timestamp: string
   nonce: string
+  query?: string
+  contentDigest?: string
+  signedHeaders: Array<[string, string]>
 }) {
-  const payload = [
+  const fields = [
     options.method,
     options.path,
     options.userId,
     options.timestamp,
     options.nonce,
-  ].join('\n')
-  return createHmac('sha256', config.gatewayInternalSecret)
-    .update(payload)
-    .digest('hex')
+  ]
+  const keys = usableSigningKeys(Date.now())
+  const separator = options.signature.indexOf('=')
+
+  const headerFields = [...options.signedHeaders]
+    .sort(([a], [b]) => (a < b ? -1 : a > b ? 1 : 0))
+    .map(([name, value]) => `${name}:${value}`)
+
+  if (separator === -1) {
+    if (headerFields.length > 0) return false
+    const mac = options.signature.toLowerCase()
+    return keys.some(key => safeEqual(computeMac(key.secret, fields), mac))
+  }
+
+  const version = options.signature.slice(0, separator).toLowerCase()
+  const mac = options.signature.slice(separator + 1).toLowerCase()
+  if (!options.keyId) return false
+  const key = keys.find(candidate => candidate.id === options.keyId)
+  if (!key) return false
+  if (version === 'v2') {
+    return safeEqual(
+      computeMac(key.secret, ['v2', key.id, ...fields, ...headerFields]),
+      mac
+    )
+  }
+  if (version === 'v3' && options.contentDigest !== undefined) {
+    return safeEqual(
+      computeMac(key.secret, [
+        'v3',
+        key.id,
+        options.method,
+        options.path,
+        options.query ?? '',
+        options.userId,
+        options.timestamp,
+        options.nonce,
+        options.contentDigest,
+        ...headerFields,
+      ]),
+      mac
+    )
+  }
+  return false
 }
 
 function safeEqual(a: string, b: string) {
@@ -70,14 +211,37 @@ export async function gatewayIdentityMiddleware(c: Context, next: Next) {
     return unauthorized(c)
   }
 
-  const expected = signIdentity({
+  // v3 签名覆盖查询串和请求体；Hono 会缓存读取过的请求体，后续处理器仍可读取
+  let query: string | undefined
+  let digest: string | undefined
+  if (signatureVersion(signature) === 'v3') {
+    digest = contentDigest(await c.req.arrayBuffer())
+    if (digest !== c.req.header('Content-Digest')?.trim()) {
+      return unauthorized(c)
+    }
+    query = canonicalQuery(new URL(c.req.url).search.slice(1))
+  }
+
+  // 经过签名的身份声明头（如 X-User-Email），只有校验通过后才可信
+  const signedHeaders = (c.req.header('X-Gateway-Signed-Headers') ?? '')
+    .split(';')
+    .map(name => name.trim().toLowerCase())
+    .filter((name, index, all) => name && all.indexOf(name) === index)
+    .map(name => [name, c.req.header(name)?.trim() ?? ''] as [string, string])
+
+  const valid = verifySignature({
+    signature,
+    keyId: c.req.header('X-Gateway-Key-ID')?.trim(),
     method: c.req.method,
     path: c.req.path,
     userId,
     timestamp,
     nonce,
+    query,
+    contentDigest: digest,
+    signedHeaders,
   })
-  if (!safeEqual(expected, signature)) {
+  if (!valid) {
     return unauthorized(c)
   }
 